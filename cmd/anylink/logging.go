@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/DanielcoderX/anylink/internal/logger"
+)
+
+// flagSet reports whether a CLI flag was given explicitly
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// configureLogging applies levels, module overrides and sinks from cfg.
+// It is safe to call again at runtime (SIGHUP reload).
+func configureLogging(cfg *config.Config) error {
+	level := cfg.Verbose
+	if cfg.Logging.Level != "" && !flagSet("verbose") {
+		level = cfg.Logging.Level
+	}
+
+	outs := make([]logger.Output, 0, len(cfg.Logging.Outputs))
+	for _, o := range cfg.Logging.Outputs {
+		lv := logger.LevelTrace
+		if o.Level != "" {
+			var ok bool
+			if lv, ok = logger.ParseLevel(o.Level); !ok {
+				closeOutputs(outs)
+				return fmt.Errorf("output %s: unknown level %q", o.Type, o.Level)
+			}
+		}
+		sink, err := newSink(o)
+		if err != nil {
+			closeOutputs(outs)
+			return err
+		}
+		outs = append(outs, logger.Output{Sink: sink, Level: lv})
+	}
+	if err := logger.SetModuleLevels(cfg.Logging.Modules); err != nil {
+		closeOutputs(outs)
+		return err
+	}
+
+	logger.SetGlobalLevel(level)
+	logger.SetOutputs(outs...)
+	return nil
+}
+
+// closeOutputs releases sinks opened by a configureLogging call that failed
+func closeOutputs(outs []logger.Output) {
+	for _, o := range outs {
+		_ = o.Sink.Close()
+	}
+}
+
+func newSink(o config.LogOutput) (logger.Sink, error) {
+	switch strings.ToLower(o.Type) {
+	case "", "stderr":
+		return logger.NewStderrSink(), nil
+	case "file":
+		return logger.NewFileSink(logger.FileOptions{
+			Path:       o.Path,
+			MaxSize:    int64(o.MaxSizeMB) << 20,
+			MaxAge:     o.MaxAge,
+			MaxBackups: o.MaxBackups,
+			Compress:   o.Compress,
+		})
+	case "syslog":
+		return logger.NewSyslogSink(o.Path, o.Tag)
+	}
+	return nil, fmt.Errorf("unknown log output type %q", o.Type)
+}
+
+// reloadLogging re-reads the config file and re-applies its logging section
+func reloadLogging(cfg *config.Config) error {
+	next := &config.Config{ConfigPath: cfg.ConfigPath, Verbose: cfg.Verbose}
+	if err := config.Load(next); err != nil {
+		return err
+	}
+	return configureLogging(next)
+}
@@ -11,22 +11,39 @@ import (
 	"github.com/DanielcoderX/anylink/internal/config"
 	"github.com/DanielcoderX/anylink/internal/logger"
 	"github.com/DanielcoderX/anylink/internal/server"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	_ "github.com/DanielcoderX/anylink/internal/xconnect" // RFC 8441 WebSockets over HTTP/2
 )
 
 func Parse() *config.Config {
 	var cfg config.Config
 	flag.StringVar(&cfg.Addr, "addr", ":8080", "HTTP listen address")
 	flag.StringVar(&cfg.QUICAddr, "quic", ":4242", "QUIC listen address")
+	flag.DurationVar(&cfg.ReadTimeout, "timeout", 60*time.Second, "idle timeout for tunnels; the config file's timeout wins")
+	flag.StringVar(&cfg.AdminAddr, "admin", "", "admin API listen address (e.g. 127.0.0.1:9090); disabled when empty")
 	flag.BoolVar(&cfg.RunTest, "selftest", false, "run WS+QUIC self-test and exit")
 	flag.StringVar(&cfg.Verbose, "verbose", "debug", "logging level: quiet|error|info|debug|trace")
+	flag.StringVar(&cfg.ConfigPath, "config", "", "Path to YAML/JSON/TOML configuration file")
 	flag.Parse()
 	return &cfg
 }
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicy(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
 	cfg := Parse()
 
-	// Initialize logger with verbose level
-	logger.SetGlobalLevel(cfg.Verbose)
+	if err := config.Load(cfg); err != nil {
+		logger.Fatalf("❌ Failed to load config file: %v", err)
+	}
+
+	// Initialize logger with verbose level, module overrides and outputs
+	if err := configureLogging(cfg); err != nil {
+		logger.Fatalf("❌ Logging setup failed: %v", err)
+	}
 
 	if cfg.RunTest {
 		if err := server.RunSelfTest(cfg); err != nil {
@@ -35,6 +52,12 @@ func main() {
 		return
 	}
 
+	// OpenTelemetry span export (no-op unless tracing.enable)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatalf("❌ Tracing setup failed: %v", err)
+	}
+
 	// Start server
 	srv := server.New(cfg)
 
@@ -50,6 +73,19 @@ func main() {
 
 	logger.Info("🌐 AnyLink listening on %s (press Ctrl+C to stop)", cfg.Addr)
 
+	// SIGHUP re-reads the logging section without restarting
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadLogging(cfg); err != nil {
+				logger.Error("❌ Logging reload failed: %v", err)
+				continue
+			}
+			logger.Info("🔄 Logging configuration reloaded")
+		}
+	}()
+
 	<-stop
 	logger.Info("🛑 Shutting down...")
 
@@ -59,6 +95,10 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Fatalf("❌ Graceful shutdown failed: %v", err)
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("❌ Tracing flush failed: %v", err)
+	}
 
 	logger.Info("✅ Shutdown complete.")
+	logger.SetOutputs() // flush and close log sinks
 }
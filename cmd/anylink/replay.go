@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/recording"
+)
+
+const replayUsage = "usage: anylink replay [-speed n] [-max-idle d] [-input] [-list] <recording>"
+
+// runReplay implements "anylink replay": it plays a session recording back
+// to stdout at the recorded pace, or lists its events. Exit status is 0 on
+// success, 1 if the recording cannot be read and 2 on usage errors.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "playback speed multiplier")
+	maxIdle := fs.Duration("max-idle", 2*time.Second, "cap on pauses between events (0 keeps them)")
+	input := fs.Bool("input", false, "also play client input")
+	list := fs.Bool("list", false, "list events with sizes instead of playing them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *speed <= 0 {
+		fs.Usage()
+		return 2
+	}
+	r, err := recording.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer r.Close()
+
+	m := r.Meta
+	fmt.Fprintf(os.Stderr, "🎬 %s recording of %s via %s from %s, started %s\n",
+		r.Format, m.Target, m.Transport, m.Client, m.Start.Format(time.RFC3339))
+	if *list {
+		err = listEvents(os.Stdout, r)
+	} else {
+		err = recording.Play(os.Stdout, r, recording.PlayOptions{Speed: *speed, MaxIdle: *maxIdle, Input: *input})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// listEvents prints one line per event: offset, direction, size and the
+// start of the data
+func listEvents(w io.Writer, r *recording.Reader) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "TIME\tDIR\tBYTES\tDATA")
+	dirs := map[byte]string{'o': "out", 'i': "in", 'm': "mark"}
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data := ev.Data
+		if len(data) > 32 {
+			data = data[:32]
+		}
+		fmt.Fprintf(tw, "%.3f\t%s\t%d\t%s\n", ev.Time.Seconds(), dirs[ev.Type], len(ev.Data), strconv.Quote(string(data)))
+	}
+}
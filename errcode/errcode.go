@@ -0,0 +1,135 @@
+// Package errcode defines why AnyLink ended a tunnel. The same numbers are
+// sent as WebSocket close codes, QUIC and WebTransport stream and
+// connection error codes, and in mux CLOSE frames, so a client can tell its
+// user what happened. The From* helpers decode them on the client side.
+package errcode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+)
+
+// Code is a tunnel close reason
+type Code uint16
+
+const (
+	Normal         Code = 0    // either side finished; WS close 1000, QUIC FIN
+	BackendRefused Code = 4001 // the target could not be reached
+	BackendReset   Code = 4002 // the backend connection failed mid-stream
+	IdleTimeout    Code = 4003 // no traffic for the configured timeout
+	PolicyDenied   Code = 4004 // the target policy refused the target
+	ServerDraining Code = 4005 // the server is shutting down
+	RateLimited    Code = 4006 // a connection or stream limit was reached
+	ProtocolError  Code = 4007 // the client broke the framing or flow control
+	SessionExpired Code = 4008 // the tunnel reached its maximum duration
+	RecordFailed   Code = 4009 // a required session recording could not be started
+)
+
+var names = map[Code]string{
+	Normal:         "normal",
+	BackendRefused: "backend refused",
+	BackendReset:   "backend reset",
+	IdleTimeout:    "idle timeout",
+	PolicyDenied:   "policy denied",
+	ServerDraining: "server draining",
+	RateLimited:    "rate limited",
+	ProtocolError:  "protocol error",
+	SessionExpired: "session expired",
+	RecordFailed:   "recording failed",
+}
+
+func (c Code) String() string {
+	if n, ok := names[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("code %d", uint16(c))
+}
+
+// WSCloseCode is the WebSocket close code for c
+func (c Code) WSCloseCode() int {
+	if c == Normal {
+		return websocket.CloseNormalClosure
+	}
+	return int(c)
+}
+
+// Error attaches a close reason to an error
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap returns err with code attached; nil stays nil
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Of returns the code attached to err by Wrap, or def
+func Of(err error, def Code) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return def
+}
+
+// FromWS returns the code in the close frame behind a WebSocket read error
+func FromWS(err error) (Code, bool) {
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) {
+		return 0, false
+	}
+	if ce.Code == websocket.CloseNormalClosure {
+		return Normal, true
+	}
+	if ce.Code >= 4000 && ce.Code <= 4999 {
+		return Code(ce.Code), true
+	}
+	return 0, false
+}
+
+// FromQUIC returns the code of a QUIC stream reset or connection close
+func FromQUIC(err error) (Code, bool) {
+	var se *quic.StreamError
+	if errors.As(err, &se) {
+		return Code(se.ErrorCode), true
+	}
+	var ae *quic.ApplicationError
+	if errors.As(err, &ae) {
+		return Code(ae.ErrorCode), true
+	}
+	return 0, false
+}
+
+// FromWebTransport returns the code of a WebTransport stream reset
+func FromWebTransport(err error) (Code, bool) {
+	var se *webtransport.StreamError
+	if errors.As(err, &se) {
+		return Code(se.ErrorCode), true
+	}
+	return FromQUIC(err)
+}
+
+// MuxBody encodes c for the body of a mux CLOSE frame
+func MuxBody(c Code) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(c))
+}
+
+// FromMux decodes the body of a mux CLOSE frame; an empty body is Normal
+func FromMux(body []byte) Code {
+	if len(body) < 2 {
+		return Normal
+	}
+	return Code(binary.BigEndian.Uint16(body))
+}
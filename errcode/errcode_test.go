@@ -0,0 +1,75 @@
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+func TestWrapOf(t *testing.T) {
+	if Wrap(PolicyDenied, nil) != nil {
+		t.Fatal("Wrap(nil) is not nil")
+	}
+	err := fmt.Errorf("dial: %w", Wrap(PolicyDenied, io.EOF))
+	if got := Of(err, BackendRefused); got != PolicyDenied {
+		t.Errorf("Of(wrapped) = %v", got)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Error("Wrap hides the underlying error")
+	}
+	if got := Of(io.EOF, BackendRefused); got != BackendRefused {
+		t.Errorf("Of(plain) = %v, want the default", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	if got := IdleTimeout.String(); got != "idle timeout" {
+		t.Errorf("IdleTimeout = %q", got)
+	}
+	if got := Code(4999).String(); got != "code 4999" {
+		t.Errorf("unknown code = %q", got)
+	}
+}
+
+func TestFromWS(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code Code
+		ok   bool
+	}{
+		{&websocket.CloseError{Code: SessionExpired.WSCloseCode()}, SessionExpired, true},
+		{&websocket.CloseError{Code: RecordFailed.WSCloseCode()}, RecordFailed, true},
+		{&websocket.CloseError{Code: Normal.WSCloseCode()}, Normal, true},
+		{&websocket.CloseError{Code: websocket.CloseGoingAway}, 0, false},
+		{io.EOF, 0, false},
+	} {
+		if code, ok := FromWS(tc.err); code != tc.code || ok != tc.ok {
+			t.Errorf("FromWS(%v) = %v, %v", tc.err, code, ok)
+		}
+	}
+}
+
+func TestFromQUIC(t *testing.T) {
+	if code, ok := FromQUIC(&quic.StreamError{ErrorCode: quic.StreamErrorCode(BackendReset)}); !ok || code != BackendReset {
+		t.Errorf("stream reset = %v, %v", code, ok)
+	}
+	if code, ok := FromQUIC(&quic.ApplicationError{ErrorCode: quic.ApplicationErrorCode(ServerDraining)}); !ok || code != ServerDraining {
+		t.Errorf("connection close = %v, %v", code, ok)
+	}
+	if _, ok := FromQUIC(io.EOF); ok {
+		t.Error("FromQUIC(io.EOF) reports a code")
+	}
+}
+
+func TestMuxBody(t *testing.T) {
+	if got := FromMux(MuxBody(RateLimited)); got != RateLimited {
+		t.Errorf("round trip = %v", got)
+	}
+	if got := FromMux(nil); got != Normal {
+		t.Errorf("empty body = %v", got)
+	}
+}
@@ -0,0 +1,211 @@
+package bridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend is one address behind a logical target
+type Backend struct {
+	Addr   string
+	Weight int // relative share for weighted policies (default 1)
+}
+
+// Load-balancing policy names
+const (
+	PolicyRoundRobin     = "round_robin"
+	PolicyWeighted       = "weighted"
+	PolicyLeastConn      = "least_conn"
+	PolicyTwoChoices     = "random_two_choices"
+	PolicyConsistentHash = "consistent_hash"
+)
+
+// PickRequest describes one backend choice
+type PickRequest struct {
+	Eligible []int   // indexes of backends not currently ejected (non-empty)
+	Active   []int64 // open connections per backend index
+	Key      string  // client IP or identity, used by consistent_hash
+}
+
+// Balancer picks the preferred backend for one connection. Implementations
+// must be safe for concurrent use.
+type Balancer interface {
+	Pick(req PickRequest) int
+}
+
+// NewBalancer returns the Balancer for policy over backends
+func NewBalancer(policy string, backends []Backend) (Balancer, error) {
+	weights := make([]int, len(backends))
+	for i, b := range backends {
+		weights[i] = max(b.Weight, 1)
+	}
+	switch policy {
+	case "", PolicyRoundRobin:
+		return &roundRobin{}, nil
+	case PolicyWeighted:
+		return &weightedRoundRobin{weights: weights, current: make([]int, len(backends))}, nil
+	case PolicyLeastConn:
+		return &leastConn{weights: weights}, nil
+	case PolicyTwoChoices:
+		return &twoChoices{weights: weights}, nil
+	case PolicyConsistentHash:
+		return newHashRing(backends, weights), nil
+	}
+	return nil, fmt.Errorf("unknown load-balancing policy %q", policy)
+}
+
+// roundRobin cycles through eligible backends
+type roundRobin struct {
+	counter uint32
+}
+
+func (r *roundRobin) Pick(req PickRequest) int {
+	idx := atomic.AddUint32(&r.counter, 1)
+	return req.Eligible[int(idx)%len(req.Eligible)]
+}
+
+// weightedRoundRobin is nginx-style smooth weighted round-robin
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	weights []int
+	current []int
+}
+
+func (w *weightedRoundRobin) Pick(req PickRequest) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	total, best := 0, -1
+	for _, i := range req.Eligible {
+		w.current[i] += w.weights[i]
+		total += w.weights[i]
+		if best < 0 || w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+	w.current[best] -= total
+	return best
+}
+
+// lighter reports whether backend i carries less load per weight than j
+func lighter(active []int64, weights []int, i, j int) bool {
+	return active[i]*int64(weights[j]) < active[j]*int64(weights[i])
+}
+
+// leastConn picks the backend with the fewest active connections per
+// weight; ties rotate so idle backends share new connections
+type leastConn struct {
+	weights []int
+	counter uint32
+}
+
+func (l *leastConn) Pick(req PickRequest) int {
+	n := len(req.Eligible)
+	start := int(atomic.AddUint32(&l.counter, 1))
+	best := req.Eligible[start%n]
+	for k := 1; k < n; k++ {
+		i := req.Eligible[(start+k)%n]
+		if lighter(req.Active, l.weights, i, best) {
+			best = i
+		}
+	}
+	return best
+}
+
+// twoChoices samples two random backends and keeps the less loaded one
+type twoChoices struct {
+	weights []int
+	rng     *rand.Rand // seeded source for tests; nil uses the global one
+}
+
+func (t *twoChoices) intN(n int) int {
+	if t.rng != nil {
+		return t.rng.IntN(n)
+	}
+	return rand.IntN(n)
+}
+
+func (t *twoChoices) Pick(req PickRequest) int {
+	n := len(req.Eligible)
+	if n == 1 {
+		return req.Eligible[0]
+	}
+	a := t.intN(n)
+	b := t.intN(n - 1)
+	if b >= a {
+		b++
+	}
+	i, j := req.Eligible[a], req.Eligible[b]
+	if lighter(req.Active, t.weights, j, i) {
+		return j
+	}
+	return i
+}
+
+// hashRing is consistent hashing with weighted virtual nodes, so a key
+// keeps its backend while the backend set is stable
+type hashRing struct {
+	points []uint32
+	owners []int // backend index per point
+	rr     roundRobin
+}
+
+const ringReplicas = 160 // virtual nodes per unit of weight
+
+func newHashRing(backends []Backend, weights []int) *hashRing {
+	type node struct {
+		point uint32
+		owner int
+	}
+	var nodes []node
+	for i, b := range backends {
+		for v := 0; v < ringReplicas*weights[i]; v++ {
+			nodes = append(nodes, node{ringHash(b.Addr + "#" + strconv.Itoa(v)), i})
+		}
+	}
+	sort.Slice(nodes, func(a, b int) bool { return nodes[a].point < nodes[b].point })
+
+	h := &hashRing{points: make([]uint32, len(nodes)), owners: make([]int, len(nodes))}
+	for i, n := range nodes {
+		h.points[i] = n.point
+		h.owners[i] = n.owner
+	}
+	return h
+}
+
+// ringHash is FNV-1a with a murmur3 finalizer: deterministic across
+// processes, and it spreads near-identical vnode names around the ring
+func ringHash(s string) uint32 {
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(s))
+	x := f.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x)
+}
+
+func (h *hashRing) Pick(req PickRequest) int {
+	if req.Key == "" {
+		return h.rr.Pick(req) // nothing to be sticky on
+	}
+	eligible := make(map[int]bool, len(req.Eligible))
+	for _, i := range req.Eligible {
+		eligible[i] = true
+	}
+	key := ringHash(req.Key)
+	start := sort.Search(len(h.points), func(i int) bool { return h.points[i] >= key })
+	// walk clockwise past ejected backends
+	for k := 0; k < len(h.points); k++ {
+		if owner := h.owners[(start+k)%len(h.points)]; eligible[owner] {
+			return owner
+		}
+	}
+	return req.Eligible[0]
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+// testBackends returns one backend per weight
+func testBackends(weights []int) []Backend {
+	backends := make([]Backend, len(weights))
+	for i, w := range weights {
+		backends[i] = Backend{Addr: "10.0.0." + strconv.Itoa(i+1) + ":5432", Weight: w}
+	}
+	return backends
+}
+
+// simulatePicks runs n picks over lb. With hold, every pick stays open so
+// load-aware policies see it.
+func simulatePicks(lb Balancer, backends, n int, hold bool, active []int64) []int {
+	eligible := make([]int, backends)
+	for i := range eligible {
+		eligible[i] = i
+	}
+	if active == nil {
+		active = make([]int64, backends)
+	}
+	counts := make([]int, backends)
+	for k := 0; k < n; k++ {
+		i := lb.Pick(PickRequest{Eligible: eligible, Active: active})
+		counts[i]++
+		if hold {
+			active[i]++
+		}
+	}
+	return counts
+}
+
+func newTestBalancer(t *testing.T, policy string, weights []int) Balancer {
+	t.Helper()
+	lb, err := NewBalancer(policy, testBackends(weights))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lb
+}
+
+func expectCounts(t *testing.T, got, want []int, tolerance int) {
+	t.Helper()
+	for i := range want {
+		if d := got[i] - want[i]; d > tolerance || d < -tolerance {
+			t.Fatalf("picks per backend %v, want %v ±%d", got, want, tolerance)
+		}
+	}
+}
+
+func TestRoundRobinDistribution(t *testing.T) {
+	lb := newTestBalancer(t, PolicyRoundRobin, []int{1, 1, 1, 1})
+	expectCounts(t, simulatePicks(lb, 4, 4000, false, nil), []int{1000, 1000, 1000, 1000}, 0)
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	lb := newTestBalancer(t, PolicyWeighted, []int{5, 3, 1, 1})
+	expectCounts(t, simulatePicks(lb, 4, 1000, false, nil), []int{500, 300, 100, 100}, 0)
+}
+
+func TestLeastConnDistribution(t *testing.T) {
+	// backend 0 starts loaded: new connections go elsewhere until it evens out
+	lb := newTestBalancer(t, PolicyLeastConn, []int{1, 1, 1})
+	expectCounts(t, simulatePicks(lb, 3, 20, true, []int64{10, 0, 0}), []int{0, 10, 10}, 0)
+
+	lb = newTestBalancer(t, PolicyLeastConn, []int{2, 1})
+	expectCounts(t, simulatePicks(lb, 2, 300, true, nil), []int{200, 100}, 1)
+}
+
+func TestTwoChoicesDistribution(t *testing.T) {
+	lb := newTestBalancer(t, PolicyTwoChoices, []int{1, 1, 1, 1})
+	lb.(*twoChoices).rng = rand.New(rand.NewPCG(1, 2))
+	expectCounts(t, simulatePicks(lb, 4, 4000, true, nil), []int{1000, 1000, 1000, 1000}, 20)
+}
+
+// TestConsistentHash checks that keys stick to one backend, spread evenly,
+// and that ejecting a backend only moves that backend's keys
+func TestConsistentHash(t *testing.T) {
+	const keys = 4000
+	lb := newTestBalancer(t, PolicyConsistentHash, []int{1, 1, 1, 1})
+	all := PickRequest{Eligible: []int{0, 1, 2, 3}, Active: make([]int64, 4)}
+
+	owner := make([]int, keys)
+	counts := make([]int, 4)
+	for k := range owner {
+		all.Key = "192.0.2." + strconv.Itoa(k)
+		owner[k] = lb.Pick(all)
+		counts[owner[k]]++
+		if again := lb.Pick(all); again != owner[k] {
+			t.Fatalf("key %s moved from %d to %d", all.Key, owner[k], again)
+		}
+	}
+	// ±25% is about 3σ for 160 virtual nodes per backend
+	expectCounts(t, counts, []int{1000, 1000, 1000, 1000}, 250)
+
+	without2 := PickRequest{Eligible: []int{0, 1, 3}, Active: make([]int64, 4)}
+	for k := range owner {
+		without2.Key = "192.0.2." + strconv.Itoa(k)
+		got := lb.Pick(without2)
+		if owner[k] != 2 && got != owner[k] {
+			t.Fatalf("key %s moved from %d to %d after ejecting backend 2", without2.Key, owner[k], got)
+		}
+		if got == 2 {
+			t.Fatalf("key %s routed to ejected backend", without2.Key)
+		}
+	}
+}
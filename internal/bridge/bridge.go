@@ -1,12 +1,15 @@
 package bridge
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/DanielcoderX/anylink/errcode"
 	"github.com/DanielcoderX/anylink/internal/logger"
 	"github.com/gorilla/websocket"
 	"github.com/quic-go/quic-go"
@@ -18,18 +21,32 @@ type BridgeType int
 const (
 	WSBridge BridgeType = iota
 	QUICBridge
+	TCPBridge
+	PollBridge
 )
 
-// Bridge represents a single connection bridge (TCP ↔ WS or TCP ↔ QUIC)
+// Bridge represents a single connection bridge (TCP ↔ WS, TCP ↔ QUIC, TCP ↔ TCP
+// or TCP ↔ HTTP poll session)
 type Bridge struct {
 	bridgeType BridgeType
 
 	ws      *websocket.Conn
-	quicStr quic.Stream
+	quicStr io.ReadWriteCloser // QUIC or WebTransport stream
+	cancel  streamCancel       // resets quicStr on shutdown
+	client  net.Conn           // TCP ingress side or poll session
 	tcpConn net.Conn
 	cfg     *Config
 	log     *logger.Logger
 	wg      sync.WaitGroup
+	act     activity
+	rec     Recorder // set before the copy loops touch data
+
+	mu     sync.Mutex    // guards tcpConn, closed and reason
+	closed bool          // set once the bridge has been torn down
+	reason errcode.Code  // why it was torn down
+	ended  chan struct{} // closed on shutdown
+	dialed chan struct{} // closed once tcpConn is set (or the QUIC dial gave up)
+	once   sync.Once
 
 	// Metrics
 	BytesSent     int64
@@ -41,11 +58,40 @@ type Bridge struct {
 
 // Config holds bridge options
 type Config struct {
-	ReadTimeout time.Duration
+	// IdleTimeout ends the bridge when no data moves in either direction;
+	// MaxDuration ends it regardless (zero: no limit)
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+	// PingInterval is how often WS bridges ping the client; two missed
+	// pongs end the bridge (zero: no pings)
+	PingInterval time.Duration
+	// Dial connects to the target named by a QUIC or mux client (default
+	// net.Dial for QUIC)
+	Dial func(target string) (net.Conn, error)
+	// HalfClose passes a backend EOF to a WS client as a MuxEOF frame and
+	// keeps the WebSocket open for upstream data, instead of closing it
+	HalfClose bool
+	// MuxWindow and MuxMaxStreams size a WSMux (defaults 256 KiB and 128)
+	MuxWindow     int
+	MuxMaxStreams int
+	// Drain, when closed, ends the bridge with errcode.ServerDraining
+	Drain <-chan struct{}
+	// Recorder receives a copy of the tunnel's data. Record opens one for
+	// a QUIC bridge once the first message names the target and it has
+	// been dialed; nil means the tunnel is not recorded. An error from
+	// Record ends the tunnel with its errcode (default RecordFailed).
+	Recorder Recorder
+	Record   func(target string, backend net.Addr) (Recorder, error)
+}
+
+// streamCancel aborts the receive and send sides of a QUIC-like stream
+// with an error code
+type streamCancel struct {
+	Read, Write func(errcode.Code)
 }
 
-// WS frame: [streamID(4B)][len(4B)][payload]
-func writeWSFrame(ws *websocket.Conn, streamID uint32, data []byte) error {
+// WriteWSFrame sends one WS frame: [streamID(4B)][len(4B)][payload]
+func WriteWSFrame(ws *websocket.Conn, streamID uint32, data []byte) error {
 	buf := make([]byte, 8+len(data))
 	binary.BigEndian.PutUint32(buf[0:4], streamID)
 	binary.BigEndian.PutUint32(buf[4:8], uint32(len(data)))
@@ -53,7 +99,8 @@ func writeWSFrame(ws *websocket.Conn, streamID uint32, data []byte) error {
 	return ws.WriteMessage(websocket.BinaryMessage, buf)
 }
 
-func readWSFrame(r io.Reader) (streamID uint32, payload []byte, err error) {
+// ReadWSFrame decodes one WS frame from a binary message reader
+func ReadWSFrame(r io.Reader) (streamID uint32, payload []byte, err error) {
 	header := make([]byte, 8)
 	if _, err = io.ReadFull(r, header); err != nil {
 		return
@@ -72,50 +119,95 @@ func NewWSBridge(ws *websocket.Conn, tcpConn net.Conn, cfg *Config) *Bridge {
 		ws:         ws,
 		tcpConn:    tcpConn,
 		cfg:        cfg,
+		rec:        cfg.recorder(),
 		log:        logger.New("bridge"),
+		dialed:     make(chan struct{}),
+		ended:      make(chan struct{}),
 	}
+	b.markDialed()
 	b.startWS()
+	watch(b.cfg, &b.act, b.ended, b.shutdown)
 	return b
 }
 
 // NewQUICBridge starts a QUIC stream bridge; TCP dial deferred until first message if tcpConn is nil
 func NewQUICBridge(qs quic.Stream, tcpConn net.Conn, cfg *Config) *Bridge {
+	return NewStreamBridge(qs,
+		func(c errcode.Code) { qs.CancelRead(quic.StreamErrorCode(c)) },
+		func(c errcode.Code) { qs.CancelWrite(quic.StreamErrorCode(c)) },
+		tcpConn, cfg)
+}
+
+// NewStreamBridge is NewQUICBridge for any QUIC-like stream, such as a
+// WebTransport stream; cancelRead and cancelWrite abort its two sides with
+// the close reason when the bridge fails
+func NewStreamBridge(str io.ReadWriteCloser, cancelRead, cancelWrite func(errcode.Code), tcpConn net.Conn, cfg *Config) *Bridge {
 	b := &Bridge{
 		bridgeType: QUICBridge,
-		quicStr:    qs,
+		quicStr:    str,
+		cancel:     streamCancel{Read: cancelRead, Write: cancelWrite},
 		tcpConn:    tcpConn, // can be nil
 		cfg:        cfg,
+		rec:        cfg.recorder(),
 		log:        logger.New("bridge"),
+		dialed:     make(chan struct{}),
+		ended:      make(chan struct{}),
+	}
+	if tcpConn != nil {
+		b.markDialed()
 	}
 	b.startQUIC()
+	watch(b.cfg, &b.act, b.ended, b.shutdown)
+	return b
+}
+
+// NewTCPBridge starts a TCP ↔ TCP bridge between an ingress client and a
+// backend
+func NewTCPBridge(client, tcpConn net.Conn, cfg *Config) *Bridge {
+	b := &Bridge{
+		bridgeType: TCPBridge,
+		client:     client,
+		tcpConn:    tcpConn,
+		cfg:        cfg,
+		rec:        cfg.recorder(),
+		log:        logger.New("bridge"),
+		dialed:     make(chan struct{}),
+		ended:      make(chan struct{}),
+	}
+	b.markDialed()
+	b.startTCP()
+	watch(b.cfg, &b.act, b.ended, b.shutdown)
 	return b
 }
 
 // startWS launches TCP ↔ WS copying
 func (b *Bridge) startWS() {
-
 	b.ws.SetReadLimit(1 << 20)
-	_ = b.ws.SetReadDeadline(time.Now().Add(b.cfg.ReadTimeout))
-	b.ws.SetPongHandler(func(string) error {
-		_ = b.ws.SetReadDeadline(time.Now().Add(b.cfg.ReadTimeout))
-		return nil
-	})
+	extend := keepAlive(b.ws, b.cfg.PingInterval, b.ended)
 
 	b.wg.Add(2)
 
 	// TCP -> WS
 	go func() {
 		defer b.wg.Done()
+		halfClosed, reason := false, errcode.Normal
+		defer func() { b.directionDone(halfClosed, reason) }()
 		buf := make([]byte, 32*1024)
 		for {
 			n, err := b.tcpConn.Read(buf)
 			if n > 0 {
+				b.act.touch()
+				b.record(true, buf[:n])
 				b.BytesSent += int64(n)
-				if ew := writeWSFrame(b.ws, 1, buf[:n]); ew != nil {
+				if ew := WriteWSFrame(b.ws, 1, buf[:n]); ew != nil {
 					return
 				}
 			}
+			if err == io.EOF && b.cfg.HalfClose {
+				halfClosed = WriteWSFrame(b.ws, 0, MuxControl(MuxEOF, 1, nil)) == nil
+			}
 			if err != nil {
+				reason = backendReason(err)
 				return
 			}
 		}
@@ -124,23 +216,40 @@ func (b *Bridge) startWS() {
 	// WS -> TCP
 	go func() {
 		defer b.wg.Done()
+		halfClosed, reason := false, errcode.Normal
+		defer func() { b.directionDone(halfClosed, reason) }()
 		for {
 			mt, rdr, err := b.ws.NextReader()
 			if err != nil {
+				reason = clientReason(err)
 				return
 			}
+			extend()
 			if mt != websocket.BinaryMessage {
 				continue
 			}
-			streamID, payload, err := readWSFrame(rdr)
+			streamID, payload, err := ReadWSFrame(rdr)
 			if err != nil {
 				return
 			}
+			if streamID == 0 {
+				if typ, id, _, err := ParseMuxControl(payload); err == nil && typ == MuxEOF && id == 1 {
+					halfClosed = closeWrite(b.tcpConn)
+					return
+				}
+				continue
+			}
 			if streamID != 1 {
 				continue
 			}
-			n, _ := b.tcpConn.Write(payload)
+			b.act.touch()
+			b.record(false, payload)
+			n, ew := b.tcpConn.Write(payload)
 			b.BytesReceived += int64(n)
+			if ew != nil {
+				reason = errcode.Of(ew, errcode.BackendReset)
+				return
+			}
 			b.log.Trace("WS->TCP %d bytes", n)
 			b.log.Debug("WS->TCP activity")
 		}
@@ -154,30 +263,61 @@ func (b *Bridge) startQUIC() {
 	// QUIC -> TCP
 	go func() {
 		defer b.wg.Done()
+		halfClosed, reason := false, errcode.Normal
+		defer func() { b.directionDone(halfClosed, reason) }()
+		defer b.markDialed() // release TCP -> QUIC if we never dialed
+		tcpConn := b.tcpConn
 		buf := make([]byte, 32*1024)
 		for {
 			n, err := b.quicStr.Read(buf)
 			if n > 0 {
-				// On first message, auto-dial TCP if tcpConn is nil
-				if b.tcpConn == nil {
-					b.target = string(buf[:n])
-					tcp, err := net.Dial("tcp", b.target)
+				b.act.touch()
+				// On first message, auto-dial TCP if tcpConn is nil.
+				// "host:port\n" may carry payload after the newline;
+				// without a newline the whole message is the target.
+				if tcpConn == nil {
+					first, rest := buf[:n], []byte(nil)
+					if i := bytes.IndexByte(first, '\n'); i >= 0 {
+						first, rest = first[:i], first[i+1:]
+					}
+					b.target = strings.TrimSpace(string(first))
+					tcp, err := b.dial(b.target)
 					if err != nil {
 						b.log.Error("QUIC auto-dial failed: %v", err)
+						reason = errcode.Of(err, errcode.BackendRefused)
+						return
+					}
+					if b.rec == nil && b.cfg != nil && b.cfg.Record != nil {
+						if b.rec, err = b.cfg.Record(b.target, tcp.RemoteAddr()); err != nil {
+							tcp.Close()
+							b.log.Error("QUIC tunnel to %s: %v", b.target, err)
+							reason = errcode.Of(err, errcode.RecordFailed)
+							return
+						}
+					}
+					if !b.setTCP(tcp) {
 						return
 					}
-					b.tcpConn = tcp
+					tcpConn = tcp
 					b.log.Debug("QUIC auto-dialed TCP target: %s", b.target)
-					continue // target message ignored
+					if len(rest) == 0 {
+						continue // target message ignored
+					}
+					n = copy(buf, rest)
 				}
 
+				b.record(false, buf[:n])
 				b.BytesReceived += int64(n)
-				if _, ew := b.tcpConn.Write(buf[:n]); ew != nil {
+				if _, ew := tcpConn.Write(buf[:n]); ew != nil {
+					reason = errcode.Of(ew, errcode.BackendReset)
 					return
 				}
 				b.log.Trace("QUIC->TCP %d bytes", n)
 				b.log.Debug("QUIC->TCP activity")
 			}
+			if err == io.EOF && tcpConn != nil {
+				halfClosed = closeWrite(tcpConn) // client sent FIN
+			}
 			if err != nil {
 				return
 			}
@@ -187,19 +327,84 @@ func (b *Bridge) startQUIC() {
 	// TCP -> QUIC
 	go func() {
 		defer b.wg.Done()
+		halfClosed, reason := false, errcode.Normal
+		defer func() { b.directionDone(halfClosed, reason) }()
+		// wait until tcpConn exists
+		<-b.dialed
+		tcpConn := b.tcp()
+		if tcpConn == nil {
+			return
+		}
 		buf := make([]byte, 32*1024)
 		for {
-			// wait until tcpConn exists
-			if b.tcpConn == nil {
-				time.Sleep(10 * time.Millisecond)
-				continue
+			n, err := tcpConn.Read(buf)
+			if n > 0 {
+				b.act.touch()
+				b.record(true, buf[:n])
+				b.BytesSent += int64(n)
+				if _, ew := b.quicStr.Write(buf[:n]); ew != nil {
+					return
+				}
+			}
+			if err == io.EOF {
+				halfClosed = b.quicStr.Close() == nil // FIN; the read side stays open
+			}
+			if err != nil {
+				reason = backendReason(err)
+				return
 			}
+		}
+	}()
+}
+
+// startTCP launches TCP ↔ TCP copying
+func (b *Bridge) startTCP() {
+	b.wg.Add(2)
+
+	// backend -> client
+	go func() {
+		defer b.wg.Done()
+		halfClosed := false
+		defer func() { b.directionDone(halfClosed, errcode.Normal) }()
+		buf := make([]byte, 32*1024)
+		for {
 			n, err := b.tcpConn.Read(buf)
 			if n > 0 {
+				b.act.touch()
+				b.record(true, buf[:n])
 				b.BytesSent += int64(n)
-				if _, ew := b.quicStr.Write(buf[:n]); ew != nil {
+				if _, ew := b.client.Write(buf[:n]); ew != nil {
+					return
+				}
+			}
+			if err == io.EOF {
+				halfClosed = closeWrite(b.client)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// client -> backend
+	go func() {
+		defer b.wg.Done()
+		halfClosed := false
+		defer func() { b.directionDone(halfClosed, errcode.Normal) }()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := b.client.Read(buf)
+			if n > 0 {
+				b.act.touch()
+				b.record(false, buf[:n])
+				b.BytesReceived += int64(n)
+				if _, ew := b.tcpConn.Write(buf[:n]); ew != nil {
 					return
 				}
+				b.log.Trace("TCP->TCP %d bytes", n)
+			}
+			if err == io.EOF {
+				halfClosed = closeWrite(b.tcpConn)
 			}
 			if err != nil {
 				return
@@ -208,24 +413,131 @@ func (b *Bridge) startQUIC() {
 	}()
 }
 
-// Close shuts down connections and waits for goroutines
-func (b *Bridge) Close() {
+// dial opens the TCP side for QUIC bridges
+func (b *Bridge) dial(target string) (net.Conn, error) {
+	if b.cfg != nil && b.cfg.Dial != nil {
+		return b.cfg.Dial(target)
+	}
+	return net.Dial("tcp", target)
+}
+
+// setTCP installs the dialed backend conn; false if the bridge already closed
+func (b *Bridge) setTCP(c net.Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		c.Close()
+		return false
+	}
+	b.tcpConn = c
+	b.markDialed()
+	return true
+}
+
+func (b *Bridge) tcp() net.Conn {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tcpConn
+}
+
+func (b *Bridge) markDialed() {
+	b.once.Do(func() { close(b.dialed) })
+}
+
+// directionDone ends one copy direction. After a clean EOF that was passed
+// on as a half-close the other direction carries on, and the bridge ends
+// when both have finished; any other ending tears the bridge down with
+// reason.
+func (b *Bridge) directionDone(halfClosed bool, reason errcode.Code) {
+	if !halfClosed {
+		b.shutdown(reason)
+	}
+}
+
+// backendReason classifies a backend read error; an interceptor's block
+// carries its own code
+func backendReason(err error) errcode.Code {
+	if err == io.EOF {
+		return errcode.Normal
+	}
+	return errcode.Of(err, errcode.BackendReset)
+}
+
+// clientReason classifies a WS read error: only a missed read deadline is
+// worth reporting, as the client is gone otherwise
+func clientReason(err error) errcode.Code {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return errcode.IdleTimeout
+	}
+	return errcode.Normal
+}
+
+// closeWS sends a close frame carrying reason, then drops the connection
+func closeWS(ws *websocket.Conn, reason errcode.Code) {
+	msg := websocket.FormatCloseMessage(reason.WSCloseCode(), reason.String())
+	_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	ws.Close()
+}
+
+// closeWrite half-closes c when it supports it; false means the caller
+// must close c entirely instead
+func closeWrite(c net.Conn) bool {
+	cw, ok := c.(interface{ CloseWrite() error })
+	return ok && cw.CloseWrite() == nil
+}
+
+// shutdown closes both sides so the other copy direction unblocks, and
+// tells the client why: a WS close code or a QUIC stream reset code
+func (b *Bridge) shutdown(reason errcode.Code) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	b.reason = reason
+	close(b.ended)
+	tcpConn := b.tcpConn
+	b.mu.Unlock()
+
 	if b.ws != nil {
-		b.ws.Close()
+		closeWS(b.ws, reason)
 	}
-	if b.quicStr != nilValueStream {
-		b.quicStr.CancelRead(0)
-		b.quicStr.CancelWrite(0)
+	if b.client != nil {
+		b.client.Close()
 	}
-	if b.tcpConn != nil {
-		b.tcpConn.Close()
+	if b.quicStr != nil {
+		b.cancel.Read(reason)
+		if reason != errcode.Normal {
+			b.cancel.Write(reason)
+		}
+		// FIN after a normal end so already-written backend data is delivered
+		b.quicStr.Close()
 	}
+	if tcpConn != nil {
+		tcpConn.Close()
+	}
+}
+
+// Reason reports why the bridge was torn down, once it has been
+func (b *Bridge) Reason() errcode.Code {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reason
+}
+
+// Close shuts down connections, waits for goroutines and finishes the
+// recording
+func (b *Bridge) Close() {
+	b.shutdown(errcode.Normal)
 	b.wg.Wait()
+	if b.rec != nil {
+		if err := b.rec.Close(); err != nil {
+			b.log.Error("closing recording: %v", err)
+		}
+	}
 }
 
 func (b *Bridge) Wg() *sync.WaitGroup {
 	return &b.wg
 }
-
-// nilValueStream is a zero value to compare quic.Stream interface
-var nilValueStream interface{} = (interface{})(nil)
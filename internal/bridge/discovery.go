@@ -0,0 +1,371 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS record types a service can be discovered from
+const (
+	DiscoverSRV = "srv" // _service._proto.name: host, port and weight per record
+	DiscoverA   = "a"   // A and AAAA records plus a fixed port
+)
+
+// DiscoveryOptions resolves a logical target's backends from DNS
+type DiscoveryOptions struct {
+	Name     string        // DNS name or SRV record
+	Type     string        // DiscoverSRV (default) or DiscoverA
+	Port     int           // backend port for DiscoverA
+	Resolver string        // DNS server host:port (default: first nameserver in /etc/resolv.conf)
+	MinTTL   time.Duration // refresh no faster than this (default 5s)
+	MaxTTL   time.Duration // refresh at least this often (default 5m)
+}
+
+func (o DiscoveryOptions) withDefaults() DiscoveryOptions {
+	if o.Type == "" {
+		o.Type = DiscoverSRV
+	}
+	if o.Resolver == "" {
+		o.Resolver = systemResolver()
+	}
+	if o.MinTTL <= 0 {
+		o.MinTTL = 5 * time.Second
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = 5 * time.Minute
+	}
+	return o
+}
+
+// AddDiscoveredService registers a logical target whose backends come from
+// DNS. Records are re-resolved when their TTL expires; bridges already
+// using a backend that disappears are left running.
+func (p *TCPPool) AddDiscoveredService(logical string, opts DiscoveryOptions, policy string) error {
+	opts = opts.withDefaults()
+	switch {
+	case opts.Name == "":
+		return fmt.Errorf("service %s: empty DNS name", logical)
+	case opts.Type != DiscoverSRV && opts.Type != DiscoverA:
+		return fmt.Errorf("service %s: unknown DNS record type %q", logical, opts.Type)
+	case opts.Type == DiscoverA && (opts.Port <= 0 || opts.Port > 65535):
+		return fmt.Errorf("service %s: A/AAAA discovery needs a port", logical)
+	}
+	if _, err := NewBalancer(policy, nil); err != nil {
+		return fmt.Errorf("service %s: %w", logical, err)
+	}
+
+	// registered up front so clients get "no backends" rather than a raw dial
+	p.mu.Lock()
+	p.backends[logical] = nil
+	p.lbs[logical] = &roundRobin{}
+	p.mu.Unlock()
+
+	// resolved in the background: a slow resolver must not hold up startup
+	go p.discoveryLoop(&discoveredService{logical: logical, opts: opts, policy: policy})
+	return nil
+}
+
+// discoveredService is the refresh state of one DNS-backed target
+type discoveredService struct {
+	logical string
+	opts    DiscoveryOptions
+	policy  string
+	current []Backend // last applied set, sorted by address
+}
+
+// discoveryLoop resolves d right away, then again whenever its records expire
+func (p *TCPPool) discoveryLoop(d *discoveredService) {
+	timer := time.NewTimer(p.refreshDiscovered(d))
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-timer.C:
+			timer.Reset(p.refreshDiscovered(d))
+		}
+	}
+}
+
+// refreshDiscovered resolves d once, swaps in the new backend set if it
+// changed and returns the delay until the next refresh
+func (p *TCPPool) refreshDiscovered(d *discoveredService) time.Duration {
+	backends, ttl, err := discover(d.opts)
+	if err != nil {
+		p.log.Error("service %s: resolve %s: %v", d.logical, d.opts.Name, err)
+		return d.opts.MinTTL // keep the last good set and retry soon
+	}
+	if !slices.Equal(backends, d.current) {
+		if err := p.setBackends(d.logical, backends, d.policy); err != nil {
+			p.log.Error("%v", err)
+			return d.opts.MinTTL
+		}
+		d.current = backends
+	}
+	return min(max(ttl, d.opts.MinTTL), d.opts.MaxTTL)
+}
+
+// setBackends replaces the backends of a discovered target. Idle conns to
+// addresses no target uses any more are closed; in-use conns are untouched.
+func (p *TCPPool) setBackends(logical string, backends []Backend, policy string) error {
+	addrs := make([]string, len(backends))
+	for i := range backends {
+		addrs[i] = backends[i].Addr
+	}
+	lb, err := NewBalancer(policy, backends)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", logical, err)
+	}
+
+	p.mu.Lock()
+	old := p.backends[logical]
+	p.backends[logical] = addrs
+	p.lbs[logical] = lb
+
+	inUse := make(map[string]bool)
+	for _, bs := range p.backends {
+		for _, b := range bs {
+			inUse[b] = true
+		}
+	}
+	var stale []idleConn
+	var removed []string
+	for _, b := range old {
+		if !inUse[b] {
+			removed = append(removed, b)
+			stale = append(stale, p.conns[b]...)
+			p.counters(b).open -= len(p.conns[b])
+			delete(p.conns, b)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, ic := range stale {
+		ic.Conn.Close()
+	}
+	p.log.Info("service %s backends: %v (removed %v)", logical, addrs, removed)
+	return nil
+}
+
+// discover returns the backends for opts and the smallest record TTL
+func discover(opts DiscoveryOptions) ([]Backend, time.Duration, error) {
+	name, err := dnsmessage.NewName(fqdn(opts.Name))
+	if err != nil {
+		return nil, 0, err
+	}
+	if opts.Type == DiscoverA {
+		ips, ttl, err := lookupIPs(opts.Resolver, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		backends := make([]Backend, len(ips))
+		for i, ip := range ips {
+			backends[i] = Backend{Addr: net.JoinHostPort(ip.String(), strconv.Itoa(opts.Port)), Weight: 1}
+		}
+		return backends, ttl, nil
+	}
+	return lookupSRV(opts.Resolver, name)
+}
+
+// lookupSRV resolves the lowest-priority SRV group; higher priorities are
+// ignored since the pool already fails over between backends
+func lookupSRV(resolver string, name dnsmessage.Name) ([]Backend, time.Duration, error) {
+	msg, err := dnsQuery(resolver, name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := time.Duration(-1)
+	minTTL := func(t uint32) {
+		if d := time.Duration(t) * time.Second; ttl < 0 || d < ttl {
+			ttl = d
+		}
+	}
+
+	var srvs []*dnsmessage.SRVResource
+	for _, a := range msg.Answers {
+		if srv, ok := a.Body.(*dnsmessage.SRVResource); ok {
+			srvs = append(srvs, srv)
+			minTTL(a.Header.TTL)
+		}
+	}
+	if len(srvs) == 0 {
+		return nil, 0, fmt.Errorf("no SRV records for %s", name)
+	}
+	best := srvs[0].Priority
+	for _, srv := range srvs {
+		best = min(best, srv.Priority)
+	}
+
+	// addresses from the additional section save a round trip per target
+	glue := make(map[string][]net.IP)
+	for _, a := range msg.Additionals {
+		switch b := a.Body.(type) {
+		case *dnsmessage.AResource:
+			glue[a.Header.Name.String()] = append(glue[a.Header.Name.String()], net.IP(b.A[:]))
+			minTTL(a.Header.TTL)
+		case *dnsmessage.AAAAResource:
+			glue[a.Header.Name.String()] = append(glue[a.Header.Name.String()], net.IP(b.AAAA[:]))
+			minTTL(a.Header.TTL)
+		}
+	}
+
+	var backends []Backend
+	for _, srv := range srvs {
+		if srv.Priority != best {
+			continue
+		}
+		ips, ok := glue[srv.Target.String()]
+		if !ok {
+			var t time.Duration
+			if ips, t, err = lookupIPs(resolver, srv.Target); err != nil {
+				return nil, 0, fmt.Errorf("%s: %w", srv.Target, err)
+			}
+			minTTL(uint32(t / time.Second))
+		}
+		for _, ip := range ips {
+			backends = append(backends, Backend{
+				Addr:   net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.Port))),
+				Weight: max(int(srv.Weight), 1),
+			})
+		}
+	}
+	slices.SortFunc(backends, func(a, b Backend) int { return strings.Compare(a.Addr, b.Addr) })
+	return backends, ttl, nil
+}
+
+// lookupIPs resolves A and AAAA records for name
+func lookupIPs(resolver string, name dnsmessage.Name) ([]net.IP, time.Duration, error) {
+	var ips []net.IP
+	ttl := time.Duration(-1)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := dnsQuery(resolver, name, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, a := range msg.Answers {
+			var ip net.IP
+			switch b := a.Body.(type) {
+			case *dnsmessage.AResource:
+				ip = net.IP(b.A[:])
+			case *dnsmessage.AAAAResource:
+				ip = net.IP(b.AAAA[:])
+			default:
+				continue // CNAME chain entries
+			}
+			ips = append(ips, ip)
+			if d := time.Duration(a.Header.TTL) * time.Second; ttl < 0 || d < ttl {
+				ttl = d
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, 0, fmt.Errorf("no A/AAAA records for %s", name)
+	}
+	slices.SortFunc(ips, func(a, b net.IP) int { return strings.Compare(a.String(), b.String()) })
+	return ips, ttl, nil
+}
+
+// dnsQuery sends one recursive question over UDP, retrying over TCP when
+// the answer is truncated
+func dnsQuery(resolver string, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	id := uint16(rand.Uint32())
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := dnsExchange("udp", resolver, packed)
+	if err != nil {
+		return nil, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, err
+	}
+	if msg.Truncated {
+		if resp, err = dnsExchange("tcp", resolver, packed); err != nil {
+			return nil, err
+		}
+		if err := msg.Unpack(resp); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case msg.ID != id:
+		return nil, errors.New("DNS response ID mismatch")
+	case msg.RCode == dnsmessage.RCodeNameError:
+		return nil, fmt.Errorf("%s: no such host", name)
+	case msg.RCode != dnsmessage.RCodeSuccess:
+		return nil, fmt.Errorf("%s: DNS %s", name, msg.RCode)
+	}
+	return &msg, nil
+}
+
+func dnsExchange(network, resolver string, query []byte) ([]byte, error) {
+	c, err := net.DialTimeout(network, resolver, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if network == "udp" {
+		if _, err := c.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 4096)
+		n, err := c.Read(buf)
+		return buf[:n], err
+	}
+	// DNS over TCP: 2-byte length prefix each way
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := c.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	_, err = io.ReadFull(c, buf)
+	return buf, err
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// systemResolver returns the first nameserver from /etc/resolv.conf
+func systemResolver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if fields := strings.Fields(sc.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
@@ -0,0 +1,205 @@
+package bridge
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDiscoverA(t *testing.T) {
+	echo := echoAddr(t)
+	dns := startDNSStub(t)
+	host, port, _ := net.SplitHostPort(echo)
+	dns.setA("echo.test.", net.ParseIP(host))
+
+	p := NewTCPPool(2)
+	defer p.Close()
+	n, _ := strconv.Atoi(port)
+	err := p.AddDiscoveredService("discovered", DiscoveryOptions{
+		Name: "echo.test", Type: DiscoverA, Port: n, Resolver: dns.addr(),
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitBackends(t, p, echo)
+	poolEcho(t, p, "discovered")
+}
+
+// TestDiscoverSRV moves an SRV record to a new backend and expects the
+// pool to follow it while a conn to the old backend stays open
+func TestDiscoverSRV(t *testing.T) {
+	first, second := echoAddr(t), echoAddr(t)
+	dns := startDNSStub(t)
+	dns.setA("echo.test.", net.IPv4(127, 0, 0, 1))
+	dns.setSRV("_echo._tcp.test.", "echo.test.", first)
+
+	p := NewTCPPool(2)
+	defer p.Close()
+	err := p.AddDiscoveredService("discovered", DiscoveryOptions{
+		Name: "_echo._tcp.test", Resolver: dns.addr(), MinTTL: 20 * time.Millisecond,
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitBackends(t, p, first)
+	old, err := p.Get("discovered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	dns.setSRV("_echo._tcp.test.", "echo.test.", second)
+	waitBackends(t, p, second)
+	poolEcho(t, p, "discovered")
+	if err := connEcho(old, []byte("still here")); err != nil {
+		t.Fatalf("conn to removed backend: %v", err)
+	}
+}
+
+// TestDiscoverSlowResolver registers a service whose resolver never
+// answers: startup must not wait for it
+func TestDiscoverSlowResolver(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	p := NewTCPPool(2)
+	defer p.Close()
+	start := time.Now()
+	err = p.AddDiscoveredService("discovered", DiscoveryOptions{
+		Name: "_echo._tcp.test", Resolver: pc.LocalAddr().String(),
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("AddDiscoveredService took %v", d)
+	}
+	if _, err := p.Get("discovered"); err == nil || !strings.Contains(err.Error(), "no backends") {
+		t.Fatalf("Get before the first resolution: %v, want no backends", err)
+	}
+}
+
+// waitBackends waits until the pool's only backend is addr
+func waitBackends(t *testing.T, p *TCPPool, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := p.Status()
+		if len(st) == 1 && st[0].Backend == addr {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backends %+v, want %s", st, addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// poolEcho gets a conn for target and echoes a payload through it
+func poolEcho(t *testing.T, p *TCPPool, target string) {
+	t.Helper()
+	c, err := p.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := connEcho(c, []byte("discovered backend")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func connEcho(c net.Conn, payload []byte) error {
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write(payload); err != nil {
+		return err
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(c, got); err != nil {
+		return err
+	}
+	if string(got) != string(payload) {
+		return errors.New("echo mismatch")
+	}
+	return nil
+}
+
+// dnsStub is a minimal UDP DNS server answering from a mutable table
+type dnsStub struct {
+	pc      net.PacketConn
+	mu      sync.Mutex
+	records map[dnsmessage.Question][]dnsmessage.Resource
+}
+
+func startDNSStub(t *testing.T) *dnsStub {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	d := &dnsStub{pc: pc, records: make(map[dnsmessage.Question][]dnsmessage.Resource)}
+	go d.serve()
+	return d
+}
+
+func (d *dnsStub) addr() string { return d.pc.LocalAddr().String() }
+
+func (d *dnsStub) set(name string, qtype dnsmessage.Type, body dnsmessage.ResourceBody) {
+	n := dnsmessage.MustNewName(name)
+	q := dnsmessage.Question{Name: n, Type: qtype, Class: dnsmessage.ClassINET}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// TTL 0: the pool refreshes at its MinTTL
+	d.records[q] = []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: n, Type: qtype, Class: dnsmessage.ClassINET},
+		Body:   body,
+	}}
+}
+
+func (d *dnsStub) setA(name string, ip net.IP) {
+	var a dnsmessage.AResource
+	copy(a.A[:], ip.To4())
+	d.set(name, dnsmessage.TypeA, &a)
+}
+
+func (d *dnsStub) setSRV(name, target, hostport string) {
+	_, port, _ := net.SplitHostPort(hostport)
+	n, _ := strconv.Atoi(port)
+	d.set(name, dnsmessage.TypeSRV, &dnsmessage.SRVResource{
+		Target: dnsmessage.MustNewName(target), Port: uint16(n), Weight: 1,
+	})
+}
+
+func (d *dnsStub) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := d.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var q dnsmessage.Message
+		if q.Unpack(buf[:n]) != nil || len(q.Questions) != 1 {
+			continue
+		}
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionAvailable: true},
+			Questions: q.Questions,
+		}
+		d.mu.Lock()
+		resp.Answers = d.records[q.Questions[0]]
+		d.mu.Unlock()
+		if packed, err := resp.Pack(); err == nil {
+			_, _ = d.pc.WriteTo(packed, from)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/logger"
+)
+
+// Interceptor inspects a tunnel's data on its way between client and
+// backend; toClient is backend output. It returns the bytes to pass on:
+// p itself, a rewrite, or nothing to hold data back until more arrives (p
+// is only valid during the call, so held data must be copied). An error
+// blocks the tunnel, which ends with errcode.PolicyDenied. Each tunnel
+// gets its own interceptors, and calls are never concurrent.
+type Interceptor interface {
+	Intercept(toClient bool, p []byte) ([]byte, error)
+}
+
+// interceptConn runs a backend connection's traffic through a chain of
+// interceptors. Working on the connection rather than in the copy loops
+// covers every transport alike, and keeps interceptor state across WS and
+// QUIC resumes, which reattach the same backend.
+type interceptConn struct {
+	net.Conn
+	target string
+	chain  []Interceptor
+	log    *logger.Logger
+
+	mu      sync.Mutex // serializes the chain across directions
+	blocked error
+
+	buf     []byte // backend reads
+	pending []byte // intercepted backend output not yet read
+	readErr error  // backend read error to return once pending is read
+}
+
+// Intercept wraps a backend connection to target so that data written to
+// it and read from it passes through chain in order. Reads and writes fail
+// with an errcode.PolicyDenied error once an interceptor blocks.
+func Intercept(c net.Conn, target string, chain ...Interceptor) net.Conn {
+	if len(chain) == 0 {
+		return c
+	}
+	return &interceptConn{Conn: c, target: target, chain: chain, log: logger.New("bridge"), buf: make([]byte, 32*1024)}
+}
+
+// intercept runs p through the chain; the first error blocks for good
+func (c *interceptConn) intercept(toClient bool, p []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blocked != nil {
+		return nil, c.blocked
+	}
+	for _, ic := range c.chain {
+		out, err := ic.Intercept(toClient, p)
+		if err != nil {
+			c.blocked = errcode.Wrap(errcode.Of(err, errcode.PolicyDenied), err)
+			c.log.Info("🛑 Blocked tunnel to %s: %v", c.target, err)
+			return nil, c.blocked
+		}
+		if p = out; len(p) == 0 {
+			break
+		}
+	}
+	return p, nil
+}
+
+func (c *interceptConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if err := c.readErr; err != nil {
+			c.readErr = nil
+			return 0, err
+		}
+		n, err := c.Conn.Read(c.buf)
+		if n > 0 {
+			out, ierr := c.intercept(true, c.buf[:n])
+			if ierr != nil {
+				return 0, ierr
+			}
+			c.pending = out
+		}
+		c.readErr = err
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write passes what the chain returns to the backend and reports all of p
+// as written, including data an interceptor holds back
+func (c *interceptConn) Write(p []byte) (int, error) {
+	out, err := c.intercept(false, p)
+	if err != nil {
+		return 0, err
+	}
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// CloseWrite half-closes the backend connection
+func (c *interceptConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,125 @@
+package bridge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/errcode"
+)
+
+// interceptFunc adapts a function to Interceptor
+type interceptFunc func(toClient bool, p []byte) ([]byte, error)
+
+func (f interceptFunc) Intercept(toClient bool, p []byte) ([]byte, error) { return f(toClient, p) }
+
+// upper uppercases client data
+var upper = interceptFunc(func(toClient bool, p []byte) ([]byte, error) {
+	if toClient {
+		return p, nil
+	}
+	return bytes.ToUpper(p), nil
+})
+
+// denyWord blocks the first chunk in either direction containing word
+func denyWord(word string) Interceptor {
+	return interceptFunc(func(_ bool, p []byte) ([]byte, error) {
+		if bytes.Contains(p, []byte(word)) {
+			return nil, errors.New(word + " is denied")
+		}
+		return p, nil
+	})
+}
+
+// interceptPair returns an intercepted end of a pipe and its backend end
+func interceptPair(t *testing.T, chain ...Interceptor) (net.Conn, net.Conn) {
+	t.Helper()
+	client, backend := net.Pipe()
+	t.Cleanup(func() { client.Close(); backend.Close() })
+	return Intercept(client, "test:1", chain...), backend
+}
+
+// pipeWrite writes p on c and returns what the other end reads
+func pipeWrite(t *testing.T, c, other net.Conn, p string) string {
+	t.Helper()
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := other.Read(buf)
+		got <- string(buf[:n])
+	}()
+	if _, err := c.Write([]byte(p)); err != nil {
+		t.Fatalf("write %q: %v", p, err)
+	}
+	return <-got
+}
+
+func TestInterceptNoChain(t *testing.T) {
+	c, _ := net.Pipe()
+	defer c.Close()
+	if Intercept(c, "test:1") != c {
+		t.Error("an empty chain wraps the connection")
+	}
+}
+
+func TestInterceptChainOrder(t *testing.T) {
+	// upper runs first, so the denial sees the uppercased data
+	c, backend := interceptPair(t, upper, denyWord("FLUSH"))
+	if got := pipeWrite(t, c, backend, "get k"); got != "GET K" {
+		t.Fatalf("backend read %q", got)
+	}
+	if _, err := c.Write([]byte("flush")); errcode.Of(err, errcode.Normal) != errcode.PolicyDenied {
+		t.Fatalf("blocked write: %v", err)
+	}
+	// a block is for good, in both directions
+	if _, err := c.Write([]byte("get k")); errcode.Of(err, errcode.Normal) != errcode.PolicyDenied {
+		t.Errorf("write after the block: %v", err)
+	}
+	go backend.Write([]byte("reply"))
+	if _, err := c.Read(make([]byte, 8)); errcode.Of(err, errcode.Normal) != errcode.PolicyDenied {
+		t.Errorf("read after the block: %v", err)
+	}
+}
+
+func TestInterceptHoldBack(t *testing.T) {
+	// hold client data until a newline, as a protocol parser might
+	var held []byte
+	line := interceptFunc(func(toClient bool, p []byte) ([]byte, error) {
+		if toClient {
+			return p, nil
+		}
+		held = append(held, p...)
+		if !bytes.HasSuffix(held, []byte("\n")) {
+			return nil, nil
+		}
+		out := held
+		held = nil
+		return out, nil
+	})
+	c, backend := interceptPair(t, line)
+	if n, err := c.Write([]byte("PI")); n != 2 || err != nil {
+		t.Fatalf("held write = %d, %v; want all of it reported written", n, err)
+	}
+	if got := pipeWrite(t, c, backend, "NG\n"); got != "PING\n" {
+		t.Errorf("backend read %q", got)
+	}
+}
+
+func TestInterceptBackendOutput(t *testing.T) {
+	c, backend := interceptPair(t, denyWord("secret"))
+	go func() {
+		backend.Write([]byte("hello"))
+		backend.Write([]byte("secret"))
+		backend.Close()
+	}()
+	buf := make([]byte, 16)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+	if _, err := io.ReadAll(c); errcode.Of(err, errcode.Normal) != errcode.PolicyDenied {
+		t.Errorf("denied output: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/logger"
+)
+
+const (
+	pollWindow      = 64        // upstream POSTs accepted ahead of the next one
+	pollChunk       = 64 * 1024 // most downstream bytes handed out per Next
+	pollDefaultSize = 1 << 20   // default buffer per direction
+)
+
+var (
+	// ErrPollSeq is returned by Push for a POST too far ahead of the next
+	// expected sequence number
+	ErrPollSeq = errors.New("upstream sequence out of window")
+	// ErrPollFull is returned by Push while the upstream buffer is full
+	ErrPollFull = errors.New("upstream buffer full")
+	// ErrPollOffset is returned by Next for an offset that is no longer,
+	// or not yet, buffered
+	ErrPollOffset = errors.New("downstream offset not buffered")
+)
+
+// PollConn is the client side of an HTTP fallback tunnel, for networks
+// that strip Upgrade headers. Upstream arrives as sequenced POST bodies,
+// applied in order whatever order they arrive in. Downstream is kept until
+// the client acknowledges it, so a streamed GET that drops can resume
+// where it stopped; unacknowledged bytes hold up the backend once the
+// buffer is full.
+type PollConn struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every state change
+
+	up      bytes.Buffer      // upstream bytes not yet read by the bridge
+	nextSeq uint64            // next upstream POST to apply
+	early   map[uint64][]byte // POSTs that arrived ahead of nextSeq
+
+	down     []byte // unacknowledged downstream, starting at offset downBase
+	downBase uint64
+	size     int // limit for up and for down
+
+	deadline    time.Time // read deadline
+	deadlineSet time.Time // when deadline was set
+	lastUp      time.Time // last upstream POST, even an empty one
+	closed      bool
+	lastSeen    time.Time // last request of any kind
+
+	local, remote net.Addr
+}
+
+// NewPollConn returns an open session buffering up to size bytes in each
+// direction (default 1 MiB). The addresses are those of the creating
+// request.
+func NewPollConn(local, remote net.Addr, size int) *PollConn {
+	if size <= 0 {
+		size = pollDefaultSize
+	}
+	return &PollConn{
+		changed:  make(chan struct{}),
+		early:    make(map[uint64][]byte),
+		size:     size,
+		lastSeen: time.Now(),
+		local:    local,
+		remote:   remote,
+	}
+}
+
+// NewPollBridge starts a bridge between a poll session and a backend; like
+// a WS bridge, IdleTimeout closes it when no data moves
+func NewPollBridge(p *PollConn, tcpConn net.Conn, cfg *Config) *Bridge {
+	b := &Bridge{
+		bridgeType: PollBridge,
+		client:     p,
+		tcpConn:    tcpConn,
+		cfg:        cfg,
+		rec:        cfg.recorder(),
+		log:        logger.New("bridge"),
+		dialed:     make(chan struct{}),
+		ended:      make(chan struct{}),
+	}
+	b.markDialed()
+	b.startTCP()
+	watch(b.cfg, &b.act, b.ended, b.shutdown)
+	return b
+}
+
+// broadcast wakes every waiter; callers hold mu
+func (c *PollConn) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Push applies upstream POST seq. Repeated sequence numbers (a retry whose
+// first response was lost) are accepted and ignored.
+func (c *PollConn) Push(seq uint64, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSeen = time.Now()
+	switch {
+	case c.closed:
+		return net.ErrClosed
+	case len(data) == 0:
+		c.lastUp = c.lastSeen // keepalive
+		return nil
+	case seq < c.nextSeq:
+		return nil
+	case seq >= c.nextSeq+pollWindow:
+		return ErrPollSeq
+	case c.up.Len()+len(data) > c.size:
+		return ErrPollFull
+	case seq > c.nextSeq:
+		c.early[seq] = bytes.Clone(data)
+		return nil
+	}
+	c.lastUp = c.lastSeen
+	c.up.Write(data)
+	c.nextSeq++
+	for {
+		next, ok := c.early[c.nextSeq]
+		if !ok {
+			break
+		}
+		delete(c.early, c.nextSeq)
+		c.up.Write(next)
+		c.nextSeq++
+	}
+	c.broadcast()
+	return nil
+}
+
+// Ack releases downstream bytes before off, which the client confirms it
+// has. Acks for already released bytes are ignored.
+func (c *PollConn) Ack(off uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSeen = time.Now()
+	if off > c.downBase+uint64(len(c.down)) {
+		return ErrPollOffset
+	}
+	if off > c.downBase {
+		c.down = c.down[off-c.downBase:]
+		c.downBase = off
+		c.broadcast()
+	}
+	return nil
+}
+
+// Next returns downstream bytes from offset off, waiting until there are
+// some. It does not release them: a GET that drops may not have delivered
+// what it wrote. After Close it returns what is left, then io.EOF.
+func (c *PollConn) Next(ctx context.Context, off uint64) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		end := c.downBase + uint64(len(c.down))
+		if off < c.downBase || off > end {
+			return nil, ErrPollOffset
+		}
+		if off < end {
+			return bytes.Clone(c.down[off-c.downBase : min(end-c.downBase, off-c.downBase+pollChunk)]), nil
+		}
+		if c.closed {
+			return nil, io.EOF
+		}
+		ch := c.changed
+		c.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			c.mu.Lock()
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+}
+
+// LastSeen is the time of the last Push or Ack
+func (c *PollConn) LastSeen() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSeen
+}
+
+// Touch records client activity, e.g. a keepalive on an idle GET
+func (c *PollConn) Touch() {
+	c.mu.Lock()
+	c.lastSeen = time.Now()
+	c.mu.Unlock()
+}
+
+// Read returns upstream bytes to the bridge. Any POST since the read
+// deadline was set moves it forward, as a WS message would, so empty
+// POSTs keep an idle session open.
+func (c *PollConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.up.Len() == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		var wait time.Duration
+		if !c.deadline.IsZero() {
+			if c.lastUp.After(c.deadlineSet) {
+				c.deadline = c.deadline.Add(c.lastUp.Sub(c.deadlineSet))
+				c.deadlineSet = c.lastUp
+			}
+			if wait = time.Until(c.deadline); wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+		}
+		ch := c.changed
+		c.mu.Unlock()
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ch:
+			case <-t.C:
+			}
+			t.Stop()
+		} else {
+			<-ch
+		}
+		c.mu.Lock()
+	}
+	return c.up.Read(p)
+}
+
+// Write queues downstream bytes, blocking while the client is a full
+// buffer behind
+func (c *PollConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if c.closed {
+			return n, net.ErrClosed
+		}
+		if space := c.size - len(c.down); space > 0 {
+			k := min(space, len(p)-n)
+			c.down = append(c.down, p[n:n+k]...)
+			n += k
+			c.broadcast()
+			continue
+		}
+		ch := c.changed
+		c.mu.Unlock()
+		<-ch
+		c.mu.Lock()
+	}
+	return n, nil
+}
+
+// Close ends the session. Buffered downstream stays readable via Next.
+func (c *PollConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.broadcast()
+	}
+	return nil
+}
+
+func (c *PollConn) LocalAddr() net.Addr  { return c.local }
+func (c *PollConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *PollConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *PollConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline, c.deadlineSet = t, time.Now()
+	c.broadcast()
+	return nil
+}
+
+// SetWriteDeadline is not supported: Write waits for the client to catch
+// up or for Close
+func (c *PollConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,155 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPollPushOrder(t *testing.T) {
+	c := NewPollConn(nil, nil, 16)
+	// out of order, plus a retried duplicate
+	for _, p := range []struct {
+		seq  uint64
+		data string
+	}{{1, "world"}, {0, "hello "}, {0, "hello "}} {
+		if err := c.Push(p.seq, []byte(p.data)); err != nil {
+			t.Fatalf("Push %d: %v", p.seq, err)
+		}
+	}
+	got := make([]byte, 32)
+	n, err := c.Read(got)
+	if err != nil || string(got[:n]) != "hello world" {
+		t.Fatalf("Read %q, %v", got[:n], err)
+	}
+
+	if err := c.Push(2+pollWindow, []byte("x")); !errors.Is(err, ErrPollSeq) {
+		t.Errorf("Push beyond the window: %v", err)
+	}
+	if err := c.Push(2, make([]byte, 17)); !errors.Is(err, ErrPollFull) {
+		t.Errorf("Push beyond the buffer: %v", err)
+	}
+}
+
+func TestPollNextAck(t *testing.T) {
+	c := NewPollConn(nil, nil, 8)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the second half only fits once the first is acknowledged
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("0123456789abcdef"))
+		written <- err
+	}()
+	got, err := c.Next(ctx, 0)
+	if err != nil || string(got) != "01234567" {
+		t.Fatalf("Next(0) = %q, %v", got, err)
+	}
+	// Next does not release: a dropped GET resumes from the same offset
+	if again, _ := c.Next(ctx, 4); string(again) != "4567" {
+		t.Fatalf("Next(4) = %q", again)
+	}
+	if _, err := c.Next(ctx, 9); !errors.Is(err, ErrPollOffset) {
+		t.Fatalf("Next past the end: %v", err)
+	}
+	select {
+	case <-written:
+		t.Fatal("Write finished before the client acknowledged")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := c.Ack(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Next(ctx, 0); !errors.Is(err, ErrPollOffset) {
+		t.Fatalf("Next before the acknowledged offset: %v", err)
+	}
+	if got, err := c.Next(ctx, 8); err != nil || string(got) != "89abcdef" {
+		t.Fatalf("Next(8) = %q, %v", got, err)
+	}
+	if err := c.Ack(100); !errors.Is(err, ErrPollOffset) {
+		t.Fatalf("Ack past the end: %v", err)
+	}
+}
+
+func TestPollClose(t *testing.T) {
+	c := NewPollConn(nil, nil, 0)
+	if _, err := c.Write([]byte("left")); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// buffered downstream stays readable after Close
+	ctx := context.Background()
+	if got, err := c.Next(ctx, 0); err != nil || string(got) != "left" {
+		t.Fatalf("Next after Close = %q, %v", got, err)
+	}
+	if _, err := c.Next(ctx, 4); err != io.EOF {
+		t.Fatalf("Next at the end after Close: %v", err)
+	}
+	if _, err := c.Read(make([]byte, 4)); err != io.EOF {
+		t.Fatalf("Read after Close: %v", err)
+	}
+	if err := c.Push(0, []byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Push after Close: %v", err)
+	}
+}
+
+// TestPollReadDeadline checks that empty POSTs move the read deadline, as
+// WS messages would
+func TestPollReadDeadline(t *testing.T) {
+	c := NewPollConn(nil, nil, 0)
+	start := time.Now()
+	_ = c.SetReadDeadline(start.Add(100 * time.Millisecond))
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_ = c.Push(0, nil)
+		}
+	}()
+	_, err := c.Read(make([]byte, 4))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read: %v", err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("deadline hit after %v despite keepalives", d)
+	}
+}
+
+func TestPollBridge(t *testing.T) {
+	c := NewPollConn(nil, nil, 0)
+	backend, err := net.Dial("tcp", echoAddr(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewPollBridge(c, backend, &Config{})
+	defer c.Close()
+
+	payload := bytes.Repeat([]byte("poll"), 1000)
+	if err := c.Push(0, payload); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []byte
+	for len(got) < len(payload) {
+		p, err := c.Next(ctx, uint64(len(got)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p...)
+		_ = c.Ack(uint64(len(got)))
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("echo mismatch")
+	}
+}
@@ -0,0 +1,256 @@
+package bridge
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthOptions controls backend health tracking in TCPPool
+type HealthOptions struct {
+	Interval      time.Duration // active TCP probe period (0 = passive tracking only)
+	Timeout       time.Duration // probe dial timeout (default 2s)
+	FailThreshold int           // consecutive failures before ejection (default 1)
+	BaseBackoff   time.Duration // first ejection period (default 1s)
+	MaxBackoff    time.Duration // ejection period cap (default 1m)
+}
+
+func (o HealthOptions) withDefaults() HealthOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.FailThreshold <= 0 {
+		o.FailThreshold = 1
+	}
+	if o.BaseBackoff <= 0 {
+		o.BaseBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Minute
+	}
+	return o
+}
+
+// backendHealth is the tracked state of one backend address
+type backendHealth struct {
+	failures     int // consecutive
+	ejections    int // consecutive ejections, drives the backoff
+	ejectedUntil time.Time
+	lastErr      string
+	lastCheck    time.Time
+}
+
+// BackendStatus is a snapshot of one backend's health
+type BackendStatus struct {
+	Target       string    `json:"target"` // logical target
+	Backend      string    `json:"backend"`
+	Healthy      bool      `json:"healthy"`
+	Failures     int       `json:"consecutive_failures"`
+	EjectedUntil time.Time `json:"ejected_until"` // zero when healthy
+	LastError    string    `json:"last_error,omitempty"`
+	LastCheck    time.Time `json:"last_check"`
+	Idle         int       `json:"idle"`   // pooled connections
+	Active       int64     `json:"active"` // connections currently handed out
+}
+
+// ejected reports whether the backend is currently out of rotation; p.mu held
+func (p *TCPPool) ejected(backend string, now time.Time) bool {
+	h, ok := p.health[backend]
+	return ok && now.Before(h.ejectedUntil)
+}
+
+// markSuccess clears failure state for backend
+func (p *TCPPool) markSuccess(backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.healthFor(backend)
+	if h.ejections > 0 {
+		p.log.Info("backend %s healthy again", backend)
+	}
+	h.failures = 0
+	h.ejections = 0
+	h.ejectedUntil = time.Time{}
+	h.lastErr = ""
+	h.lastCheck = time.Now()
+}
+
+// markFailure counts a failure and ejects the backend with exponential backoff
+func (p *TCPPool) markFailure(backend string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	h := p.healthFor(backend)
+	h.failures++
+	h.lastErr = err.Error()
+	h.lastCheck = now
+	if h.failures < p.healthOpts.FailThreshold || now.Before(h.ejectedUntil) {
+		return
+	}
+	backoff := p.healthOpts.BaseBackoff << min(h.ejections, 16)
+	if backoff > p.healthOpts.MaxBackoff || backoff <= 0 {
+		backoff = p.healthOpts.MaxBackoff
+	}
+	h.ejections++
+	h.ejectedUntil = now.Add(backoff)
+	p.log.Error("backend %s ejected for %s: %v", backend, backoff, err)
+}
+
+// healthFor returns (creating) the state for backend; p.mu held
+func (p *TCPPool) healthFor(backend string) *backendHealth {
+	h, ok := p.health[backend]
+	if !ok {
+		h = &backendHealth{}
+		p.health[backend] = h
+	}
+	return h
+}
+
+// StartHealthChecks enables health tracking options and, if opts.Interval
+// is set, actively probes every registered backend until Close.
+func (p *TCPPool) StartHealthChecks(opts HealthOptions) {
+	p.mu.Lock()
+	p.healthOpts = opts.withDefaults()
+	p.mu.Unlock()
+	if opts.Interval <= 0 {
+		return
+	}
+	go p.probeLoop(opts.Interval)
+}
+
+func (p *TCPPool) probeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.probeAll()
+		}
+	}
+}
+
+// probeAll dials every registered backend once, concurrently so that a
+// blackholed backend cannot delay the others by its timeout
+func (p *TCPPool) probeAll() {
+	p.mu.Lock()
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, backends := range p.backends {
+		for _, b := range backends {
+			if !seen[b] {
+				seen[b] = true
+				addrs = append(addrs, b)
+			}
+		}
+	}
+	timeout := p.healthOpts.Timeout
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := net.DialTimeout("tcp", addr, timeout)
+			if err != nil {
+				p.markFailure(addr, err)
+				return
+			}
+			c.Close()
+			p.markSuccess(addr)
+		}()
+	}
+	wg.Wait()
+}
+
+// Status returns the health of every registered backend, sorted by target
+func (p *TCPPool) Status() []BackendStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+
+	var out []BackendStatus
+	add := func(logical, backend string) {
+		st := BackendStatus{Target: logical, Backend: backend, Healthy: true, Idle: len(p.conns[backend])}
+		if c, ok := p.stats[backend]; ok {
+			st.Active = c.active
+		}
+		if h, ok := p.health[backend]; ok {
+			st.Healthy = !now.Before(h.ejectedUntil)
+			st.Failures = h.failures
+			st.LastError = h.lastErr
+			st.LastCheck = h.lastCheck
+			if !st.Healthy {
+				st.EjectedUntil = h.ejectedUntil
+			}
+		}
+		out = append(out, st)
+	}
+	for logical, backends := range p.backends {
+		for _, b := range backends {
+			add(logical, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Target != out[j].Target {
+			return out[i].Target < out[j].Target
+		}
+		return out[i].Backend < out[j].Backend
+	})
+	return out
+}
+
+// connAlive reports whether an idle pooled conn is still usable and
+// returns the conn to hand out. A peer that closed the conn or failed it is
+// dead. Data waiting on a fresh (pre-warmed) conn is a greeting from a
+// backend that speaks first, such as an SSH or SMTP banner: it is kept and
+// replayed to the first reader. Data waiting on a conn returned with Put
+// belongs to the previous client, so that conn is dead.
+func connAlive(ic idleConn) (net.Conn, bool) {
+	c := ic.Conn
+	if err := c.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return nil, false
+	}
+	buf := make([]byte, peekSize)
+	n, err := c.Read(buf)
+	_ = c.SetReadDeadline(time.Time{})
+	switch {
+	case n == 0:
+		return c, errors.Is(err, os.ErrDeadlineExceeded)
+	case ic.reused:
+		return nil, false
+	case err != nil && !errors.Is(err, os.ErrDeadlineExceeded):
+		return nil, false // greeting followed by EOF or a reset
+	}
+	return &peekedConn{Conn: c, buf: buf[:n]}, true
+}
+
+// peekSize bounds the greeting read from a fresh conn by connAlive
+const peekSize = 4096
+
+// peekedConn replays bytes read by connAlive before reading from Conn
+type peekedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// CloseWrite half-closes the underlying conn
+func (c *peekedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
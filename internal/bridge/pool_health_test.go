@@ -0,0 +1,186 @@
+package bridge
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var errProbe = errors.New("probe failed")
+
+// deadAddr returns a loopback address that refuses connections
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// liveAddr returns a loopback address that accepts and closes connections
+func liveAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func backendStatus(t *testing.T, p *TCPPool, backend string) BackendStatus {
+	t.Helper()
+	for _, st := range p.Status() {
+		if st.Backend == backend {
+			return st
+		}
+	}
+	t.Fatalf("backend %s not registered", backend)
+	return BackendStatus{}
+}
+
+// ejectedFor returns the length of the backend's current ejection
+func ejectedFor(p *TCPPool, backend string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.health[backend]
+	return h.ejectedUntil.Sub(h.lastCheck)
+}
+
+// expireEjection ends the backend's ejection period now
+func expireEjection(p *TCPPool, backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.health[backend].ejectedUntil = time.Now().Add(-time.Millisecond)
+}
+
+func TestEjectAfterFailThreshold(t *testing.T) {
+	p := NewTCPPool(4)
+	defer p.Close()
+	p.AddTargets("svc", []string{"a:1", "b:1"})
+	p.StartHealthChecks(HealthOptions{FailThreshold: 2, BaseBackoff: time.Hour})
+
+	p.markFailure("a:1", errProbe)
+	if st := backendStatus(t, p, "a:1"); !st.Healthy || st.Failures != 1 {
+		t.Fatalf("after 1 failure: healthy %v, failures %d", st.Healthy, st.Failures)
+	}
+	p.markFailure("a:1", errProbe)
+	st := backendStatus(t, p, "a:1")
+	if st.Healthy || st.EjectedUntil.IsZero() || st.LastError != errProbe.Error() {
+		t.Fatalf("after 2 failures: %+v, want ejected", st)
+	}
+
+	// an ejected backend is only tried after the healthy ones
+	for i := 0; i < 4; i++ {
+		list, _, err := p.candidates("svc", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0] != "b:1" || list[1] != "a:1" {
+			t.Fatalf("candidates %v, want [b:1 a:1]", list)
+		}
+	}
+}
+
+func TestEjectionBackoff(t *testing.T) {
+	p := NewTCPPool(4)
+	defer p.Close()
+	p.AddTargets("svc", []string{"a:1"})
+	p.StartHealthChecks(HealthOptions{BaseBackoff: time.Second, MaxBackoff: 3 * time.Second})
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		p.markFailure("a:1", errProbe)
+		if got := ejectedFor(p, "a:1"); got != want {
+			t.Fatalf("ejected for %s, want %s", got, want)
+		}
+		// failures during an ejection do not extend it
+		p.markFailure("a:1", errProbe)
+		if got := ejectedFor(p, "a:1"); got <= 0 || got > want {
+			t.Fatalf("failure while ejected moved ejection to %s", got)
+		}
+		expireEjection(p, "a:1")
+	}
+}
+
+func TestReadmitOnSuccess(t *testing.T) {
+	p := NewTCPPool(4)
+	defer p.Close()
+	p.AddTargets("svc", []string{"a:1"})
+	p.StartHealthChecks(HealthOptions{BaseBackoff: time.Second})
+
+	p.markFailure("a:1", errProbe)
+	expireEjection(p, "a:1")
+	p.markFailure("a:1", errProbe)
+	if got := ejectedFor(p, "a:1"); got != 2*time.Second {
+		t.Fatalf("second ejection %s, want 2s", got)
+	}
+
+	p.markSuccess("a:1")
+	st := backendStatus(t, p, "a:1")
+	if !st.Healthy || st.Failures != 0 || st.LastError != "" {
+		t.Fatalf("after success: %+v, want healthy", st)
+	}
+	// backoff starts over once the backend has recovered
+	p.markFailure("a:1", errProbe)
+	if got := ejectedFor(p, "a:1"); got != time.Second {
+		t.Fatalf("ejection after recovery %s, want 1s", got)
+	}
+}
+
+func TestActiveProbes(t *testing.T) {
+	live, dead := liveAddr(t), deadAddr(t)
+	p := NewTCPPool(4)
+	defer p.Close()
+	p.AddTargets("svc", []string{live, dead})
+	p.StartHealthChecks(HealthOptions{Interval: 10 * time.Millisecond, Timeout: time.Second, BaseBackoff: time.Hour})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l, d := backendStatus(t, p, live), backendStatus(t, p, dead)
+		if !l.LastCheck.IsZero() && !d.LastCheck.IsZero() {
+			if !l.Healthy || d.Healthy {
+				t.Fatalf("live healthy %v, dead healthy %v", l.Healthy, d.Healthy)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("backends were not probed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the dead backend comes back: the next probe re-admits it
+	ln, err := net.Listen("tcp", dead)
+	if err != nil {
+		t.Skipf("cannot rebind %s: %v", dead, err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	for !backendStatus(t, p, dead).Healthy {
+		if time.Now().After(deadline) {
+			t.Fatal("recovered backend was not re-admitted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
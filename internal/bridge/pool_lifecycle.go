@@ -0,0 +1,237 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// PoolOptions controls pooled connection lifetime and per-backend limits
+type PoolOptions struct {
+	IdleTimeout time.Duration // close conns idle in the pool this long (0 = never)
+	MaxLifetime time.Duration // close pooled conns this old since dial (0 = never)
+	MaxConns    int           // open conns per backend, idle + in use (0 = unlimited)
+	Prewarm     int           // keep this many fresh conns per registered backend; Put never reuses
+}
+
+// idleConn is a pooled connection with its age
+type idleConn struct {
+	net.Conn
+	created time.Time // dialed
+	since   time.Time // returned to the pool
+	reused  bool      // returned with Put after serving a client
+}
+
+// poolCounters is the per-backend accounting behind PoolStats
+type poolCounters struct {
+	active   int64 // handed out
+	open     int   // idle + handed out + dialing
+	hits     uint64
+	misses   uint64
+	evicted  uint64
+	rejected uint64
+}
+
+// PoolStats is a snapshot of pool activity for one backend address
+type PoolStats struct {
+	Backend  string `json:"backend"`
+	Idle     int    `json:"idle"`
+	Active   int64  `json:"active"`
+	Open     int    `json:"open"`
+	Hits     uint64 `json:"hits"`     // served from the pool
+	Misses   uint64 `json:"misses"`   // dialed on demand
+	Evicted  uint64 `json:"evicted"`  // idle conns closed as expired or dead
+	Rejected uint64 `json:"rejected"` // dials refused by MaxConns
+}
+
+// counters returns (creating) the accounting for backend; p.mu held
+func (p *TCPPool) counters(backend string) *poolCounters {
+	c, ok := p.stats[backend]
+	if !ok {
+		c = &poolCounters{}
+		p.stats[backend] = c
+	}
+	return c
+}
+
+// reserve claims an open-connection slot for a dial to backend
+func (p *TCPPool) reserve(backend string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	c := p.counters(backend)
+	if p.opts.MaxConns > 0 && c.open >= p.opts.MaxConns {
+		c.rejected++
+		return fmt.Errorf("%s: %w (%d)", backend, ErrPoolExhausted, p.opts.MaxConns)
+	}
+	c.open++
+	return nil
+}
+
+// unreserve gives back a slot after a failed dial
+func (p *TCPPool) unreserve(backend string) {
+	p.mu.Lock()
+	p.counters(backend).open--
+	p.mu.Unlock()
+}
+
+// expired reports whether an idle conn outlived IdleTimeout or MaxLifetime; p.mu held
+func (p *TCPPool) expired(ic idleConn, now time.Time) bool {
+	return (p.opts.IdleTimeout > 0 && now.Sub(ic.since) >= p.opts.IdleTimeout) ||
+		(p.opts.MaxLifetime > 0 && now.Sub(ic.created) >= p.opts.MaxLifetime)
+}
+
+// evict closes an idle conn already removed from the pool
+func (p *TCPPool) evict(backend string, ic idleConn) {
+	p.mu.Lock()
+	c := p.counters(backend)
+	c.open--
+	c.evicted++
+	p.mu.Unlock()
+	ic.Conn.Close()
+}
+
+// StartMaintenance applies opts and, when idle expiry or pre-warming is
+// enabled, runs the eviction and refill loop until Close
+func (p *TCPPool) StartMaintenance(opts PoolOptions) {
+	p.mu.Lock()
+	p.opts = opts
+	p.mu.Unlock()
+	if opts.IdleTimeout <= 0 && opts.MaxLifetime <= 0 && opts.Prewarm <= 0 {
+		return
+	}
+	go p.maintainLoop(sweepInterval(opts))
+}
+
+// sweepInterval checks at least twice per timeout, and every 30s otherwise
+func sweepInterval(opts PoolOptions) time.Duration {
+	d := 30 * time.Second
+	for _, t := range []time.Duration{opts.IdleTimeout, opts.MaxLifetime} {
+		if t > 0 {
+			d = min(d, t/2)
+		}
+	}
+	return max(d, 10*time.Millisecond)
+}
+
+func (p *TCPPool) maintainLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	p.prewarm()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.evictExpired()
+			p.prewarm()
+		case <-p.refill:
+			p.prewarm()
+		}
+	}
+}
+
+// wakeRefill nudges the maintenance loop to replace a taken conn
+func (p *TCPPool) wakeRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// evictExpired closes idle conns past IdleTimeout or MaxLifetime
+func (p *TCPPool) evictExpired() {
+	now := time.Now()
+	var stale []net.Conn
+	p.mu.Lock()
+	for backend, conns := range p.conns {
+		keep := conns[:0]
+		for _, ic := range conns {
+			if p.expired(ic, now) {
+				stale = append(stale, ic.Conn)
+				c := p.counters(backend)
+				c.open--
+				c.evicted++
+			} else {
+				keep = append(keep, ic)
+			}
+		}
+		clear(conns[len(keep):])
+		p.conns[backend] = keep
+	}
+	p.mu.Unlock()
+
+	for _, c := range stale {
+		c.Close()
+	}
+	if len(stale) > 0 {
+		p.log.Debug("evicted %d expired idle connections", len(stale))
+	}
+}
+
+// prewarm tops up every healthy registered backend to opts.Prewarm idle conns
+func (p *TCPPool) prewarm() {
+	p.mu.Lock()
+	want := p.opts.Prewarm
+	timeout := p.healthOpts.Timeout
+	now := time.Now()
+	need := make(map[string]int)
+	for _, backends := range p.backends {
+		for _, b := range backends {
+			if n := want - len(p.conns[b]); n > 0 && !p.ejected(b, now) {
+				need[b] = n
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	for backend, n := range need {
+		for ; n > 0; n-- {
+			if p.reserve(backend) != nil {
+				break
+			}
+			c, err := net.DialTimeout("tcp", backend, timeout)
+			if err != nil {
+				p.unreserve(backend)
+				p.markFailure(backend, err)
+				break
+			}
+			p.markSuccess(backend)
+
+			p.mu.Lock()
+			if p.closed {
+				p.counters(backend).open--
+				p.mu.Unlock()
+				c.Close()
+				return
+			}
+			now := time.Now()
+			p.conns[backend] = append(p.conns[backend], idleConn{Conn: c, created: now, since: now})
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns pool counters for every backend that has been used, sorted
+func (p *TCPPool) Stats() []PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]PoolStats, 0, len(p.stats))
+	for backend, c := range p.stats {
+		out = append(out, PoolStats{
+			Backend:  backend,
+			Idle:     len(p.conns[backend]),
+			Active:   c.active,
+			Open:     c.open,
+			Hits:     c.hits,
+			Misses:   c.misses,
+			Evicted:  c.evicted,
+			Rejected: c.rejected,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Backend < out[j].Backend })
+	return out
+}
@@ -0,0 +1,216 @@
+package bridge
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// serveAddr returns a loopback address whose conns are handled by fn
+func serveAddr(t *testing.T, fn func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				fn(c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// echoAddr returns a loopback TCP echo server address
+func echoAddr(t *testing.T) string {
+	return serveAddr(t, func(c net.Conn) { io.Copy(c, c) })
+}
+
+func poolStats(p *TCPPool, backend string) PoolStats {
+	for _, st := range p.Stats() {
+		if st.Backend == backend {
+			return st
+		}
+	}
+	return PoolStats{Backend: backend}
+}
+
+// waitPool polls the backend's stats until ok holds
+func waitPool(t *testing.T, p *TCPPool, backend string, ok func(PoolStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := poolStats(p, backend)
+		if ok(st) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool stats %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPoolReuseAndIdleEviction(t *testing.T) {
+	echo := echoAddr(t)
+	p := NewTCPPool(2)
+	defer p.Close()
+	p.StartMaintenance(PoolOptions{IdleTimeout: 50 * time.Millisecond})
+
+	c, err := p.Get(echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(echo, c)
+	if c, err = p.Get(echo); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(echo, c)
+	if st := poolStats(p, echo); st.Hits != 1 || st.Misses != 1 {
+		t.Fatalf("hits=%d misses=%d, want 1 and 1", st.Hits, st.Misses)
+	}
+	waitPool(t, p, echo, func(st PoolStats) bool {
+		return st.Idle == 0 && st.Open == 0 && st.Evicted == 1
+	})
+}
+
+func TestPoolMaxConns(t *testing.T) {
+	echo := echoAddr(t)
+	p := NewTCPPool(2)
+	defer p.Close()
+	p.StartMaintenance(PoolOptions{MaxConns: 1})
+
+	c, err := p.Get(echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(echo); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("second conn: %v, want %v", err, ErrPoolExhausted)
+	}
+	c.Close()
+	if c, err = p.Get(echo); err != nil {
+		t.Fatalf("after close: %v", err)
+	}
+	c.Close()
+	if st := poolStats(p, echo); st.Rejected != 1 || st.Open != 0 {
+		t.Fatalf("rejected=%d open=%d, want 1 and 0", st.Rejected, st.Open)
+	}
+}
+
+func TestPoolPrewarm(t *testing.T) {
+	echo := echoAddr(t)
+	p := NewTCPPool(2)
+	defer p.Close()
+	p.AddTargets("prewarmed", []string{echo})
+	p.StartMaintenance(PoolOptions{Prewarm: 2})
+
+	full := func(st PoolStats) bool { return st.Idle == 2 && st.Open == 2 }
+	waitPool(t, p, echo, full)
+	c, err := p.Get("prewarmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// returned conns are never reused: the pool refills with fresh ones
+	p.Put("prewarmed", c)
+	waitPool(t, p, echo, full)
+	if st := poolStats(p, echo); st.Hits != 1 || st.Misses != 0 {
+		t.Fatalf("hits=%d misses=%d, want 1 and 0", st.Hits, st.Misses)
+	}
+}
+
+func TestPoolPrewarmServerFirst(t *testing.T) {
+	const banner = "SSH-2.0-OpenSSH_9.6\r\n"
+	backend := serveAddr(t, func(c net.Conn) {
+		io.WriteString(c, banner)
+		io.Copy(c, c)
+	})
+	p := NewTCPPool(2)
+	defer p.Close()
+	p.AddTargets("ssh", []string{backend})
+	p.StartMaintenance(PoolOptions{Prewarm: 1})
+
+	waitPool(t, p, backend, func(st PoolStats) bool { return st.Idle == 1 })
+	time.Sleep(20 * time.Millisecond) // let the banner arrive while pooled
+
+	c, err := p.Get("ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(banner))
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != banner {
+		t.Fatalf("greeting %q, %v; want %q", got, err, banner)
+	}
+	if _, err := io.WriteString(c, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(c, got[:4]); err != nil || string(got[:4]) != "ping" {
+		t.Fatalf("echo %q, %v", got[:4], err)
+	}
+	if st := poolStats(p, backend); st.Hits != 1 || st.Evicted != 0 {
+		t.Fatalf("hits=%d evicted=%d, want 1 and 0", st.Hits, st.Evicted)
+	}
+}
+
+func TestPoolReusedConnWithStaleData(t *testing.T) {
+	echo := echoAddr(t)
+	p := NewTCPPool(2)
+	defer p.Close()
+
+	c, err := p.Get(echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the echo of this write is still unread when the conn is returned
+	if _, err := io.WriteString(c, "leftover"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	p.Put(echo, c)
+
+	if c, err = p.Get(echo); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if st := poolStats(p, echo); st.Hits != 0 || st.Misses != 2 || st.Evicted != 1 {
+		t.Fatalf("hits=%d misses=%d evicted=%d, want 0, 2 and 1", st.Hits, st.Misses, st.Evicted)
+	}
+}
+
+func TestPoolCloseAll(t *testing.T) {
+	echo := echoAddr(t)
+	p := NewTCPPool(2)
+	idle, err := p.Get(echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inUse, err := p.Get(echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(echo, idle)
+	p.Close()
+
+	_ = inUse.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := inUse.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("in-use conn still open after Close: %v", err)
+	}
+	if _, err := p.Get(echo); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("get after close: %v, want %v", err, ErrPoolClosed)
+	}
+	if st := poolStats(p, echo); st.Open != 0 || st.Idle != 0 {
+		t.Fatalf("open=%d idle=%d after close", st.Open, st.Idle)
+	}
+}
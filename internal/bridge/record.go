@@ -0,0 +1,23 @@
+package bridge
+
+// Recorder captures a copy of a tunnel's data, such as a session
+// recording; toClient is backend output. The bridge closes it once both
+// directions have finished.
+type Recorder interface {
+	Record(toClient bool, p []byte)
+	Close() error
+}
+
+func (c *Config) recorder() Recorder {
+	if c == nil {
+		return nil
+	}
+	return c.Recorder
+}
+
+// record passes p to the bridge's recorder, if any
+func (b *Bridge) record(toClient bool, p []byte) {
+	if b.rec != nil {
+		b.rec.Record(toClient, p)
+	}
+}
@@ -0,0 +1,386 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/logger"
+)
+
+var (
+	// ErrResumeUnknown is returned by Attach for a token that never
+	// existed, expired or whose backend closed
+	ErrResumeUnknown = errors.New("unknown or expired resume token")
+	// ErrResumeOffset is returned by Attach when the client's offset is
+	// outside the replay buffer
+	ErrResumeOffset = errors.New("resume offset not in replay buffer")
+	// ErrDetached is returned by a superseded or closed attachment
+	ErrDetached = errors.New("tunnel detached")
+)
+
+// ResumeTable keeps the backends of resumable tunnels open while their
+// client is away. Each tunnel remembers the last downstream bytes it sent
+// so a client reconnecting with its received offset loses nothing.
+type ResumeTable struct {
+	mu       sync.Mutex
+	sessions map[string]*Resumable
+	grace    time.Duration
+	size     int
+	closed   bool
+	log      *logger.Logger
+}
+
+// NewResumeTable keeps detached backends for grace (default 30s) and
+// replays up to size downstream bytes (default 1 MiB)
+func NewResumeTable(grace time.Duration, size int) *ResumeTable {
+	if grace <= 0 {
+		grace = 30 * time.Second
+	}
+	if size <= 0 {
+		size = 1 << 20
+	}
+	return &ResumeTable{
+		sessions: make(map[string]*Resumable),
+		grace:    grace,
+		size:     size,
+		log:      logger.New("resume"),
+	}
+}
+
+// Resumable is a backend connection that outlives its transports. At most
+// one attachment at a time reads and writes it.
+type Resumable struct {
+	Token  string
+	Target string
+	Route  string // WS route path the tunnel was opened on; empty for client-chosen targets
+
+	table   *ResumeTable
+	backend net.Conn
+
+	mu       sync.Mutex
+	att      *Attachment
+	ring     []byte // last downstream bytes, ending at sent
+	sent     uint64 // downstream bytes handed to transports
+	received uint64 // upstream bytes written to the backend
+	downEOF  bool   // the backend sent FIN
+	upEOF    bool   // the client sent FIN, passed on with CloseWrite
+	timer    *time.Timer
+	done     bool
+}
+
+// New registers backend as a resumable tunnel opened on route and returns
+// its first attachment
+func (t *ResumeTable) New(target, route string, backend net.Conn) (*Attachment, error) {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	r := &Resumable{Token: hex.EncodeToString(b), Target: target, Route: route, table: t, backend: backend}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		backend.Close()
+		return nil, net.ErrClosed
+	}
+	t.sessions[r.Token] = r
+	return r.attach(0)
+}
+
+// Attach reconnects a client that has received offset downstream bytes.
+// The token only resumes on the route it was opened on, so a reattach
+// keeps that route's auth, timeouts and recording. An attachment still in
+// use (the old transport may not have noticed it is dead yet) is cut off
+// first.
+func (t *ResumeTable) Attach(token, route string, offset uint64) (*Attachment, error) {
+	t.mu.Lock()
+	r := t.sessions[token]
+	t.mu.Unlock()
+	if r == nil || r.Route != route {
+		return nil, ErrResumeUnknown
+	}
+	return r.attach(offset)
+}
+
+// Close ends every tunnel and refuses new ones
+func (t *ResumeTable) Close() {
+	t.mu.Lock()
+	t.closed = true
+	sessions := t.sessions
+	t.sessions = make(map[string]*Resumable)
+	t.mu.Unlock()
+	for _, r := range sessions {
+		r.finish()
+	}
+}
+
+// Len returns the number of tunnels, attached or waiting for a client
+func (t *ResumeTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sessions)
+}
+
+func (r *Resumable) attach(offset uint64) (*Attachment, error) {
+	r.mu.Lock()
+	old := r.att
+	r.mu.Unlock()
+	if old != nil {
+		old.detach()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return nil, ErrResumeUnknown
+	}
+	if r.att != nil && r.att != old {
+		return nil, ErrResumeUnknown // lost a race with another reconnect
+	}
+	start := r.sent - uint64(len(r.ring))
+	if offset < start || offset > r.sent {
+		return nil, ErrResumeOffset
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	a := &Attachment{r: r, replay: append([]byte(nil), r.ring[offset-start:]...), Received: r.received}
+	r.att = a
+	return a, nil
+}
+
+// detached starts the grace period after a's transport went away
+func (r *Resumable) detached(a *Attachment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.att != a || r.done {
+		return
+	}
+	r.att = nil
+	r.timer = time.AfterFunc(r.table.grace, func() {
+		r.table.log.Debug("resume token for %s expired", r.Target)
+		r.finish()
+	})
+}
+
+// finish closes the backend and forgets the tunnel
+func (r *Resumable) finish() {
+	r.mu.Lock()
+	if r.done {
+		r.mu.Unlock()
+		return
+	}
+	r.done = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+	r.backend.Close()
+
+	t := r.table
+	t.mu.Lock()
+	if t.sessions[r.Token] == r {
+		delete(t.sessions, r.Token)
+	}
+	t.mu.Unlock()
+}
+
+// halfClosed records one direction's EOF; the tunnel ends with the second
+func (r *Resumable) halfClosed(eof *bool) {
+	r.mu.Lock()
+	*eof = true
+	both := r.downEOF && r.upEOF
+	r.mu.Unlock()
+	if both {
+		r.finish()
+	}
+}
+
+// record appends downstream bytes to the replay ring
+func (r *Resumable) record(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent += uint64(len(p))
+	r.ring = append(r.ring, p...)
+	if over := len(r.ring) - r.table.size; over > 0 {
+		r.ring = append(r.ring[:0], r.ring[over:]...)
+	}
+}
+
+// Attachment is the net.Conn a transport bridge uses in place of the
+// backend. Closing it detaches the transport and leaves the backend open
+// for the grace period; an error or EOF from the backend ends the tunnel.
+type Attachment struct {
+	r *Resumable
+	// Received is how many upstream bytes the backend had been sent when
+	// this attachment was made; the client resends from there
+	Received uint64
+
+	mu       sync.Mutex
+	replay   []byte
+	detached bool
+	ops      sync.WaitGroup
+}
+
+// Line is the handshake reply telling the client its token and Received
+func (a *Attachment) Line() string {
+	return fmt.Sprintf("resume %s %d", a.r.Token, a.Received)
+}
+
+// Target is the backend target the tunnel was opened to
+func (a *Attachment) Target() string { return a.r.Target }
+
+func (a *Attachment) begin() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.detached {
+		return false
+	}
+	a.ops.Add(1)
+	return true
+}
+
+// Read replays what the client missed, then reads the backend. A backend
+// EOF is repeated to later attachments.
+func (a *Attachment) Read(p []byte) (int, error) {
+	if !a.begin() {
+		return 0, ErrDetached
+	}
+	defer a.ops.Done()
+	a.mu.Lock()
+	if len(a.replay) > 0 {
+		n := copy(p, a.replay)
+		a.replay = a.replay[n:]
+		a.mu.Unlock()
+		return n, nil
+	}
+	a.mu.Unlock()
+	a.r.mu.Lock()
+	eof := a.r.downEOF
+	a.r.mu.Unlock()
+	if eof {
+		return 0, io.EOF
+	}
+
+	n, err := a.r.backend.Read(p)
+	if n > 0 {
+		// recorded even if the transport then fails to deliver it
+		a.r.record(p[:n])
+	}
+	if err != nil && a.isDetached() {
+		return n, ErrDetached
+	}
+	if err == io.EOF {
+		a.r.halfClosed(&a.r.downEOF)
+	} else if err != nil {
+		a.r.finish()
+	}
+	return n, err
+}
+
+// Write sends upstream bytes to the backend
+func (a *Attachment) Write(p []byte) (int, error) {
+	if !a.begin() {
+		return 0, ErrDetached
+	}
+	defer a.ops.Done()
+	n, err := a.r.backend.Write(p)
+	a.r.mu.Lock()
+	a.r.received += uint64(n)
+	a.r.mu.Unlock()
+	if err != nil && a.isDetached() {
+		return n, ErrDetached
+	}
+	if err != nil {
+		a.r.finish()
+	}
+	return n, err
+}
+
+// CloseWrite passes the client's EOF on to the backend
+func (a *Attachment) CloseWrite() error {
+	if !a.begin() {
+		return ErrDetached
+	}
+	defer a.ops.Done()
+	cw, ok := a.r.backend.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return err
+	}
+	a.r.halfClosed(&a.r.upEOF)
+	return nil
+}
+
+func (a *Attachment) isDetached() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.detached
+}
+
+// detach interrupts a's pending backend I/O and waits for it, so the next
+// attachment starts from exact offsets
+func (a *Attachment) detach() {
+	a.mu.Lock()
+	if a.detached {
+		a.mu.Unlock()
+		return
+	}
+	a.detached = true
+	a.mu.Unlock()
+	b := a.r.backend
+	_ = b.SetDeadline(time.Now())
+	a.ops.Wait()
+	_ = b.SetDeadline(time.Time{})
+}
+
+// Close detaches the transport; the backend waits for a resume
+func (a *Attachment) Close() error {
+	a.detach()
+	a.r.detached(a)
+	return nil
+}
+
+// Done reports whether the tunnel has ended for good (backend closed or
+// grace expired), as opposed to waiting for a resume
+func (a *Attachment) Done() bool {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+	return a.r.done
+}
+
+func (a *Attachment) LocalAddr() net.Addr                { return a.r.backend.LocalAddr() }
+func (a *Attachment) RemoteAddr() net.Addr               { return a.r.backend.RemoteAddr() }
+func (a *Attachment) SetDeadline(t time.Time) error      { return nil }
+func (a *Attachment) SetReadDeadline(t time.Time) error  { return nil }
+func (a *Attachment) SetWriteDeadline(t time.Time) error { return nil }
+
+// ParseResumeLine parses a client's "resume new <target>" or
+// "resume <token> <offset>" handshake. ok is false for any other line.
+func ParseResumeLine(line string) (token, target string, offset uint64, ok bool, err error) {
+	rest, found := strings.CutPrefix(line, "resume ")
+	if !found {
+		return "", "", 0, false, nil
+	}
+	first, second, found := strings.Cut(strings.TrimSpace(rest), " ")
+	if !found || second == "" {
+		return "", "", 0, true, errors.New("resume: want \"resume new <target>\" or \"resume <token> <offset>\"")
+	}
+	if first == "new" {
+		return "", second, 0, true, nil
+	}
+	offset, err = strconv.ParseUint(second, 10, 64)
+	if err != nil {
+		return "", "", 0, true, fmt.Errorf("resume: bad offset %q", second)
+	}
+	return first, "", offset, true, nil
+}
@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newResumable opens a tunnel on route to one end of a pipe and returns
+// the other end as the backend's peer
+func newResumable(t *testing.T, table *ResumeTable, route string) (*Attachment, net.Conn) {
+	t.Helper()
+	backend, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+	a, err := table.New("backend:1", route, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, peer
+}
+
+func TestResumeReplay(t *testing.T) {
+	table := NewResumeTable(time.Minute, 0)
+	defer table.Close()
+	a, peer := newResumable(t, table, "/db")
+	go peer.Write([]byte("hello world"))
+	got := make([]byte, 11)
+	if _, err := io.ReadFull(a, got); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	// the client only kept "hello"
+	b, err := table.Attach(a.r.Token, "/db", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got, err := io.ReadAll(io.LimitReader(b, 6)); err != nil || string(got) != " world" {
+		t.Fatalf("replay %q, %v", got, err)
+	}
+	if b.Line() != "resume "+a.r.Token+" 0" {
+		t.Errorf("reattach line %q", b.Line())
+	}
+}
+
+func TestResumeAttachErrors(t *testing.T) {
+	table := NewResumeTable(time.Minute, 4)
+	defer table.Close()
+	a, peer := newResumable(t, table, "/db")
+	go peer.Write([]byte("0123456789"))
+	if _, err := io.ReadFull(a, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token that is unknown on this route leaves its transport alone
+	for _, route := range []string{"/", "/other"} {
+		if _, err := table.Attach(a.r.Token, route, 10); !errors.Is(err, ErrResumeUnknown) {
+			t.Errorf("Attach on %s: %v", route, err)
+		}
+	}
+	if _, err := table.Attach("0123", "/db", 10); !errors.Is(err, ErrResumeUnknown) {
+		t.Errorf("unknown token: %v", err)
+	}
+	go peer.Write([]byte("x"))
+	if _, err := io.ReadFull(a, make([]byte, 1)); err != nil {
+		t.Fatalf("attachment after refused attaches: %v", err)
+	}
+
+	// 11 bytes sent, the last 4 kept
+	for _, offset := range []uint64{6, 12} {
+		if _, err := table.Attach(a.r.Token, "/db", offset); !errors.Is(err, ErrResumeOffset) {
+			t.Errorf("offset %d: %v", offset, err)
+		}
+	}
+}
+
+func TestResumeSupersede(t *testing.T) {
+	table := NewResumeTable(time.Minute, 0)
+	defer table.Close()
+	a, _ := newResumable(t, table, "")
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := a.Read(make([]byte, 1))
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond) // let the Read block on the backend
+	b, err := table.Attach(a.r.Token, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := <-read; !errors.Is(err, ErrDetached) {
+		t.Fatalf("superseded Read: %v", err)
+	}
+	if _, err := a.Write([]byte("x")); !errors.Is(err, ErrDetached) {
+		t.Fatalf("superseded Write: %v", err)
+	}
+}
+
+func TestResumeGraceExpiry(t *testing.T) {
+	table := NewResumeTable(20*time.Millisecond, 0)
+	defer table.Close()
+	a, peer := newResumable(t, table, "")
+	a.Close()
+	if table.Len() != 1 || a.Done() {
+		t.Fatal("detached tunnel ended before the grace period")
+	}
+
+	// the backend is closed once nobody came back
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("backend after grace: %v", err)
+	}
+	if !a.Done() || table.Len() != 0 {
+		t.Fatal("expired tunnel still registered")
+	}
+	if _, err := table.Attach(a.r.Token, "", 0); !errors.Is(err, ErrResumeUnknown) {
+		t.Fatalf("Attach after grace: %v", err)
+	}
+}
+
+func TestParseResumeLine(t *testing.T) {
+	for _, tc := range []struct {
+		line, token, target string
+		offset              uint64
+		ok, err             bool
+	}{
+		{line: "db:5432"},
+		{line: "resume new db:5432", target: "db:5432", ok: true},
+		{line: "resume abcd 42", token: "abcd", offset: 42, ok: true},
+		{line: "resume abcd", ok: true, err: true},
+		{line: "resume abcd x", ok: true, err: true},
+	} {
+		token, target, offset, ok, err := ParseResumeLine(tc.line)
+		if token != tc.token || target != tc.target || offset != tc.offset || ok != tc.ok || (err != nil) != tc.err {
+			t.Errorf("ParseResumeLine(%q) = %q, %q, %d, %v, %v", tc.line, token, target, offset, ok, err)
+		}
+	}
+}
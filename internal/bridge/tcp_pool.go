@@ -1,27 +1,56 @@
 package bridge
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
-	"sync/atomic"
 	"time"
+
+	"github.com/DanielcoderX/anylink/internal/logger"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type TCPPool struct {
 	mu       sync.Mutex
-	conns    map[string][]net.Conn      // pooled connections per backend
-	backends map[string][]string        // logical target -> multiple backend addresses
-	counters map[string]*uint32         // round-robin counters per logical target
+	conns    map[string][]idleConn // pooled connections per backend
+	backends map[string][]string   // logical target -> multiple backend addresses
+	lbs      map[string]Balancer   // load-balancing policy per logical target
+	stats    map[string]*poolCounters
+	inUse    map[*pooledConn]struct{} // handed-out conns, closed by Close
 	maxSize  int
+	opts     PoolOptions
+	closed   bool
+
+	health     map[string]*backendHealth // per backend address
+	healthOpts HealthOptions
+	stop       chan struct{}
+	stopOnce   sync.Once
+	refill     chan struct{} // wakes the pre-warm loop after a take
+	log        *logger.Logger
 }
 
+// ErrPoolClosed is returned by Get after Close
+var ErrPoolClosed = errors.New("tcp pool closed")
+
+// ErrPoolExhausted is returned when a backend is at PoolOptions.MaxConns
+var ErrPoolExhausted = errors.New("backend connection limit reached")
+
 func NewTCPPool(max int) *TCPPool {
 	return &TCPPool{
-		conns:    make(map[string][]net.Conn),
-		backends: make(map[string][]string),
-		counters: make(map[string]*uint32),
-		maxSize:  max,
+		conns:      make(map[string][]idleConn),
+		backends:   make(map[string][]string),
+		lbs:        make(map[string]Balancer),
+		stats:      make(map[string]*poolCounters),
+		inUse:      make(map[*pooledConn]struct{}),
+		maxSize:    max,
+		health:     make(map[string]*backendHealth),
+		healthOpts: HealthOptions{}.withDefaults(),
+		stop:       make(chan struct{}),
+		refill:     make(chan struct{}, 1),
+		log:        logger.New("pool"),
 	}
 }
 
@@ -30,44 +59,285 @@ func (p *TCPPool) AddTargets(logical string, targets []string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.backends[logical] = targets
-	var zero uint32
-	p.counters[logical] = &zero
+	p.lbs[logical] = &roundRobin{}
+}
+
+// AddService registers a named logical target with weighted backends and
+// a load-balancing policy (see the Policy constants)
+func (p *TCPPool) AddService(logical string, backends []Backend, policy string) error {
+	if len(backends) == 0 {
+		return fmt.Errorf("service %s: no backends", logical)
+	}
+	backends = append([]Backend(nil), backends...)
+	addrs := make([]string, len(backends))
+	for i := range backends {
+		if backends[i].Weight < 0 {
+			return fmt.Errorf("service %s: negative weight for %s", logical, backends[i].Addr)
+		}
+		if backends[i].Weight == 0 {
+			backends[i].Weight = 1
+		}
+		addrs[i] = backends[i].Addr
+	}
+	lb, err := NewBalancer(policy, backends)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", logical, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backends[logical] = addrs
+	p.lbs[logical] = lb
+	return nil
+}
+
+// HasTarget reports whether logical was registered with AddTargets or AddService
+func (p *TCPPool) HasTarget(logical string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.backends[logical]
+	return ok
 }
 
 // Get returns a TCP connection to a backend for the logical target (round-robin)
 func (p *TCPPool) Get(logical string) (net.Conn, error) {
+	return p.GetContext(context.Background(), logical)
+}
+
+// GetContext is Get with a "backend.dial" span recording pool hit or miss
+func (p *TCPPool) GetContext(ctx context.Context, logical string) (net.Conn, error) {
+	return p.GetKeyed(ctx, logical, "")
+}
+
+// GetKeyed is GetContext with a client key (IP or identity) for sticky
+// consistent_hash services
+func (p *TCPPool) GetKeyed(ctx context.Context, logical, key string) (net.Conn, error) {
+	_, span := tracing.Start(ctx, "backend.dial", tracing.AttrTarget.String(logical))
+	defer span.End()
+
+	conn, backend, hit, err := p.get(logical, key)
+	span.SetAttributes(tracing.AttrBackend.String(backend), tracing.AttrPoolHit.Bool(hit))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return conn, err
+}
+
+// get tries backends in balancer order, skipping ejected ones, and
+// fails over to the next backend when a dial fails
+func (p *TCPPool) get(logical, key string) (conn net.Conn, backend string, hit bool, err error) {
+	candidates, tracked, err := p.candidates(logical, key)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	for _, backend = range candidates {
+		// check pool for existing connection
+		if idle, ok := p.takeIdle(backend); ok {
+			return p.handOut(backend, idle, true), backend, true, nil
+		}
+
+		// create new connection
+		if err = p.reserve(backend); err != nil {
+			continue
+		}
+		var c net.Conn
+		c, err = net.DialTimeout("tcp", backend, 5*time.Second)
+		if err == nil {
+			if tracked {
+				p.markSuccess(backend)
+			}
+			return p.handOut(backend, idleConn{Conn: c, created: time.Now()}, false), backend, false, nil
+		}
+		p.unreserve(backend)
+		if tracked {
+			p.markFailure(backend, err)
+		}
+	}
+	if len(candidates) > 1 {
+		err = fmt.Errorf("all %d backends for %s failed, last: %w", len(candidates), logical, err)
+	}
+	return nil, backend, false, err
+}
+
+// candidates orders the backends for logical: the balancer's pick among
+// healthy ones first, the other healthy ones next, then ejected ones as a
+// last resort. Health is only tracked for registered backends.
+func (p *TCPPool) candidates(logical, key string) (list []string, tracked bool, err error) {
 	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, false, ErrPoolClosed
+	}
+
 	backends, ok := p.backends[logical]
-	if !ok || len(backends) == 0 {
-		p.mu.Unlock()
-		return nil, fmt.Errorf("no backends for target %s", logical)
+	if !ok {
+		// unregistered targets are plain host:port addresses
+		return []string{logical}, false, nil
+	}
+	if len(backends) == 0 {
+		return nil, false, fmt.Errorf("no backends for target %s", logical)
 	}
 
-	// pick backend using round-robin
-	idx := atomic.AddUint32(p.counters[logical], 1)
-	backend := backends[int(idx)%len(backends)]
+	now := time.Now()
+	eligible := make([]int, 0, len(backends))
+	active := make([]int64, len(backends))
+	var ejected []string
+	for i, b := range backends {
+		active[i] = p.counters(b).active
+		if p.ejected(b, now) {
+			ejected = append(ejected, b)
+		} else {
+			eligible = append(eligible, i)
+		}
+	}
+	list = make([]string, 0, len(backends))
+	if len(eligible) > 0 {
+		first := p.lbs[logical].Pick(PickRequest{Eligible: eligible, Active: active, Key: key})
+		list = append(list, backends[first])
+		// remaining healthy backends in order after the pick
+		for i := 1; i < len(backends); i++ {
+			j := (first + i) % len(backends)
+			if !p.ejected(backends[j], now) {
+				list = append(list, backends[j])
+			}
+		}
+	}
+	return append(list, ejected...), true, nil
+}
 
-	// check pool for existing connection
-	conns := p.conns[backend]
-	if len(conns) > 0 {
-		conn := conns[len(conns)-1]
+// takeIdle pops the most recently returned conn for backend, closing
+// expired and dead ones on the way
+func (p *TCPPool) takeIdle(backend string) (idleConn, bool) {
+	for {
+		p.mu.Lock()
+		conns := p.conns[backend]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return idleConn{}, false
+		}
+		ic := conns[len(conns)-1]
 		p.conns[backend] = conns[:len(conns)-1]
+		expired := p.expired(ic, time.Now())
 		p.mu.Unlock()
-		return conn, nil
+		p.wakeRefill()
+
+		if !expired {
+			if c, ok := connAlive(ic); ok {
+				ic.Conn = c
+				return ic, true
+			}
+		}
+		p.evict(backend, ic)
+	}
+}
+
+// Put returns a connection obtained from Get to the pool. Only call it at a
+// protocol boundary: the next Get may hand the conn to another client. In
+// pre-warm mode returned conns are always closed.
+func (p *TCPPool) Put(target string, conn net.Conn) {
+	pc, ok := conn.(*pooledConn)
+	if !ok {
+		conn.Close()
+		return
+	}
+	if !pc.release() {
+		return // already closed or returned
+	}
+
+	p.mu.Lock()
+	if p.closed || p.opts.Prewarm > 0 || len(p.conns[pc.backend]) >= p.maxSize {
+		p.counters(pc.backend).open--
+		p.mu.Unlock()
+		pc.Conn.Close()
+		return
 	}
+	p.conns[pc.backend] = append(p.conns[pc.backend], idleConn{Conn: pc.Conn, created: pc.created, since: time.Now(), reused: true})
 	p.mu.Unlock()
+}
 
-	// create new connection
-	return net.DialTimeout("tcp", backend, 5*time.Second)
+// pooledConn is a handed-out backend connection, counted as active until
+// it is closed or returned with Put
+type pooledConn struct {
+	net.Conn
+	pool    *TCPPool
+	backend string
+	created time.Time
+	done    bool // p.mu held
 }
 
-// Put returns a TCP connection to the pool
-func (p *TCPPool) Put(target string, conn net.Conn) {
+// handOut wraps ic as an active conn for backend
+func (p *TCPPool) handOut(backend string, ic idleConn, hit bool) net.Conn {
+	pc := &pooledConn{Conn: ic.Conn, pool: p, backend: backend, created: ic.created}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if len(p.conns[target]) >= p.maxSize {
-		conn.Close()
-		return
+	c := p.counters(backend)
+	c.active++
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
 	}
-	p.conns[target] = append(p.conns[target], conn)
-}
\ No newline at end of file
+	p.inUse[pc] = struct{}{}
+	return pc
+}
+
+// release stops counting c as active; false if it was already released
+func (c *pooledConn) release() bool {
+	p := c.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c.done {
+		return false
+	}
+	c.done = true
+	p.counters(c.backend).active--
+	delete(p.inUse, c)
+	return true
+}
+
+func (c *pooledConn) Close() error {
+	if !c.release() {
+		return nil // already closed, or returned with Put and owned by the pool
+	}
+	c.pool.mu.Lock()
+	c.pool.counters(c.backend).open--
+	c.pool.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the backend connection
+func (c *pooledConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// Close stops background work and closes every pooled and handed-out conn
+func (p *TCPPool) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	p.mu.Lock()
+	p.closed = true
+	var toClose []net.Conn
+	for backend, conns := range p.conns {
+		for _, ic := range conns {
+			toClose = append(toClose, ic.Conn)
+		}
+		p.counters(backend).open -= len(conns)
+		delete(p.conns, backend)
+	}
+	for pc := range p.inUse {
+		toClose = append(toClose, pc)
+	}
+	p.mu.Unlock()
+
+	for _, c := range toClose {
+		c.Close()
+	}
+	if len(toClose) > 0 {
+		p.log.Debug("closed %d backend connections", len(toClose))
+	}
+}
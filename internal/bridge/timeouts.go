@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/gorilla/websocket"
+)
+
+// activity records when data last moved through a tunnel, in either
+// direction
+type activity struct {
+	last atomic.Int64 // unix nanoseconds
+}
+
+func (a *activity) touch() { a.last.Store(time.Now().UnixNano()) }
+
+func (a *activity) idleFor() time.Duration {
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+// watch calls end with the reason a tunnel must stop: the server drains,
+// nothing moved for cfg.IdleTimeout, or cfg.MaxDuration passed. It returns
+// once ended is closed.
+func watch(cfg *Config, act *activity, ended <-chan struct{}, end func(errcode.Code)) {
+	if cfg == nil || (cfg.Drain == nil && cfg.IdleTimeout <= 0 && cfg.MaxDuration <= 0) {
+		return
+	}
+	act.touch()
+	go func() {
+		var idle, expire <-chan time.Time
+		var idleTimer *time.Timer
+		if cfg.IdleTimeout > 0 {
+			idleTimer = time.NewTimer(cfg.IdleTimeout)
+			defer idleTimer.Stop()
+			idle = idleTimer.C
+		}
+		if cfg.MaxDuration > 0 {
+			t := time.NewTimer(cfg.MaxDuration)
+			defer t.Stop()
+			expire = t.C
+		}
+		for {
+			select {
+			case <-ended:
+				return
+			case <-cfg.Drain:
+				end(errcode.ServerDraining)
+				return
+			case <-expire:
+				end(errcode.SessionExpired)
+				return
+			case <-idle:
+				left := cfg.IdleTimeout - act.idleFor()
+				if left <= 0 {
+					end(errcode.IdleTimeout)
+					return
+				}
+				idleTimer.Reset(left)
+			}
+		}
+	}()
+}
+
+// keepAlive pings ws every interval until ended is closed. The read
+// deadline allows two missed pongs; extend, called after every message
+// read, and each pong push it back. A zero interval sets no deadline.
+func keepAlive(ws *websocket.Conn, interval time.Duration, ended <-chan struct{}) (extend func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	extend = func() { _ = ws.SetReadDeadline(time.Now().Add(2 * interval)) }
+	extend()
+	ws.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ended:
+				return
+			case <-t.C:
+				if ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)) != nil {
+					return
+				}
+			}
+		}
+	}()
+	return extend
+}
@@ -0,0 +1,133 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/gorilla/websocket"
+)
+
+// watchReason runs watch until it ends the tunnel and returns the reason,
+// or fails after wait
+func watchReason(t *testing.T, cfg *Config, act *activity, wait time.Duration) errcode.Code {
+	t.Helper()
+	ended := make(chan struct{})
+	defer close(ended)
+	reason := make(chan errcode.Code, 1)
+	watch(cfg, act, ended, func(c errcode.Code) { reason <- c })
+	select {
+	case c := <-reason:
+		return c
+	case <-time.After(wait):
+		t.Fatal("tunnel not ended")
+		return 0
+	}
+}
+
+func TestWatchIdle(t *testing.T) {
+	var act activity
+	stop := make(chan struct{})
+	defer close(stop)
+	// traffic for three idle periods holds the timeout off
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				act.touch()
+			}
+		}
+	}()
+	start := time.Now()
+	ended := make(chan struct{})
+	reason := make(chan errcode.Code, 1)
+	watch(&Config{IdleTimeout: 50 * time.Millisecond}, &act, ended, func(c errcode.Code) { reason <- c })
+	select {
+	case c := <-reason:
+		t.Fatalf("busy tunnel ended with %q after %v", c, time.Since(start))
+	case <-time.After(150 * time.Millisecond):
+	}
+	stop <- struct{}{}
+	if c := <-reason; c != errcode.IdleTimeout {
+		t.Fatalf("idle tunnel ended with %q", c)
+	}
+	close(ended)
+}
+
+func TestWatchMaxDuration(t *testing.T) {
+	var act activity
+	cfg := &Config{IdleTimeout: time.Minute, MaxDuration: 30 * time.Millisecond}
+	if c := watchReason(t, cfg, &act, time.Second); c != errcode.SessionExpired {
+		t.Fatalf("ended with %q", c)
+	}
+}
+
+func TestWatchDrain(t *testing.T) {
+	var act activity
+	drain := make(chan struct{})
+	close(drain)
+	if c := watchReason(t, &Config{Drain: drain}, &act, time.Second); c != errcode.ServerDraining {
+		t.Fatalf("ended with %q", c)
+	}
+}
+
+func TestWatchEnded(t *testing.T) {
+	var act activity
+	ended := make(chan struct{})
+	called := make(chan struct{}, 1)
+	watch(&Config{IdleTimeout: 20 * time.Millisecond}, &act, ended, func(errcode.Code) { called <- struct{}{} })
+	close(ended)
+	select {
+	case <-called:
+		t.Fatal("end called after the tunnel ended")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestKeepAlive pings a client that answers and drops one that does not:
+// gorilla only sends pongs while the application reads
+func TestKeepAlive(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	for _, tc := range []struct {
+		name    string
+		reading bool
+	}{{"pongs", true}, {"silent", false}} {
+		t.Run(tc.name, func(t *testing.T) {
+			readErr := make(chan error, 1)
+			client := wsPair(t, func(ws *websocket.Conn) {
+				ended := make(chan struct{})
+				defer close(ended)
+				keepAlive(ws, interval, ended)
+				_ = ws.SetReadDeadline(time.Now().Add(2 * interval)) // as extend does
+				done := time.After(10 * interval)
+				go func() {
+					_, _, err := ws.ReadMessage()
+					readErr <- err
+				}()
+				<-done
+				ws.Close()
+			})
+			pings := make(chan struct{}, 100)
+			client.SetPingHandler(func(data string) error {
+				pings <- struct{}{}
+				return client.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+			})
+			if tc.reading {
+				go client.ReadMessage()
+			}
+			err := <-readErr
+			if tc.reading {
+				if err != nil && clientReason(err) == errcode.IdleTimeout {
+					t.Fatalf("answering client timed out: %v", err)
+				}
+				if len(pings) == 0 {
+					t.Fatal("no pings")
+				}
+			} else if clientReason(err) != errcode.IdleTimeout {
+				t.Fatalf("silent client: %v, want a read timeout", err)
+			}
+		})
+	}
+}
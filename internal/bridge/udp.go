@@ -0,0 +1,333 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/logger"
+	"github.com/gorilla/websocket"
+)
+
+// UDPPrefix marks a UDP target: "udp/host:port"
+const UDPPrefix = "udp/"
+
+// maxDatagram is the largest UDP payload relayed
+const maxDatagram = 64 * 1024
+
+// ErrUDPTableClosed is returned by Open after Close
+var ErrUDPTableClosed = errors.New("udp table closed")
+
+// UDPTable tracks the server's UDP associations and closes idle ones
+type UDPTable struct {
+	mu     sync.Mutex
+	flows  map[*UDPAssoc]struct{}
+	idle   time.Duration
+	closed bool
+	stop   chan struct{}
+	log    *logger.Logger
+}
+
+// UDPFlow describes one association for the admin API
+type UDPFlow struct {
+	Client        string        `json:"client"`
+	Target        string        `json:"target"`
+	BytesSent     int64         `json:"bytes_sent"`     // to the client
+	BytesReceived int64         `json:"bytes_received"` // from the client
+	Idle          time.Duration `json:"idle_ns"`
+}
+
+// NewUDPTable starts a table whose associations close after idle without
+// traffic in either direction (default 60s)
+func NewUDPTable(idle time.Duration) *UDPTable {
+	if idle <= 0 {
+		idle = 60 * time.Second
+	}
+	t := &UDPTable{
+		flows: make(map[*UDPAssoc]struct{}),
+		idle:  idle,
+		stop:  make(chan struct{}),
+		log:   logger.New("udp"),
+	}
+	go t.sweepLoop()
+	return t
+}
+
+// Open creates an association to the first address in addrs. UDP has no
+// handshake, so later addresses are only tried if the socket cannot be
+// created.
+func (t *UDPTable) Open(client string, addrs []string) (*UDPAssoc, error) {
+	var conn net.Conn
+	var err error
+	for _, addr := range addrs {
+		if conn, err = net.Dial("udp", addr); err == nil {
+			break
+		}
+	}
+	if conn == nil {
+		return nil, err
+	}
+	a := &UDPAssoc{conn: conn, client: client, table: t}
+	a.touch()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		conn.Close()
+		return nil, ErrUDPTableClosed
+	}
+	t.flows[a] = struct{}{}
+	t.log.Debug("UDP flow %s -> %s opened", client, conn.RemoteAddr())
+	return a, nil
+}
+
+// Flows returns a snapshot of the open associations
+func (t *UDPTable) Flows() []UDPFlow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]UDPFlow, 0, len(t.flows))
+	for a := range t.flows {
+		out = append(out, UDPFlow{
+			Client:        a.client,
+			Target:        a.conn.RemoteAddr().String(),
+			BytesSent:     a.BytesSent.Load(),
+			BytesReceived: a.BytesReceived.Load(),
+			Idle:          a.idleFor(),
+		})
+	}
+	return out
+}
+
+// Close closes every association and stops the sweeper
+func (t *UDPTable) Close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	close(t.stop)
+	flows := t.flows
+	t.flows = make(map[*UDPAssoc]struct{})
+	t.mu.Unlock()
+	for a := range flows {
+		a.conn.Close()
+	}
+}
+
+func (t *UDPTable) sweepLoop() {
+	ticker := time.NewTicker(max(t.idle/2, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+		var idle []*UDPAssoc
+		t.mu.Lock()
+		for a := range t.flows {
+			if a.idleFor() > t.idle {
+				idle = append(idle, a)
+			}
+		}
+		t.mu.Unlock()
+		for _, a := range idle {
+			t.log.Debug("UDP flow %s -> %s idle, closing", a.client, a.conn.RemoteAddr())
+			a.Close()
+		}
+	}
+}
+
+// UDPAssoc is one client's UDP association with a backend
+type UDPAssoc struct {
+	conn   net.Conn
+	client string
+	table  *UDPTable
+	last   atomic.Int64 // unix nanos of the last datagram either way
+
+	BytesSent     atomic.Int64 // backend -> client
+	BytesReceived atomic.Int64 // client -> backend
+}
+
+// Send relays one datagram from the client to the backend
+func (a *UDPAssoc) Send(p []byte) error {
+	a.touch()
+	n, err := a.conn.Write(p)
+	a.BytesReceived.Add(int64(n))
+	return err
+}
+
+// Recv reads one datagram from the backend into p
+func (a *UDPAssoc) Recv(p []byte) (int, error) {
+	n, err := a.conn.Read(p)
+	if n > 0 {
+		a.touch()
+		a.BytesSent.Add(int64(n))
+	}
+	return n, err
+}
+
+// Close removes the association and closes its socket
+func (a *UDPAssoc) Close() error {
+	a.table.mu.Lock()
+	delete(a.table.flows, a)
+	a.table.mu.Unlock()
+	return a.conn.Close()
+}
+
+func (a *UDPAssoc) touch() { a.last.Store(time.Now().UnixNano()) }
+
+func (a *UDPAssoc) idleFor() time.Duration {
+	return time.Duration(time.Now().UnixNano() - a.last.Load())
+}
+
+// Read, Write and the rest of net.Conn let a UDPAssoc stand in as the
+// backend side of a Bridge; Read and Write move whole datagrams
+func (a *UDPAssoc) Read(p []byte) (int, error) { return a.Recv(p) }
+
+func (a *UDPAssoc) Write(p []byte) (int, error) {
+	if err := a.Send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (a *UDPAssoc) LocalAddr() net.Addr                { return a.conn.LocalAddr() }
+func (a *UDPAssoc) RemoteAddr() net.Addr               { return a.conn.RemoteAddr() }
+func (a *UDPAssoc) SetDeadline(t time.Time) error      { return a.conn.SetDeadline(t) }
+func (a *UDPAssoc) SetReadDeadline(t time.Time) error  { return a.conn.SetReadDeadline(t) }
+func (a *UDPAssoc) SetWriteDeadline(t time.Time) error { return a.conn.SetWriteDeadline(t) }
+
+// NewWSUDPBridge relays a UDP association over WS: each binary message is
+// one datagram in a streamID-1 frame, in both directions
+func NewWSUDPBridge(ws *websocket.Conn, a *UDPAssoc) *Bridge {
+	b := &Bridge{
+		bridgeType: WSBridge,
+		ws:         ws,
+		tcpConn:    a,
+		cfg:        &Config{},
+		log:        logger.New("bridge"),
+		dialed:     make(chan struct{}),
+		ended:      make(chan struct{}),
+	}
+	b.markDialed()
+	ws.SetReadLimit(8 + maxDatagram)
+	b.wg.Add(2)
+
+	// backend -> WS
+	go func() {
+		defer b.wg.Done()
+		defer b.shutdown(errcode.Normal)
+		buf := make([]byte, maxDatagram)
+		for {
+			n, err := a.Recv(buf)
+			if err != nil {
+				return
+			}
+			if WriteWSFrame(ws, 1, buf[:n]) != nil {
+				return
+			}
+		}
+	}()
+
+	// WS -> backend
+	go func() {
+		defer b.wg.Done()
+		defer b.shutdown(errcode.Normal)
+		for {
+			mt, rdr, err := ws.NextReader()
+			if err != nil {
+				return
+			}
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			streamID, payload, err := ReadWSFrame(rdr)
+			if err != nil {
+				return
+			}
+			if streamID != 1 {
+				continue
+			}
+			if a.Send(payload) != nil {
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// UDPStreamConn carries datagrams over a byte stream as [len(2B)][payload]
+// records, so a QUIC stream bridge can drive a UDP association. When reply
+// is set, backend datagrams go through it instead (e.g. as QUIC
+// datagrams); reply returns false to fall back to the stream.
+type UDPStreamConn struct {
+	*UDPAssoc
+	reply func([]byte) bool
+
+	in      []byte // partial records written by the client
+	pending []byte // framed backend datagrams not yet read
+	buf     []byte
+}
+
+// NewUDPStreamConn wraps a for stream framing
+func NewUDPStreamConn(a *UDPAssoc, reply func([]byte) bool) *UDPStreamConn {
+	return &UDPStreamConn{UDPAssoc: a, reply: reply, buf: make([]byte, maxDatagram)}
+}
+
+// Write parses records from the client and sends each payload
+func (c *UDPStreamConn) Write(p []byte) (int, error) {
+	c.in = append(c.in, p...)
+	for len(c.in) >= 2 {
+		size := int(binary.BigEndian.Uint16(c.in))
+		if len(c.in) < 2+size {
+			break
+		}
+		if err := c.Send(c.in[2 : 2+size]); err != nil {
+			return 0, err
+		}
+		c.in = c.in[2+size:]
+	}
+	return len(p), nil
+}
+
+// Read returns framed backend datagrams not delivered through reply
+func (c *UDPStreamConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		n, err := c.Recv(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		if c.reply != nil && c.reply(c.buf[:n]) {
+			continue
+		}
+		c.pending = binary.BigEndian.AppendUint16(c.pending, uint16(n))
+		c.pending = append(c.pending, c.buf[:n]...)
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// ReadUDPRecord reads one [len(2B)][payload] record, for clients of
+// UDPStreamConn
+func ReadUDPRecord(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	p := make([]byte, binary.BigEndian.Uint16(size[:]))
+	_, err := io.ReadFull(r, p)
+	return p, err
+}
+
+// AppendUDPRecord frames p as a UDPStreamConn record
+func AppendUDPRecord(b, p []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(p))), p...)
+}
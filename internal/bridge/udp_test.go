@@ -0,0 +1,109 @@
+package bridge
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// udpEchoAddr starts a UDP server that echoes every datagram to its sender
+func udpEchoAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestUDPIdleTimeout(t *testing.T) {
+	table := NewUDPTable(50 * time.Millisecond)
+	defer table.Close()
+	a, err := table.Open("client", []string{udpEchoAddr(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(table.Flows()); n != 1 {
+		t.Fatalf("%d flows after open, want 1", n)
+	}
+	// Recv fails once the sweeper closes the idle association
+	_ = a.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := a.Recv(make([]byte, 16)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("idle flow: Recv returned %v, want closed", err)
+	}
+	if n := len(table.Flows()); n != 0 {
+		t.Fatalf("%d flows after idle timeout, want 0", n)
+	}
+}
+
+func TestUDPTableClose(t *testing.T) {
+	table := NewUDPTable(time.Minute)
+	addr := udpEchoAddr(t)
+	a, err := table.Open("client", []string{addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Close()
+	if _, err := a.Recv(make([]byte, 16)); err == nil {
+		t.Error("flow still readable after Close")
+	}
+	if _, err := table.Open("client", []string{addr}); !errors.Is(err, ErrUDPTableClosed) {
+		t.Errorf("Open after Close: %v", err)
+	}
+}
+
+func TestUDPStreamConn(t *testing.T) {
+	table := NewUDPTable(time.Minute)
+	defer table.Close()
+	a, err := table.Open("client", []string{udpEchoAddr(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = a.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// datagrams up to 8 bytes go through reply, larger ones on the stream
+	var replied [][]byte
+	c := NewUDPStreamConn(a, func(p []byte) bool {
+		if len(p) > 8 {
+			return false
+		}
+		replied = append(replied, bytes.Clone(p))
+		return true
+	})
+	small, large := []byte("small"), bytes.Repeat([]byte("L"), 1000)
+
+	// records may arrive split at any byte
+	stream := AppendUDPRecord(AppendUDPRecord(nil, small), large)
+	for _, part := range [][]byte{stream[:1], stream[1:9], stream[9:]} {
+		if n, err := c.Write(part); err != nil || n != len(part) {
+			t.Fatalf("Write: %d, %v", n, err)
+		}
+	}
+	got, err := ReadUDPRecord(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, large) {
+		t.Fatalf("stream record of %d bytes, want the large datagram", len(got))
+	}
+	if len(replied) != 1 || !bytes.Equal(replied[0], small) {
+		t.Fatalf("replied %q, want the small datagram", replied)
+	}
+	if a.BytesReceived.Load() != int64(len(small)+len(large)) {
+		t.Errorf("BytesReceived %d", a.BytesReceived.Load())
+	}
+}
@@ -0,0 +1,514 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/logger"
+	"github.com/gorilla/websocket"
+)
+
+// Control frames of a multiplexed WebSocket travel on streamID 0 as
+// [type(1B)][streamID(4B)][body]. Single-stream WebSockets use MuxEOF for
+// stream 1 as well.
+const (
+	MuxOpen     byte = 1 // client → server, body: target
+	MuxWindow   byte = 2 // either way, body: credit increment (4B)
+	MuxClose    byte = 3 // either way: the sender is done with the stream, body: errcode (2B, optional)
+	MuxSettings byte = 4 // server → client on stream 0, body: window(4B) max streams(4B)
+	MuxEOF      byte = 5 // either way: no more data from the sender (half-close)
+)
+
+const (
+	muxMaxFrame          = 16 * 1024 // largest data frame the server sends
+	muxDefaultWindow     = 256 * 1024
+	muxDefaultMaxStreams = 128
+)
+
+var errMuxControl = errors.New("short mux control frame")
+
+// MuxControl encodes a control frame payload for streamID 0
+func MuxControl(typ byte, streamID uint32, body []byte) []byte {
+	p := make([]byte, 5+len(body))
+	p[0] = typ
+	binary.BigEndian.PutUint32(p[1:5], streamID)
+	copy(p[5:], body)
+	return p
+}
+
+// ParseMuxControl decodes a streamID-0 payload written by MuxControl
+func ParseMuxControl(p []byte) (typ byte, streamID uint32, body []byte, err error) {
+	if len(p) < 5 {
+		return 0, 0, nil, errMuxControl
+	}
+	return p[0], binary.BigEndian.Uint32(p[1:5]), p[5:], nil
+}
+
+func uint32Body(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint32(b[4*i:], x)
+	}
+	return b
+}
+
+// outFrame is one WS message for the writer; written receives the result
+type outFrame struct {
+	msg     []byte
+	written chan error
+}
+
+// WSMux carries many TCP streams over one WebSocket. Every stream has a
+// credit window in each direction, so a stream whose client or backend is
+// slow stops only itself, and buffers at most one window. A single writer
+// goroutine owns the WebSocket: control frames go first, then data frames
+// from each ready stream in turn.
+type WSMux struct {
+	ws         *websocket.Conn
+	cfg        *Config
+	window     int
+	maxStreams int
+	log        *logger.Logger
+	wg         sync.WaitGroup
+
+	ctrl chan []byte   // control frames, written before data
+	data chan outFrame // at most one frame per stream, FIFO
+	done chan struct{}
+	once sync.Once
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+
+	act    activity
+	extend func() // pushes back the read deadline, see keepAlive
+
+	// Metrics
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+}
+
+// NewWSMux starts serving multiplexed streams on ws; cfg.Dial opens the
+// backend named by each MuxOpen
+func NewWSMux(ws *websocket.Conn, cfg *Config) *WSMux {
+	m := &WSMux{
+		ws:         ws,
+		cfg:        cfg,
+		window:     cfg.MuxWindow,
+		maxStreams: cfg.MuxMaxStreams,
+		log:        logger.New("mux"),
+		ctrl:       make(chan []byte, 64),
+		data:       make(chan outFrame),
+		done:       make(chan struct{}),
+		streams:    make(map[uint32]*muxStream),
+	}
+	if m.window <= 0 {
+		m.window = muxDefaultWindow
+	}
+	if m.maxStreams <= 0 {
+		m.maxStreams = muxDefaultMaxStreams
+	}
+	m.control(MuxSettings, 0, uint32Body(uint32(m.window), uint32(m.maxStreams)))
+
+	// frames over the window end only their stream, not the WebSocket
+	ws.SetReadLimit(int64(max(m.window, 1<<20)) + 8)
+	m.extend = keepAlive(ws, cfg.PingInterval, m.done)
+
+	m.wg.Add(2)
+	go m.writeLoop()
+	go m.readLoop()
+	// idle means no data on any stream
+	watch(cfg, &m.act, m.done, m.shutdown)
+	return m
+}
+
+// Bytes returns the data bytes sent to and received from the client
+func (m *WSMux) Bytes() (sent, received int64) {
+	return m.bytesSent.Load(), m.bytesReceived.Load()
+}
+
+// control queues a control frame ahead of data
+func (m *WSMux) control(typ byte, streamID uint32, body []byte) {
+	select {
+	case m.ctrl <- MuxControl(typ, streamID, body):
+	case <-m.done:
+	}
+}
+
+func (m *WSMux) writeLoop() {
+	defer m.wg.Done()
+	defer m.shutdown(errcode.Normal)
+	for {
+		var f outFrame
+		select {
+		case msg := <-m.ctrl:
+			f.msg = append(make([]byte, 8, 8+len(msg)), msg...)
+			binary.BigEndian.PutUint32(f.msg[4:8], uint32(len(msg)))
+		default:
+			select {
+			case msg := <-m.ctrl:
+				f.msg = append(make([]byte, 8, 8+len(msg)), msg...)
+				binary.BigEndian.PutUint32(f.msg[4:8], uint32(len(msg)))
+			case f = <-m.data:
+			case <-m.done:
+				return
+			}
+		}
+		err := m.ws.WriteMessage(websocket.BinaryMessage, f.msg)
+		if f.written != nil {
+			f.written <- err
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (m *WSMux) readLoop() {
+	defer m.wg.Done()
+	reason := errcode.Normal
+	defer func() { m.shutdown(reason) }()
+	for {
+		mt, rdr, err := m.ws.NextReader()
+		if err != nil {
+			reason = clientReason(err)
+			return
+		}
+		m.extend()
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+		streamID, payload, err := ReadWSFrame(rdr)
+		if err != nil {
+			return
+		}
+		if streamID != 0 {
+			m.act.touch()
+			if s := m.stream(streamID); s != nil {
+				s.receive(payload)
+			}
+			continue
+		}
+		typ, id, body, err := ParseMuxControl(payload)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case MuxOpen:
+			m.open(id, string(body))
+		case MuxWindow:
+			if s := m.stream(id); s != nil && len(body) >= 4 {
+				s.grant(int(binary.BigEndian.Uint32(body)))
+			}
+		case MuxEOF:
+			if s := m.stream(id); s != nil {
+				s.peerHalfClosed()
+			}
+		case MuxClose:
+			if s := m.stream(id); s != nil {
+				s.peerClosed()
+			}
+		}
+	}
+}
+
+func (m *WSMux) stream(id uint32) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+// open registers a stream and dials its backend in the background, so
+// the client may send its first window while the dial is in progress
+func (m *WSMux) open(id uint32, target string) {
+	m.mu.Lock()
+	select {
+	case <-m.done:
+		m.mu.Unlock()
+		return
+	default:
+	}
+	if id == 0 || m.streams[id] != nil {
+		m.mu.Unlock()
+		m.log.Debug("ignoring open of stream %d", id)
+		return
+	}
+	if len(m.streams) >= m.maxStreams {
+		m.mu.Unlock()
+		m.log.Debug("stream limit reached, refusing %s", target)
+		m.control(MuxClose, id, errcode.MuxBody(errcode.RateLimited))
+		return
+	}
+	s := &muxStream{
+		m:          m,
+		id:         id,
+		target:     target,
+		sendWindow: m.window,
+		recvWindow: m.window,
+		changed:    make(chan struct{}),
+	}
+	m.streams[id] = s
+	m.wg.Add(1)
+	m.mu.Unlock()
+	go s.run()
+}
+
+// shutdown closes the WebSocket with reason and ends every stream
+func (m *WSMux) shutdown(reason errcode.Code) {
+	m.once.Do(func() {
+		close(m.done)
+		closeWS(m.ws, reason)
+		m.mu.Lock()
+		streams := m.streams
+		m.streams = make(map[uint32]*muxStream)
+		m.mu.Unlock()
+		for _, s := range streams {
+			s.finish(reason)
+		}
+	})
+}
+
+// Close shuts down the WebSocket and all streams and waits for them
+func (m *WSMux) Close() {
+	m.shutdown(errcode.Normal)
+	m.wg.Wait()
+}
+
+func (m *WSMux) Wg() *sync.WaitGroup {
+	return &m.wg
+}
+
+// muxStream is one tunnel of a WSMux
+type muxStream struct {
+	m      *WSMux
+	id     uint32
+	target string
+
+	mu         sync.Mutex
+	changed    chan struct{} // closed and replaced on every state change
+	conn       net.Conn      // nil until dialed
+	inbound    []byte        // client bytes not yet written to the backend
+	sendWindow int           // bytes the client will still accept
+	recvWindow int           // bytes the client may still send
+	peerDone   bool          // client sent MuxClose
+	peerEOF    bool          // client sent MuxEOF
+	upDone     bool          // client EOF passed to the backend
+	downDone   bool          // backend EOF passed to the client
+	done       bool
+}
+
+// broadcast wakes every waiter; callers hold mu
+func (s *muxStream) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// wait blocks until cond holds or the stream ends; callers hold mu
+func (s *muxStream) wait(cond func() bool) bool {
+	for !cond() && !s.done {
+		ch := s.changed
+		s.mu.Unlock()
+		<-ch
+		s.mu.Lock()
+	}
+	return !s.done
+}
+
+func (s *muxStream) run() {
+	defer s.m.wg.Done()
+	conn, err := s.m.cfg.Dial(s.target)
+	if err != nil {
+		s.m.log.Error("mux dial %s: %v", s.target, err)
+		s.finish(errcode.Of(err, errcode.BackendRefused))
+		return
+	}
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	s.m.wg.Add(1)
+	go s.downstream(conn)
+	s.upstream(conn)
+}
+
+// upstream writes client bytes to the backend and returns the credit
+func (s *muxStream) upstream(conn net.Conn) {
+	for {
+		s.mu.Lock()
+		ok := s.wait(func() bool { return len(s.inbound) > 0 || s.peerDone || s.peerEOF })
+		data, eof := s.inbound, s.peerEOF && !s.peerDone
+		s.inbound = nil
+		s.mu.Unlock()
+		if ok && len(data) == 0 && eof && closeWrite(conn) {
+			s.halfClosed(&s.upDone)
+			return
+		}
+		if !ok || len(data) == 0 {
+			s.finish(errcode.Normal) // ended, or the client closed and everything is written
+			return
+		}
+		n, err := conn.Write(data)
+		s.m.bytesReceived.Add(int64(n))
+		if err != nil {
+			s.finish(errcode.Of(err, errcode.BackendReset))
+			return
+		}
+		s.mu.Lock()
+		s.recvWindow += n
+		s.mu.Unlock()
+		s.m.control(MuxWindow, s.id, uint32Body(uint32(n)))
+	}
+}
+
+// downstream reads the backend no faster than the client grants credit
+func (s *muxStream) downstream(conn net.Conn) {
+	defer s.m.wg.Done()
+	halfClosed, reason := false, errcode.Normal
+	defer func() {
+		if !halfClosed {
+			s.finish(reason)
+		}
+	}()
+	buf := make([]byte, 8+muxMaxFrame)
+	binary.BigEndian.PutUint32(buf[0:4], s.id)
+	written := make(chan error, 1)
+	for {
+		s.mu.Lock()
+		ok := s.wait(func() bool { return s.sendWindow > 0 })
+		n := min(s.sendWindow, muxMaxFrame)
+		s.mu.Unlock()
+		if !ok {
+			return
+		}
+		k, err := conn.Read(buf[8 : 8+n])
+		if k > 0 {
+			s.m.act.touch()
+			s.mu.Lock()
+			s.sendWindow -= k
+			s.mu.Unlock()
+			binary.BigEndian.PutUint32(buf[4:8], uint32(k))
+			select {
+			case s.m.data <- outFrame{msg: buf[:8+k], written: written}:
+			case <-s.m.done:
+				return
+			}
+			select {
+			case ew := <-written:
+				if ew != nil {
+					return
+				}
+			case <-s.m.done:
+				return
+			}
+			s.m.bytesSent.Add(int64(k))
+		}
+		if err == io.EOF {
+			// after the data frames above: each waited for the writer
+			s.m.control(MuxEOF, s.id, nil)
+			halfClosed = true
+			s.halfClosed(&s.downDone)
+		}
+		if err != nil {
+			reason = backendReason(err)
+			return
+		}
+	}
+}
+
+// receive buffers client data; more than the granted window is a
+// protocol error that ends the stream
+func (s *muxStream) receive(p []byte) {
+	s.mu.Lock()
+	if s.done || s.peerDone || s.peerEOF {
+		s.mu.Unlock()
+		return
+	}
+	if len(p) > s.recvWindow {
+		s.mu.Unlock()
+		s.m.log.Error("stream %d overran its window", s.id)
+		s.finish(errcode.ProtocolError)
+		return
+	}
+	s.recvWindow -= len(p)
+	s.inbound = append(s.inbound, p...)
+	s.broadcast()
+	s.mu.Unlock()
+}
+
+// grant adds client credit for downstream data
+func (s *muxStream) grant(n int) {
+	s.mu.Lock()
+	s.sendWindow += n
+	s.broadcast()
+	s.mu.Unlock()
+}
+
+// peerHalfClosed half-closes the backend once buffered client data is written
+func (s *muxStream) peerHalfClosed() {
+	s.mu.Lock()
+	s.peerEOF = true
+	s.broadcast()
+	s.mu.Unlock()
+}
+
+// halfClosed records one direction's EOF; the stream ends with the second
+func (s *muxStream) halfClosed(eof *bool) {
+	s.mu.Lock()
+	*eof = true
+	// a MuxClose that raced the client's EOF ends the stream too
+	end := s.upDone && (s.downDone || s.peerDone)
+	s.mu.Unlock()
+	if end {
+		s.finish(errcode.Normal)
+	}
+}
+
+// peerClosed ends the stream once buffered client data is written, or at
+// once if upstream already ended with an EOF
+func (s *muxStream) peerClosed() {
+	s.mu.Lock()
+	s.peerDone = true
+	s.broadcast()
+	upDone := s.upDone
+	s.mu.Unlock()
+	if upDone {
+		s.finish(errcode.Normal)
+	}
+}
+
+// finish closes the backend, forgets the stream and tells the client why
+func (s *muxStream) finish(reason errcode.Code) {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.broadcast()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+
+	m := s.m
+	m.mu.Lock()
+	if m.streams[s.id] == s {
+		delete(m.streams, s.id)
+	}
+	m.mu.Unlock()
+	var body []byte
+	if reason != errcode.Normal {
+		body = errcode.MuxBody(reason)
+	}
+	m.control(MuxClose, s.id, body)
+}
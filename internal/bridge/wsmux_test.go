@@ -0,0 +1,261 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/gorilla/websocket"
+)
+
+// wsPair runs serve on the server side of a loopback WebSocket and returns
+// the client side
+func wsPair(t *testing.T, serve func(ws *websocket.Conn)) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serve(ws)
+	}))
+	t.Cleanup(srv.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return ws
+}
+
+// muxPair starts a WSMux with cfg and returns its client after checking
+// the settings frame
+func muxPair(t *testing.T, cfg *Config) *websocket.Conn {
+	t.Helper()
+	ws := wsPair(t, func(ws *websocket.Conn) {
+		m := NewWSMux(ws, cfg)
+		m.Wg().Wait()
+	})
+	typ, _, body := readMuxControl(t, ws)
+	if typ != MuxSettings || len(body) != 8 {
+		t.Fatalf("first frame: type %d, body %x", typ, body)
+	}
+	if window := binary.BigEndian.Uint32(body); cfg.MuxWindow > 0 && window != uint32(cfg.MuxWindow) {
+		t.Fatalf("settings window %d, want %d", window, cfg.MuxWindow)
+	}
+	return ws
+}
+
+func readMuxFrame(t *testing.T, ws *websocket.Conn) (uint32, []byte) {
+	t.Helper()
+	_, rdr, err := ws.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, payload, err := ReadWSFrame(rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, payload
+}
+
+// readMuxControl skips data and window frames up to the next other
+// control frame
+func readMuxControl(t *testing.T, ws *websocket.Conn) (typ byte, streamID uint32, body []byte) {
+	t.Helper()
+	for {
+		id, payload := readMuxFrame(t, ws)
+		if id != 0 {
+			continue
+		}
+		typ, streamID, body, err := ParseMuxControl(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != MuxWindow {
+			return typ, streamID, body
+		}
+	}
+}
+
+func muxSend(t *testing.T, ws *websocket.Conn, streamID uint32, p []byte) {
+	t.Helper()
+	if err := WriteWSFrame(ws, streamID, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func muxOpen(t *testing.T, ws *websocket.Conn, streamID uint32, target string) {
+	muxSend(t, ws, 0, MuxControl(MuxOpen, streamID, []byte(target)))
+}
+
+// expectMuxClose waits for the MuxClose of streamID and checks its reason
+func expectMuxClose(t *testing.T, ws *websocket.Conn, streamID uint32, want errcode.Code) {
+	t.Helper()
+	typ, id, body := readMuxControl(t, ws)
+	if typ != MuxClose || id != streamID {
+		t.Fatalf("control frame type %d for stream %d, want close of %d", typ, id, streamID)
+	}
+	if got := errcode.FromMux(body); got != want {
+		t.Fatalf("stream %d closed with %q, want %q", streamID, got, want)
+	}
+}
+
+func dialTCP(target string) (net.Conn, error) { return net.Dial("tcp", target) }
+
+func TestMuxControl(t *testing.T) {
+	typ, id, body, err := ParseMuxControl(MuxControl(MuxWindow, 7, []byte{1, 2}))
+	if err != nil || typ != MuxWindow || id != 7 || !bytes.Equal(body, []byte{1, 2}) {
+		t.Fatalf("round trip: %d %d %x %v", typ, id, body, err)
+	}
+	if _, _, _, err := ParseMuxControl([]byte{MuxOpen, 0, 0}); err == nil {
+		t.Fatal("short control frame accepted")
+	}
+}
+
+func TestWSMuxEcho(t *testing.T) {
+	ws := muxPair(t, &Config{Dial: dialTCP, MuxWindow: 64 * 1024})
+	muxOpen(t, ws, 1, echoAddr(t))
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 2048) // 32 KiB, within the window
+	muxSend(t, ws, 1, payload)
+
+	var got []byte
+	for len(got) < len(payload) {
+		id, p := readMuxFrame(t, ws)
+		if id != 1 {
+			continue
+		}
+		if len(p) > muxMaxFrame {
+			t.Fatalf("data frame of %d bytes", len(p))
+		}
+		got = append(got, p...)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("echo mismatch")
+	}
+
+	muxSend(t, ws, 0, MuxControl(MuxClose, 1, nil))
+	expectMuxClose(t, ws, 1, errcode.Normal)
+}
+
+// TestWSMuxWindow checks that a stream without credit gets one window and
+// no more, and that credit releases the rest
+func TestWSMuxWindow(t *testing.T) {
+	const window = 4096
+	flood := serveAddr(t, func(c net.Conn) { c.Write(make([]byte, 4*window)) })
+	ws := muxPair(t, &Config{Dial: dialTCP, MuxWindow: window})
+	muxOpen(t, ws, 1, flood)
+
+	data := make(chan int, 64)
+	go func() {
+		defer close(data)
+		for {
+			_, rdr, err := ws.NextReader()
+			if err != nil {
+				return
+			}
+			if id, p, err := ReadWSFrame(rdr); err == nil && id == 1 {
+				data <- len(p)
+			}
+		}
+	}()
+	received := func(want int) {
+		t.Helper()
+		n := 0
+		for n < want {
+			k, ok := <-data
+			if !ok {
+				t.Fatalf("WebSocket ended after %d bytes", n)
+			}
+			n += k
+		}
+		select {
+		case k := <-data:
+			t.Fatalf("%d bytes sent beyond the window", k)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	received(window)
+	muxSend(t, ws, 0, MuxControl(MuxWindow, 1, binary.BigEndian.AppendUint32(nil, window)))
+	received(window)
+}
+
+func TestWSMuxOverrun(t *testing.T) {
+	ws := muxPair(t, &Config{Dial: dialTCP, MuxWindow: 1024})
+	echo := echoAddr(t)
+	muxOpen(t, ws, 1, echo)
+	muxSend(t, ws, 1, make([]byte, 1025))
+	expectMuxClose(t, ws, 1, errcode.ProtocolError)
+
+	// only that stream ends
+	muxOpen(t, ws, 2, echo)
+	muxSend(t, ws, 2, []byte("ping"))
+	for {
+		if id, p := readMuxFrame(t, ws); id == 2 {
+			if string(p) != "ping" {
+				t.Fatalf("stream 2 echoed %q", p)
+			}
+			return
+		}
+	}
+}
+
+func TestWSMuxStreamLimit(t *testing.T) {
+	ws := muxPair(t, &Config{Dial: dialTCP, MuxMaxStreams: 1})
+	echo := echoAddr(t)
+	muxOpen(t, ws, 1, echo)
+	muxOpen(t, ws, 2, echo)
+	expectMuxClose(t, ws, 2, errcode.RateLimited)
+}
+
+func TestWSMuxDialError(t *testing.T) {
+	denied := errcode.Wrap(errcode.PolicyDenied, errors.New("denied"))
+	ws := muxPair(t, &Config{Dial: func(target string) (net.Conn, error) {
+		if target == "denied:1" {
+			return nil, denied
+		}
+		return nil, errors.New("connection refused")
+	}})
+	muxOpen(t, ws, 1, "denied:1")
+	expectMuxClose(t, ws, 1, errcode.PolicyDenied)
+	muxOpen(t, ws, 2, "dead:1")
+	expectMuxClose(t, ws, 2, errcode.BackendRefused)
+}
+
+// TestWSMuxHalfClose passes the client's EOF to the backend and the
+// backend's back as MuxEOF before the stream closes
+func TestWSMuxHalfClose(t *testing.T) {
+	ws := muxPair(t, &Config{Dial: dialTCP})
+	muxOpen(t, ws, 1, echoAddr(t))
+	muxSend(t, ws, 1, []byte("last words"))
+	muxSend(t, ws, 0, MuxControl(MuxEOF, 1, nil))
+
+	var got []byte
+	for {
+		id, p := readMuxFrame(t, ws)
+		if id == 1 {
+			got = append(got, p...)
+			continue
+		}
+		typ, sid, _, err := ParseMuxControl(p)
+		if err != nil || sid != 1 || typ == MuxWindow {
+			continue
+		}
+		if typ != MuxEOF {
+			t.Fatalf("control type %d before the backend's EOF", typ)
+		}
+		break
+	}
+	if string(got) != "last words" {
+		t.Fatalf("echo before EOF %q", got)
+	}
+	expectMuxClose(t, ws, 1, errcode.Normal)
+}
@@ -0,0 +1,275 @@
+// Package capture writes tunnel plaintext as pcapng for Wireshark. Each
+// tunnel becomes a synthetic TCP connection between the client and the
+// backend: a handshake when the capture first sees it, one segment per
+// chunk of data with consistent sequence numbers, and a FIN exchange when
+// it ends. Packets are raw IPv4 or IPv6 (LINKTYPE_RAW), so the backend
+// protocol is dissected by port as usual.
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	linkTypeRaw = 101
+
+	optEnd     = 0
+	optComment = 1
+	optIfName  = 2
+
+	// segment payloads stay below the 16-bit IP length limit
+	maxSegment = 65000
+)
+
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+// Conn describes a tunnel as a TCP connection
+type Conn struct {
+	Client  netip.AddrPort
+	Backend netip.AddrPort
+	Comment string // attached to the SYN, e.g. target and transport
+}
+
+// flow is the TCP state of one tunnel
+type flow struct {
+	client, backend netip.AddrPort
+	cseq, bseq      uint32 // next sequence number from each side
+}
+
+// Writer writes one pcapng section with a single raw-IP interface. It is
+// safe for concurrent use by many tunnels.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flows   map[uint64]*flow
+	used    map[[2]netip.AddrPort]bool // tuples of open flows
+	ipID    uint16
+	written int64
+	packets int64
+	err     error
+}
+
+// NewWriter writes the section and interface headers to w
+func NewWriter(w io.Writer, comment string) (*Writer, error) {
+	cw := &Writer{w: w, flows: make(map[uint64]*flow), used: make(map[[2]netip.AddrPort]bool)}
+
+	shb := binary.LittleEndian.AppendUint32(nil, 0x1A2B3C4D)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // version 1.0
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0)) // section length unknown
+	shb = appendOption(shb, optComment, []byte(comment))
+	shb = appendOption(shb, optEnd, nil)
+	cw.block(blockSHB, shb)
+
+	idb := binary.LittleEndian.AppendUint16(nil, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // no snap length
+	idb = appendOption(idb, optIfName, []byte("anylink"))
+	idb = appendOption(idb, optEnd, nil)
+	cw.block(blockIDB, idb)
+	return cw, cw.err
+}
+
+// Data adds p, sent by the backend if toClient, to tunnel id. The first
+// call for id writes a handshake; when another open flow has the same
+// addresses, as QUIC streams of one connection do, the client port is
+// bumped so Wireshark keeps them apart.
+func (w *Writer) Data(id uint64, c Conn, toClient bool, p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	f := w.flows[id]
+	if f == nil {
+		f = w.open(id, c)
+	}
+	for len(p) > 0 {
+		n := min(len(p), maxSegment)
+		if toClient {
+			w.packet(f.backend, f.client, f.bseq, f.cseq, flagPSH|flagACK, p[:n], "")
+			f.bseq += uint32(n)
+		} else {
+			w.packet(f.client, f.backend, f.cseq, f.bseq, flagPSH|flagACK, p[:n], "")
+			f.cseq += uint32(n)
+		}
+		p = p[n:]
+	}
+	return w.err
+}
+
+// End closes tunnel id with a FIN from each side, if the capture saw it
+func (w *Writer) End(id uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f := w.flows[id]
+	if f == nil || w.err != nil {
+		return w.err
+	}
+	delete(w.flows, id)
+	delete(w.used, [2]netip.AddrPort{f.client, f.backend})
+	w.packet(f.client, f.backend, f.cseq, f.bseq, flagFIN|flagACK, nil, "")
+	w.packet(f.backend, f.client, f.bseq, f.cseq+1, flagFIN|flagACK, nil, "")
+	w.packet(f.client, f.backend, f.cseq+1, f.bseq+1, flagACK, nil, "")
+	return w.err
+}
+
+// Written is the number of bytes written so far
+func (w *Writer) Written() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// Packets is the number of packets written so far
+func (w *Writer) Packets() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.packets
+}
+
+// open starts a flow with a three-way handshake; callers hold mu
+func (w *Writer) open(id uint64, c Conn) *flow {
+	client := netip.AddrPortFrom(c.Client.Addr().Unmap(), c.Client.Port())
+	backend := netip.AddrPortFrom(c.Backend.Addr().Unmap(), c.Backend.Port())
+	// both ends must share an IP version
+	if client.Addr().Is4() != backend.Addr().Is4() {
+		client = netip.AddrPortFrom(netip.AddrFrom16(client.Addr().As16()), client.Port())
+		backend = netip.AddrPortFrom(netip.AddrFrom16(backend.Addr().As16()), backend.Port())
+	}
+	for w.used[[2]netip.AddrPort{client, backend}] {
+		client = netip.AddrPortFrom(client.Addr(), client.Port()+1)
+	}
+	w.used[[2]netip.AddrPort{client, backend}] = true
+	f := &flow{client: client, backend: backend, cseq: 1000, bseq: 5000}
+	w.flows[id] = f
+	w.packet(client, backend, f.cseq, 0, flagSYN, nil, c.Comment)
+	w.packet(backend, client, f.bseq, f.cseq+1, flagSYN|flagACK, nil, "")
+	f.cseq++
+	f.bseq++
+	w.packet(client, backend, f.cseq, f.bseq, flagACK, nil, "")
+	return f
+}
+
+// packet writes one IP/TCP segment as an enhanced packet block; callers
+// hold mu
+func (w *Writer) packet(src, dst netip.AddrPort, seq, ack uint32, flags byte, payload []byte, comment string) {
+	if w.err != nil {
+		return
+	}
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	if flags&flagACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], ack)
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	s, d := src.Addr().AsSlice(), dst.Addr().AsSlice()
+	sum := checksumAdd(0, s)
+	sum = checksumAdd(sum, d)
+	sum += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], checksumFold(checksumAdd(sum, tcp)))
+
+	var ip []byte
+	if src.Addr().Is4() {
+		ip = make([]byte, 20, 20+len(tcp))
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		w.ipID++
+		binary.BigEndian.PutUint16(ip[4:], w.ipID)
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], s)
+		copy(ip[16:], d)
+		binary.BigEndian.PutUint16(ip[10:], checksumFold(checksumAdd(0, ip)))
+	} else {
+		ip = make([]byte, 40, 40+len(tcp))
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], s)
+		copy(ip[24:], d)
+	}
+	ip = append(ip, tcp...)
+
+	ts := uint64(time.Now().UnixMicro())
+	epb := binary.LittleEndian.AppendUint32(nil, 0) // interface 0
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(ip)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(ip)))
+	epb = append(epb, pad(ip)...)
+	if comment != "" {
+		epb = appendOption(epb, optComment, []byte(comment))
+		epb = appendOption(epb, optEnd, nil)
+	}
+	w.block(blockEPB, epb)
+	w.packets++
+}
+
+// block writes a pcapng block around body; callers hold mu
+func (w *Writer) block(typ uint32, body []byte) {
+	if w.err != nil {
+		return
+	}
+	total := uint32(12 + len(body))
+	b := binary.LittleEndian.AppendUint32(nil, typ)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	n, err := w.w.Write(b)
+	w.written += int64(n)
+	w.err = err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, pad(value)...)
+}
+
+// pad extends b to a multiple of 4 bytes
+func pad(b []byte) []byte {
+	if n := len(b) % 4; n != 0 {
+		b = append(b, make([]byte, 4-n)...)
+	}
+	return b
+}
+
+// checksumAdd adds b to an Internet checksum sum
+func checksumAdd(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+func checksumFold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
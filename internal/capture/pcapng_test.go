@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func newTestWriter(t *testing.T) (*Writer, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "test capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, &buf
+}
+
+func readBack(t *testing.T, buf *bytes.Buffer) []Packet {
+	t.Helper()
+	pkts, err := ReadPackets(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range pkts {
+		if !p.Valid {
+			t.Errorf("packet %d has bad checksums", i)
+		}
+	}
+	return pkts
+}
+
+func TestWriterFlow(t *testing.T) {
+	w, buf := newTestWriter(t)
+	c := Conn{
+		Client:  netip.MustParseAddrPort("10.0.0.7:41000"),
+		Backend: netip.MustParseAddrPort("10.0.0.11:5432"),
+		Comment: "conn 1: db via ws",
+	}
+	if err := w.Data(1, c, false, []byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Data(1, c, true, []byte("result")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.End(1); err != nil {
+		t.Fatal(err)
+	}
+
+	pkts := readBack(t, buf)
+	// SYN, SYN-ACK, ACK, two data segments, FIN, FIN, ACK
+	if len(pkts) != 8 || int64(len(pkts)) != w.Packets() || int64(buf.Len()) != w.Written() {
+		t.Fatalf("%d packets (%d counted), %d bytes (%d counted)", len(pkts), w.Packets(), buf.Len(), w.Written())
+	}
+	syn := pkts[0]
+	if !syn.SYN() || syn.Src != c.Client || syn.Dst != c.Backend || syn.Comment != c.Comment {
+		t.Errorf("SYN %+v", syn)
+	}
+	up, down := pkts[3], pkts[4]
+	if string(up.Payload) != "query" || up.Src != c.Client || !up.PSH() {
+		t.Errorf("client data %+v", up)
+	}
+	if string(down.Payload) != "result" || down.Src != c.Backend || down.Ack != up.Seq+uint32(len(up.Payload)) {
+		t.Errorf("backend data %+v", down)
+	}
+	if !pkts[5].FIN() || !pkts[6].FIN() || pkts[5].Seq != up.Seq+uint32(len(up.Payload)) {
+		t.Errorf("FIN exchange %+v %+v", pkts[5], pkts[6])
+	}
+}
+
+func TestWriterSharedAddresses(t *testing.T) {
+	w, buf := newTestWriter(t)
+	// two QUIC streams of one connection share both addresses
+	c := Conn{
+		Client:  netip.MustParseAddrPort("127.0.0.1:50000"),
+		Backend: netip.MustParseAddrPort("127.0.0.1:6379"),
+	}
+	for id := uint64(1); id <= 2; id++ {
+		if err := w.Data(id, c, false, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var clients []netip.AddrPort
+	for _, p := range readBack(t, buf) {
+		if p.SYN() && !p.FIN() && p.Ack == 0 {
+			clients = append(clients, p.Src)
+		}
+	}
+	if len(clients) != 2 || clients[0] == clients[1] {
+		t.Errorf("flows from %v, want two distinct client ports", clients)
+	}
+}
+
+func TestWriterMixedFamilies(t *testing.T) {
+	w, buf := newTestWriter(t)
+	c := Conn{
+		Client:  netip.MustParseAddrPort("[2001:db8::1]:41000"),
+		Backend: netip.MustParseAddrPort("192.0.2.10:22"),
+	}
+	if err := w.Data(1, c, true, []byte("SSH-2.0-test\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	pkts := readBack(t, buf)
+	if len(pkts) == 0 || !pkts[0].Dst.Addr().Is6() || pkts[0].Dst.Addr().Unmap() != c.Backend.Addr() {
+		t.Errorf("mixed families written as %v -> %v, want IPv6", pkts[0].Src, pkts[0].Dst)
+	}
+}
+
+func TestWriterLargeChunk(t *testing.T) {
+	w, buf := newTestWriter(t)
+	c := Conn{
+		Client:  netip.MustParseAddrPort("10.0.0.7:41000"),
+		Backend: netip.MustParseAddrPort("10.0.0.11:80"),
+	}
+	payload := bytes.Repeat([]byte("x"), 2*maxSegment+10)
+	if err := w.Data(1, c, false, payload); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for _, p := range readBack(t, buf) {
+		got = append(got, p.Payload...)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("read back %d bytes, want %d split into segments", len(got), len(payload))
+	}
+}
+
+func TestReadPacketsTruncated(t *testing.T) {
+	w, buf := newTestWriter(t)
+	c := Conn{
+		Client:  netip.MustParseAddrPort("10.0.0.7:41000"),
+		Backend: netip.MustParseAddrPort("10.0.0.11:80"),
+	}
+	if err := w.Data(1, c, false, []byte("GET / HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	// a capture still being written ends mid-block
+	pkts, err := ReadPackets(bytes.NewReader(buf.Bytes()[:buf.Len()-10]))
+	if err != nil || len(pkts) != 3 {
+		t.Errorf("truncated capture: %d packets, %v; want the handshake", len(pkts), err)
+	}
+	if _, err := ReadPackets(bytes.NewReader([]byte("not pcapng at all"))); err == nil {
+		t.Error("garbage accepted")
+	}
+}
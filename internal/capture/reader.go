@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+)
+
+// Packet is one decoded TCP segment of a capture
+type Packet struct {
+	Src, Dst netip.AddrPort
+	Seq, Ack uint32
+	Flags    byte
+	Payload  []byte
+	Comment  string
+	Valid    bool // IP and TCP checksums are correct
+}
+
+// SYN, FIN and PSH report the segment's flags
+func (p Packet) SYN() bool { return p.Flags&flagSYN != 0 }
+func (p Packet) FIN() bool { return p.Flags&flagFIN != 0 }
+func (p Packet) PSH() bool { return p.Flags&flagPSH != 0 }
+
+var errFormat = errors.New("not an anylink pcapng capture")
+
+// ReadPackets decodes the packets of a capture written by Writer. A block
+// cut off at the end, as in a capture still being written, ends the list.
+func ReadPackets(r io.Reader) ([]Packet, error) {
+	var pkts []Packet
+	first := true
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if first {
+				return nil, errFormat
+			}
+			return pkts, nil
+		}
+		typ := binary.LittleEndian.Uint32(hdr[:4])
+		total := binary.LittleEndian.Uint32(hdr[4:])
+		if first && typ != blockSHB || total < 12 || total%4 != 0 {
+			return nil, errFormat
+		}
+		first = false
+		body := make([]byte, total-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return pkts, nil
+		}
+		body = body[:len(body)-4] // trailing length
+		if typ != blockEPB || len(body) < 20 {
+			continue
+		}
+		n := binary.LittleEndian.Uint32(body[12:])
+		if int(n) > len(body)-20 {
+			return nil, errFormat
+		}
+		p, ok := decode(body[20 : 20+n])
+		if !ok {
+			return nil, errFormat
+		}
+		opts := body[20+(n+3)&^3:]
+		for len(opts) >= 4 {
+			code := binary.LittleEndian.Uint16(opts)
+			l := int(binary.LittleEndian.Uint16(opts[2:]))
+			if code == optEnd || 4+l > len(opts) {
+				break
+			}
+			if code == optComment {
+				p.Comment = string(opts[4 : 4+l])
+			}
+			opts = opts[4+(l+3)&^3:]
+		}
+		pkts = append(pkts, p)
+	}
+}
+
+// decode parses a raw IPv4 or IPv6 TCP packet
+func decode(b []byte) (Packet, bool) {
+	var p Packet
+	var src, dst netip.Addr
+	var tcp []byte
+	valid := true
+	switch {
+	case len(b) >= 20 && b[0]>>4 == 4:
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl || b[9] != 6 {
+			return p, false
+		}
+		src = netip.AddrFrom4([4]byte(b[12:16]))
+		dst = netip.AddrFrom4([4]byte(b[16:20]))
+		valid = checksumFold(checksumAdd(0, b[:ihl])) == 0
+		tcp = b[ihl:]
+	case len(b) >= 40 && b[0]>>4 == 6:
+		if b[6] != 6 {
+			return p, false
+		}
+		src = netip.AddrFrom16([16]byte(b[8:24]))
+		dst = netip.AddrFrom16([16]byte(b[24:40]))
+		tcp = b[40:]
+	default:
+		return p, false
+	}
+	if len(tcp) < 20 {
+		return p, false
+	}
+	off := int(tcp[12]>>4) * 4
+	if off < 20 || len(tcp) < off {
+		return p, false
+	}
+	sum := checksumAdd(0, src.AsSlice())
+	sum = checksumAdd(sum, dst.AsSlice())
+	sum += 6 + uint32(len(tcp))
+	p.Valid = valid && checksumFold(checksumAdd(sum, tcp)) == 0
+	p.Src = netip.AddrPortFrom(src, binary.BigEndian.Uint16(tcp[0:]))
+	p.Dst = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(tcp[2:]))
+	p.Seq = binary.BigEndian.Uint32(tcp[4:])
+	p.Ack = binary.BigEndian.Uint32(tcp[8:])
+	p.Flags = tcp[13]
+	p.Payload = tcp[off:]
+	return p, true
+}
@@ -19,7 +19,10 @@ const Version = "0.1.0"
 type Config struct {
 	Addr           string        `json:"addr" yaml:"addr" toml:"addr"`
 	AllowedTargets []string      `json:"allowed_targets" yaml:"allowed_targets" toml:"allowed_targets"`
-	ReadTimeout    time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	DenyRanges     []string      `json:"deny_ranges" yaml:"deny_ranges" toml:"deny_ranges"`       // nil = loopback, link-local and metadata
+	TargetPolicy   []PolicyEntry `json:"target_policy" yaml:"target_policy" toml:"target_policy"` // ordered, evaluated before allowed_targets
+	ReadTimeout    time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`                   // default idle timeout
+	Timeouts       TimeoutConfig `json:"timeouts" yaml:"timeouts" toml:"timeouts"`
 	ShowVersion    bool          `json:"-" yaml:"-" toml:"-"`
 	RunTest        bool          `json:"-" yaml:"-" toml:"-"`
 	ConfigPath     string        `json:"-" yaml:"-" toml:"-"`
@@ -28,8 +31,263 @@ type Config struct {
 	TLSCert  tls.Certificate
 	QUICAddr string
 
+	AdminAddr  string `json:"admin_addr" yaml:"admin_addr" toml:"admin_addr"`    // loopback admin API, disabled when empty
+	AdminToken string `json:"admin_token" yaml:"admin_token" toml:"admin_token"` // bearer token for every admin endpoint; required off loopback
+
 	EnableWSS   bool `json:"enable_wss" yaml:"enable_wss" toml:"enable_wss"`
 	TCPPoolSize int  `json:"tcp_pool_size" yaml:"tcp_pool_size" toml:"tcp_pool_size"`
+
+	Listen ListenConfig `json:"listen" yaml:"listen" toml:"listen"`
+
+	WebTransport WebTransportConfig `json:"webtransport" yaml:"webtransport" toml:"webtransport"`
+	Proxy        ProxyConfig        `json:"proxy" yaml:"proxy" toml:"proxy"`
+	Poll         PollConfig         `json:"poll" yaml:"poll" toml:"poll"`
+	Resume       ResumeConfig       `json:"resume" yaml:"resume" toml:"resume"`
+	Mux          MuxConfig          `json:"mux" yaml:"mux" toml:"mux"`
+	Recording    RecordingConfig    `json:"recording" yaml:"recording" toml:"recording"`
+	Capture      CaptureConfig      `json:"capture" yaml:"capture" toml:"capture"`
+	Inspect      InspectConfig      `json:"inspect" yaml:"inspect" toml:"inspect"`
+
+	Routes     map[string]RouteConfig `json:"routes" yaml:"routes" toml:"routes"`                // WS path -> fixed target
+	RoutesOnly bool                   `json:"routes_only" yaml:"routes_only" toml:"routes_only"` // refuse client-chosen targets
+
+	Pool      PoolConfig               `json:"pool" yaml:"pool" toml:"pool"`
+	UDP       UDPConfig                `json:"udp" yaml:"udp" toml:"udp"`
+	Services  map[string]ServiceConfig `json:"services" yaml:"services" toml:"services"`
+	Discovery DiscoveryConfig          `json:"discovery" yaml:"discovery" toml:"discovery"`
+
+	Logging LoggingConfig `json:"logging" yaml:"logging" toml:"logging"`
+	Tracing TracingConfig `json:"tracing" yaml:"tracing" toml:"tracing"`
+	Health  HealthConfig  `json:"health" yaml:"health" toml:"health"`
+}
+
+// ListenConfig holds the optional raw TCP entry points
+type ListenConfig struct {
+	TCP       string           `json:"tcp" yaml:"tcp" toml:"tcp"`                      // clients send "target\n" first
+	TCPRoutes []TCPRouteConfig `json:"tcp_routes" yaml:"tcp_routes" toml:"tcp_routes"` // one fixed target per port
+}
+
+// TCPRouteConfig is a TCP listener that always relays to Target
+type TCPRouteConfig struct {
+	Addr   string `json:"addr" yaml:"addr" toml:"addr"`
+	Target string `json:"target" yaml:"target" toml:"target"` // host:port or service name
+}
+
+// WebTransportConfig serves HTTP/3 WebTransport on the QUIC listener
+type WebTransportConfig struct {
+	Enable  bool     `json:"enable" yaml:"enable" toml:"enable"`
+	Path    string   `json:"path" yaml:"path" toml:"path"`          // session endpoint (default /)
+	Origins []string `json:"origins" yaml:"origins" toml:"origins"` // allowed Origin headers; empty = any
+}
+
+// ProxyConfig turns the HTTP listener into a CONNECT proxy (HTTP/1.1 and
+// h2c), with RFC 8441 WebSockets over HTTP/2
+type ProxyConfig struct {
+	Enable     bool     `json:"enable" yaml:"enable" toml:"enable"`
+	AuthTokens []string `json:"auth_tokens" yaml:"auth_tokens" toml:"auth_tokens"` // Proxy-Authorization tokens; empty = no auth
+}
+
+// PollConfig serves the HTTP fallback transport for networks that strip
+// Upgrade headers: POST to open, streamed GET down, sequenced POSTs up
+type PollConfig struct {
+	Enable       bool          `json:"enable" yaml:"enable" toml:"enable"`
+	Path         string        `json:"path" yaml:"path" toml:"path"`                            // default /poll
+	ResumeWindow time.Duration `json:"resume_window" yaml:"resume_window" toml:"resume_window"` // close sessions unseen this long (default 30s)
+	BufferSize   int           `json:"buffer_size" yaml:"buffer_size" toml:"buffer_size"`       // bytes buffered per direction (default 1 MiB)
+}
+
+// ResumeConfig lets WS and QUIC clients reattach to a tunnel after the
+// transport drops, without closing the backend connection
+type ResumeConfig struct {
+	Enable     bool          `json:"enable" yaml:"enable" toml:"enable"`
+	Grace      time.Duration `json:"grace" yaml:"grace" toml:"grace"`                   // how long a detached backend is kept (default 30s)
+	BufferSize int           `json:"buffer_size" yaml:"buffer_size" toml:"buffer_size"` // downstream bytes kept for replay (default 1 MiB)
+}
+
+// MuxConfig serves many flow-controlled streams over one WebSocket
+// (?mux=1), each opened to its own target
+type MuxConfig struct {
+	Enable     bool `json:"enable" yaml:"enable" toml:"enable"`
+	Window     int  `json:"window" yaml:"window" toml:"window"`                // per-stream credit in each direction (default 256 KiB)
+	MaxStreams int  `json:"max_streams" yaml:"max_streams" toml:"max_streams"` // open streams per WebSocket (default 128)
+}
+
+// RecordingConfig stores session recordings of chosen routes and targets
+// for audit and replay
+type RecordingConfig struct {
+	Dir      string            `json:"dir" yaml:"dir" toml:"dir"`                   // required to record anything
+	Targets  map[string]string `json:"targets" yaml:"targets" toml:"targets"`       // target or service -> asciicast or raw
+	MaxSize  int               `json:"max_size" yaml:"max_size" toml:"max_size"`    // bytes per recording, then it stops (default 64 MiB)
+	MaxTotal int               `json:"max_total" yaml:"max_total" toml:"max_total"` // bytes kept in dir; the oldest are deleted (default 1 GiB)
+	Cols     int               `json:"cols" yaml:"cols" toml:"cols"`                // asciicast terminal size (default 80x24)
+	Rows     int               `json:"rows" yaml:"rows" toml:"rows"`
+}
+
+// CaptureConfig bounds the pcapng captures started through the admin API
+type CaptureConfig struct {
+	Dir         string        `json:"dir" yaml:"dir" toml:"dir"`                            // default: the system temp dir
+	MaxDuration time.Duration `json:"max_duration" yaml:"max_duration" toml:"max_duration"` // longest capture allowed (default 10m)
+	MaxBytes    int           `json:"max_bytes" yaml:"max_bytes" toml:"max_bytes"`          // file size that stops a capture (default 100 MiB)
+	MaxFiles    int           `json:"max_files" yaml:"max_files" toml:"max_files"`          // finished capture files kept in dir (default 20)
+	MaxAge      time.Duration `json:"max_age" yaml:"max_age" toml:"max_age"`                // finished capture files older than this are deleted (default 24h)
+}
+
+// InspectConfig runs protocol checks on the tunnels of chosen targets;
+// routes add their own with RouteConfig.Inspect
+type InspectConfig struct {
+	Targets map[string][]InspectorConfig `json:"targets" yaml:"targets" toml:"targets"` // target or service -> interceptors, in order
+}
+
+// InspectorConfig enables one built-in interceptor; each option applies to
+// one type
+type InspectorConfig struct {
+	Type         string   `json:"type" yaml:"type" toml:"type"`                            // redis, postgres or ssh
+	DenyCommands []string `json:"deny_commands" yaml:"deny_commands" toml:"deny_commands"` // redis (default FLUSHALL, FLUSHDB, CONFIG)
+	Users        []string `json:"users" yaml:"users" toml:"users"`                         // postgres: users allowed to log in; empty = any
+	Databases    []string `json:"databases" yaml:"databases" toml:"databases"`             // postgres: databases allowed; empty = any
+	DenySoftware []string `json:"deny_software" yaml:"deny_software" toml:"deny_software"` // ssh: glob patterns, e.g. libssh_0.*
+}
+
+// RouteConfig is a WebSocket endpoint with a fixed, operator-chosen target
+type RouteConfig struct {
+	Target           string            `json:"target" yaml:"target" toml:"target"`                                  // host:port or service name
+	AuthTokens       []string          `json:"auth_tokens" yaml:"auth_tokens" toml:"auth_tokens"`                   // bearer tokens; empty = no auth
+	Origins          []string          `json:"origins" yaml:"origins" toml:"origins"`                               // allowed Origin headers; empty = any
+	Subprotocols     []string          `json:"subprotocols" yaml:"subprotocols" toml:"subprotocols"`                // client must offer one of these
+	ReadTimeout      time.Duration     `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`                // same as timeouts.idle
+	HandshakeTimeout time.Duration     `json:"handshake_timeout" yaml:"handshake_timeout" toml:"handshake_timeout"` // WS upgrade deadline
+	Timeouts         TimeoutConfig     `json:"timeouts" yaml:"timeouts" toml:"timeouts"`                            // unset fields: the global timeouts
+	Record           string            `json:"record" yaml:"record" toml:"record"`                                  // asciicast or raw; empty = recording.targets decides
+	Inspect          []InspectorConfig `json:"inspect" yaml:"inspect" toml:"inspect"`                               // after inspect.targets
+}
+
+// TimeoutConfig bounds how long a tunnel lives. Routes override the idle,
+// max_duration and ping_interval fields; quic_session is global only.
+type TimeoutConfig struct {
+	Idle         time.Duration `json:"idle" yaml:"idle" toml:"idle"`                            // no data in either direction (default: timeout)
+	MaxDuration  time.Duration `json:"max_duration" yaml:"max_duration" toml:"max_duration"`    // absolute limit (0 = unlimited)
+	PingInterval time.Duration `json:"ping_interval" yaml:"ping_interval" toml:"ping_interval"` // WS pings (default idle/2)
+	QUICSession  time.Duration `json:"quic_session" yaml:"quic_session" toml:"quic_session"`    // close QUIC connections with no streams (default 30s)
+}
+
+// TunnelTimeouts resolves the timeouts of a tunnel on route (nil for
+// client-chosen targets): route fields win, then timeouts, then timeout
+func (c *Config) TunnelTimeouts(route *RouteConfig) TimeoutConfig {
+	t := c.Timeouts
+	if t.Idle <= 0 {
+		t.Idle = c.ReadTimeout
+	}
+	if route != nil {
+		rt := route.Timeouts
+		if rt.Idle <= 0 {
+			rt.Idle = route.ReadTimeout
+		}
+		if rt.Idle > 0 {
+			t.Idle = rt.Idle
+		}
+		if rt.MaxDuration > 0 {
+			t.MaxDuration = rt.MaxDuration
+		}
+		if rt.PingInterval > 0 {
+			t.PingInterval = rt.PingInterval
+		}
+	}
+	if t.PingInterval <= 0 {
+		t.PingInterval = t.Idle / 2
+	}
+	return t
+}
+
+// PolicyEntry is one ordered target_policy rule: exactly one of Allow or
+// Deny holds a "host[:ports]" pattern
+type PolicyEntry struct {
+	Allow string `json:"allow,omitempty" yaml:"allow,omitempty" toml:"allow,omitempty"`
+	Deny  string `json:"deny,omitempty" yaml:"deny,omitempty" toml:"deny,omitempty"`
+}
+
+// PoolConfig controls backend connection pool lifetime and limits;
+// tcp_pool_size caps idle conns per backend
+type PoolConfig struct {
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"` // 0 = keep until dead
+	MaxLifetime time.Duration `json:"max_lifetime" yaml:"max_lifetime" toml:"max_lifetime"` // 0 = unlimited
+	MaxConns    int           `json:"max_conns" yaml:"max_conns" toml:"max_conns"`          // per backend, idle + in use (0 = unlimited)
+	Prewarm     int           `json:"prewarm" yaml:"prewarm" toml:"prewarm"`                // fresh conns kept per service backend; disables reuse
+}
+
+// UDPConfig controls UDP associations ("udp/host:port" targets)
+type UDPConfig struct {
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"` // close flows with no traffic (default 60s)
+}
+
+// HealthConfig tunes the /readyz checks and pool backend health tracking
+type HealthConfig struct {
+	Backends []string           `json:"backends" yaml:"backends" toml:"backends"` // host:port targets dialed by /readyz
+	Timeout  time.Duration      `json:"timeout" yaml:"timeout" toml:"timeout"`    // overall probe budget (default 2s)
+	Cache    time.Duration      `json:"cache" yaml:"cache" toml:"cache"`          // reuse a /readyz result this long (default 1s)
+	Checks   BackendCheckConfig `json:"checks" yaml:"checks" toml:"checks"`
+}
+
+// BackendCheckConfig controls active probing and ejection of pool backends
+type BackendCheckConfig struct {
+	Interval      time.Duration `json:"interval" yaml:"interval" toml:"interval"` // active TCP probe period (0 = passive only)
+	Timeout       time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	FailThreshold int           `json:"fail_threshold" yaml:"fail_threshold" toml:"fail_threshold"` // consecutive failures before ejection
+	BaseBackoff   time.Duration `json:"base_backoff" yaml:"base_backoff" toml:"base_backoff"`
+	MaxBackoff    time.Duration `json:"max_backoff" yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// TracingConfig controls OpenTelemetry span export over OTLP/HTTP
+type TracingConfig struct {
+	Enable      bool    `json:"enable" yaml:"enable" toml:"enable"`
+	Endpoint    string  `json:"endpoint" yaml:"endpoint" toml:"endpoint"` // collector host:port (default localhost:4318)
+	Insecure    bool    `json:"insecure" yaml:"insecure" toml:"insecure"` // plain HTTP to the collector
+	ServiceName string  `json:"service_name" yaml:"service_name" toml:"service_name"`
+	SampleRatio float64 `json:"sample_ratio" yaml:"sample_ratio" toml:"sample_ratio"` // 0 < r <= 1 (default 1)
+}
+
+// ServiceConfig maps a logical target name (e.g. postgres-prod) to backends.
+// Clients request the name; backend addresses are never exposed to them.
+type ServiceConfig struct {
+	Backends []ServiceBackend `json:"backends" yaml:"backends" toml:"backends"`
+	Policy   string           `json:"policy" yaml:"policy" toml:"policy"`    // round_robin (default) | weighted | least_conn | random_two_choices | consistent_hash
+	HashOn   string           `json:"hash_on" yaml:"hash_on" toml:"hash_on"` // consistent_hash key: client_ip (default) | header:<Name>
+
+	// DNS discovery, instead of a static backend list
+	DNS     string `json:"dns" yaml:"dns" toml:"dns"`                // SRV record or host name
+	DNSType string `json:"dns_type" yaml:"dns_type" toml:"dns_type"` // srv (default) | a
+	Port    int    `json:"port" yaml:"port" toml:"port"`             // backend port for dns_type a
+}
+
+// DiscoveryConfig controls how DNS-discovered services are resolved
+type DiscoveryConfig struct {
+	Resolver string        `json:"resolver" yaml:"resolver" toml:"resolver"` // DNS server host:port (default: /etc/resolv.conf)
+	MinTTL   time.Duration `json:"min_ttl" yaml:"min_ttl" toml:"min_ttl"`    // refresh floor (default 5s)
+	MaxTTL   time.Duration `json:"max_ttl" yaml:"max_ttl" toml:"max_ttl"`    // refresh ceiling (default 5m)
+}
+
+// ServiceBackend is one backend of a service
+type ServiceBackend struct {
+	Addr   string `json:"addr" yaml:"addr" toml:"addr"`
+	Weight int    `json:"weight" yaml:"weight" toml:"weight"` // default 1
+}
+
+// LoggingConfig describes log levels and outputs
+type LoggingConfig struct {
+	Level   string            `json:"level" yaml:"level" toml:"level"`
+	Modules map[string]string `json:"modules" yaml:"modules" toml:"modules"` // per-prefix overrides, e.g. bridge: trace
+	Outputs []LogOutput       `json:"outputs" yaml:"outputs" toml:"outputs"`
+}
+
+// LogOutput is a single log sink
+type LogOutput struct {
+	Type       string        `json:"type" yaml:"type" toml:"type"`    // stderr | file | syslog
+	Level      string        `json:"level" yaml:"level" toml:"level"` // most verbose level written (default trace)
+	Path       string        `json:"path" yaml:"path" toml:"path"`    // file path, or syslog socket
+	Tag        string        `json:"tag" yaml:"tag" toml:"tag"`       // syslog tag
+	MaxSizeMB  int           `json:"max_size_mb" yaml:"max_size_mb" toml:"max_size_mb"`
+	MaxAge     time.Duration `json:"max_age" yaml:"max_age" toml:"max_age"`
+	MaxBackups int           `json:"max_backups" yaml:"max_backups" toml:"max_backups"`
+	Compress   bool          `json:"compress" yaml:"compress" toml:"compress"`
 }
 
 // Parse parses CLI arguments and (optionally) loads a config file.
@@ -40,7 +298,7 @@ func Parse() *Config {
 	flag.StringVar(&cfg.Addr, "addr", ":8080", "Address and port to listen on (e.g., :8080 or 0.0.0.0:9000)")
 	flag.StringVar(&cfg.Addr, "a", ":8080", "alias for --addr")
 	flag.StringVar(&cfg.ConfigPath, "config", "", "Path to YAML/JSON/TOML configuration file")
-	flag.DurationVar(&cfg.ReadTimeout, "timeout", 60*time.Second, "Read timeout for WebSocket connections.")
+	flag.DurationVar(&cfg.ReadTimeout, "timeout", 60*time.Second, "Idle timeout for tunnels.")
 	flag.BoolVar(&cfg.ShowVersion, "version", false, "Show version and exit")
 	flag.BoolVar(&cfg.RunTest, "test", false, "Run internal echo test and exit")
 
@@ -107,6 +365,19 @@ func validate(cfg *Config) {
 	}
 }
 
+// Load reads cfg.ConfigPath (if set) and merges it into cfg; CLI values win.
+func Load(cfg *Config) error {
+	if cfg.ConfigPath == "" {
+		return nil
+	}
+	fileCfg, err := loadFromFile(cfg.ConfigPath)
+	if err != nil {
+		return err
+	}
+	merge(cfg, fileCfg)
+	return nil
+}
+
 // loadFromFile parses a YAML/JSON/TOML config file
 func loadFromFile(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -132,10 +403,46 @@ func merge(dst, src *Config) {
 	if dst.Addr == ":8080" && src.Addr != "" {
 		dst.Addr = src.Addr
 	}
+	if dst.AdminAddr == "" {
+		dst.AdminAddr = src.AdminAddr
+	}
+	if dst.AdminToken == "" {
+		dst.AdminToken = src.AdminToken
+	}
 	if len(dst.AllowedTargets) == 0 && len(src.AllowedTargets) > 0 {
 		dst.AllowedTargets = src.AllowedTargets
 	}
-	if dst.ReadTimeout == 60*time.Second && src.ReadTimeout != 0 {
+	if dst.TargetPolicy == nil {
+		dst.TargetPolicy = src.TargetPolicy
+	}
+	if dst.DenyRanges == nil {
+		dst.DenyRanges = src.DenyRanges
+	}
+	if (dst.ReadTimeout == 0 || dst.ReadTimeout == 60*time.Second) && src.ReadTimeout != 0 {
 		dst.ReadTimeout = src.ReadTimeout
 	}
+	if dst.TCPPoolSize == 0 {
+		dst.TCPPoolSize = src.TCPPoolSize
+	}
+	// file-only sections
+	dst.Pool = src.Pool
+	dst.UDP = src.UDP
+	dst.Listen = src.Listen
+	dst.WebTransport = src.WebTransport
+	dst.Proxy = src.Proxy
+	dst.Poll = src.Poll
+	dst.Resume = src.Resume
+	dst.Mux = src.Mux
+	dst.Recording = src.Recording
+	dst.Capture = src.Capture
+	dst.Inspect = src.Inspect
+	dst.Timeouts = src.Timeouts
+	dst.Routes = src.Routes
+	dst.RoutesOnly = dst.RoutesOnly || src.RoutesOnly
+	dst.Services = src.Services
+	dst.Discovery = src.Discovery
+	// logging is file-only; the --verbose flag is applied on top by the caller
+	dst.Logging = src.Logging
+	dst.Tracing = src.Tracing
+	dst.Health = src.Health
 }
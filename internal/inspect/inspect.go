@@ -0,0 +1,47 @@
+// Package inspect holds the built-in bridge interceptors: protocol-aware
+// guardrails for tunnels to Redis, PostgreSQL and SSH backends. They read
+// the plaintext a tunnel carries, so a backend the client reaches over
+// TLS cannot be inspected.
+package inspect
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+// New returns a fresh interceptor for one tunnel as configured by c. Every
+// tunnel needs its own, as interceptors track the protocol's state.
+func New(c config.InspectorConfig) (bridge.Interceptor, error) {
+	if c.Type != "redis" && c.Type != "postgres" && c.Type != "ssh" {
+		return nil, fmt.Errorf("unknown inspector type %q (want redis, postgres or ssh)", c.Type)
+	}
+	// an option of another type would be a guardrail silently not applied
+	for _, o := range []struct {
+		name, typ string
+		set       bool
+	}{
+		{"deny_commands", "redis", len(c.DenyCommands) > 0},
+		{"users", "postgres", len(c.Users) > 0},
+		{"databases", "postgres", len(c.Databases) > 0},
+		{"deny_software", "ssh", len(c.DenySoftware) > 0},
+	} {
+		if o.set && o.typ != c.Type {
+			return nil, fmt.Errorf("%s applies to %s, not %s", o.name, o.typ, c.Type)
+		}
+	}
+	switch c.Type {
+	case "redis":
+		return newRedis(c.DenyCommands), nil
+	case "postgres":
+		return newPostgres(c.Users, c.Databases), nil
+	}
+	for _, p := range c.DenySoftware {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("deny_software %q: %v", p, err)
+		}
+	}
+	return newSSH(c.DenySoftware), nil
+}
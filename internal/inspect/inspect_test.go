@@ -0,0 +1,41 @@
+package inspect
+
+import (
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+// feed passes client chunks through i and returns what reaches the backend,
+// stopping at the first error
+func feed(i bridge.Interceptor, chunks ...string) (string, error) {
+	var out []byte
+	for _, c := range chunks {
+		p, err := i.Intercept(false, []byte(c))
+		out = append(out, p...)
+		if err != nil {
+			return string(out), err
+		}
+	}
+	return string(out), nil
+}
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		c  config.InspectorConfig
+		ok bool
+	}{
+		{config.InspectorConfig{Type: "redis"}, true},
+		{config.InspectorConfig{Type: "postgres", Users: []string{"analyst"}}, true},
+		{config.InspectorConfig{Type: "ssh", DenySoftware: []string{"libssh_0.*"}}, true},
+		{config.InspectorConfig{Type: "mysql"}, false},
+		// an option of another type would silently not apply
+		{config.InspectorConfig{Type: "redis", Users: []string{"analyst"}}, false},
+		{config.InspectorConfig{Type: "ssh", DenySoftware: []string{"["}}, false},
+	} {
+		if _, err := New(tc.c); (err == nil) != tc.ok {
+			t.Errorf("New(%+v) = %v, want ok %v", tc.c, err, tc.ok)
+		}
+	}
+}
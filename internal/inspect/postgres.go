@@ -0,0 +1,118 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// PostgreSQL startup codes (protocol 3.0 is 196608)
+const (
+	pgCancelRequest = 80877102
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+
+	maxPGStartup = 10000 // as the backend limits it
+)
+
+var errPGEncrypted = errors.New("postgres: no plaintext startup message, so the user cannot be checked")
+
+// postgres checks the user and database of the client's startup message
+// against allowlists. The message is held back until it is complete; an
+// SSLRequest or GSSENCRequest passes, but encryption then hides the
+// startup message and the tunnel is blocked.
+type postgres struct {
+	users, databases map[string]bool // nil: any
+
+	buf  []byte // client bytes of the startup phase not yet forwarded
+	done bool   // startup checked, everything passes
+}
+
+func newPostgres(users, databases []string) *postgres {
+	return &postgres{users: set(users), databases: set(databases)}
+}
+
+func (pg *postgres) Intercept(toClient bool, p []byte) ([]byte, error) {
+	if toClient || pg.done {
+		return p, nil
+	}
+	pg.buf = append(pg.buf, p...)
+	var out []byte
+	for !pg.done && len(pg.buf) >= 8 {
+		n := int(binary.BigEndian.Uint32(pg.buf))
+		code := binary.BigEndian.Uint32(pg.buf[4:])
+		if n < 8 || n > maxPGStartup {
+			return nil, errPGEncrypted
+		}
+		if len(pg.buf) < n {
+			break
+		}
+		switch {
+		case code == pgSSLRequest || code == pgGSSENCRequest:
+			// the next message follows the backend's one-byte answer
+		case code == pgCancelRequest:
+			pg.done = true
+		case code>>16 == 3:
+			if err := pg.check(pg.buf[8:n]); err != nil {
+				return nil, err
+			}
+			pg.done = true
+		default:
+			return nil, fmt.Errorf("postgres: unsupported protocol %d.%d", code>>16, code&0xffff)
+		}
+		out = append(out, pg.buf[:n]...)
+		pg.buf = pg.buf[n:]
+	}
+	if pg.done {
+		out = append(out, pg.buf...)
+		pg.buf = nil
+	}
+	return out, nil
+}
+
+// check reads the name/value pairs of a startup message
+func (pg *postgres) check(params []byte) error {
+	values := make(map[string]string)
+	for {
+		i := bytes.IndexByte(params, 0)
+		if i <= 0 {
+			break // the terminating empty name, or a malformed message
+		}
+		key := string(params[:i])
+		params = params[i+1:]
+		j := bytes.IndexByte(params, 0)
+		if j < 0 {
+			return errors.New("postgres: malformed startup message")
+		}
+		values[key] = string(params[:j])
+		params = params[j+1:]
+	}
+	user := values["user"]
+	if user == "" {
+		return errors.New("postgres: startup message names no user")
+	}
+	db := values["database"]
+	if db == "" {
+		db = user
+	}
+	if pg.users != nil && !pg.users[user] {
+		return fmt.Errorf("postgres: user %q is not allowed", user)
+	}
+	if pg.databases != nil && !pg.databases[db] {
+		return fmt.Errorf("postgres: database %q is not allowed", db)
+	}
+	return nil
+}
+
+// set returns the members of list, or nil for an empty list
+func set(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
@@ -0,0 +1,56 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// pgStartup builds a protocol 3.0 startup message
+func pgStartup(user, database string) []byte {
+	var params bytes.Buffer
+	params.WriteString("user\x00" + user + "\x00")
+	if database != "" {
+		params.WriteString("database\x00" + database + "\x00")
+	}
+	params.WriteByte(0)
+	msg := binary.BigEndian.AppendUint32(nil, uint32(8+params.Len()))
+	msg = binary.BigEndian.AppendUint32(msg, 3<<16)
+	return append(msg, params.Bytes()...)
+}
+
+func TestPostgresStartup(t *testing.T) {
+	ssl := string(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), pgSSLRequest))
+	allowed := string(pgStartup("analyst", "sales"))
+	for _, tc := range []struct {
+		name   string
+		chunks []string
+		passed string
+		denied bool
+	}{
+		{"allowed", []string{allowed, "Q..."}, allowed + "Q...", false},
+		{"split and after SSLRequest", []string{ssl + allowed[:5], allowed[5:] + "Q"}, ssl + allowed + "Q", false},
+		{"user", []string{string(pgStartup("postgres", "sales"))}, "", true},
+		// no database: it defaults to the user name
+		{"default database", []string{string(pgStartup("analyst", ""))}, "", true},
+		{"tls handshake", []string{"\x16\x03\x01\x02\x00\x01\x00\x01"}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			passed, err := feed(newPostgres([]string{"analyst"}, []string{"sales"}), tc.chunks...)
+			if passed != tc.passed || (err != nil) != tc.denied {
+				t.Errorf("passed %q, err %v; want %q, denied %v", passed, err, tc.passed, tc.denied)
+			}
+		})
+	}
+}
+
+func TestPostgresHoldsStartup(t *testing.T) {
+	pg := newPostgres([]string{"analyst"}, nil)
+	msg := pgStartup("analyst", "")
+	if out, err := pg.Intercept(false, msg[:len(msg)-1]); err != nil || len(out) != 0 {
+		t.Fatalf("partial startup forwarded %q, %v", out, err)
+	}
+	if out, err := pg.Intercept(false, msg[len(msg)-1:]); err != nil || !bytes.Equal(out, msg) {
+		t.Errorf("complete startup forwarded %q, %v", out, err)
+	}
+}
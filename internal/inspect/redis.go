@@ -0,0 +1,130 @@
+package inspect
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultRedisDeny are the commands a redis interceptor blocks by default
+var defaultRedisDeny = []string{"FLUSHALL", "FLUSHDB", "CONFIG"}
+
+const (
+	maxRedisLine = 64 << 10 // as Redis limits inline commands
+	maxRedisName = 64       // longer first arguments are no known command
+)
+
+// redis blocks denylisted commands sent by the client, in RESP arrays or
+// inline. It parses the request stream as it passes, without holding any
+// of it back: the chunk that completes a denied command name is the one
+// blocked, so the backend never receives a whole one.
+type redis struct {
+	deny map[string]bool
+
+	line   []byte // header or inline command being read
+	args   int    // bulk strings left in the current array
+	first  bool   // the next bulk string is the command name
+	bulk   int    // bytes left in the current bulk string, with CRLF
+	inBulk bool
+	name   []byte // command name read so far, with CRLF
+}
+
+func newRedis(deny []string) *redis {
+	if len(deny) == 0 {
+		deny = defaultRedisDeny
+	}
+	r := &redis{deny: make(map[string]bool, len(deny))}
+	for _, c := range deny {
+		r.deny[strings.ToUpper(c)] = true
+	}
+	return r
+}
+
+func (r *redis) Intercept(toClient bool, p []byte) ([]byte, error) {
+	if toClient {
+		return p, nil
+	}
+	for rest := p; len(rest) > 0; {
+		if r.inBulk {
+			n := min(r.bulk, len(rest))
+			if r.first {
+				r.name = append(r.name, rest[:min(n, max(maxRedisName+2-len(r.name), 0))]...)
+			}
+			r.bulk -= n
+			rest = rest[n:]
+			if r.bulk > 0 {
+				break
+			}
+			r.inBulk = false
+			if r.first {
+				r.first = false
+				if err := r.check(bytes.TrimSuffix(r.name, []byte("\r\n"))); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			r.line = append(r.line, rest...)
+			if len(r.line) > maxRedisLine {
+				return nil, fmt.Errorf("redis: request line over %d bytes", maxRedisLine)
+			}
+			break
+		}
+		r.line = append(r.line, rest[:i+1]...)
+		rest = rest[i+1:]
+		if err := r.endLine(bytes.TrimRight(r.line, "\r\n")); err != nil {
+			return nil, err
+		}
+		r.line = r.line[:0]
+	}
+	return p, nil
+}
+
+// endLine handles a complete header or inline command
+func (r *redis) endLine(line []byte) error {
+	if r.args > 0 {
+		if len(line) == 0 || line[0] != '$' {
+			return fmt.Errorf("redis: want a bulk string, got %q", truncate(line))
+		}
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < 0 {
+			return fmt.Errorf("redis: bad bulk length %q", truncate(line))
+		}
+		r.args--
+		r.bulk, r.inBulk = n+2, true
+		r.name = r.name[:0]
+		return nil
+	}
+	if len(line) > 0 && line[0] == '*' {
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return fmt.Errorf("redis: bad array length %q", truncate(line))
+		}
+		r.args, r.first = max(n, 0), n > 0
+		return nil
+	}
+	// inline command
+	if fields := bytes.Fields(line); len(fields) > 0 {
+		return r.check(fields[0])
+	}
+	return nil
+}
+
+func (r *redis) check(name []byte) error {
+	cmd := strings.ToUpper(string(name))
+	if r.deny[cmd] {
+		return fmt.Errorf("redis: command %s is denied", cmd)
+	}
+	return nil
+}
+
+// truncate shortens protocol text quoted in errors
+func truncate(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
@@ -0,0 +1,43 @@
+package inspect
+
+import "testing"
+
+func TestRedisDenylist(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		chunks []string
+		passed string // what reaches the backend
+		denied bool
+	}{
+		{"allowed", []string{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "PING\r\n"}, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\nPING\r\n", false},
+		// the chunk completing the name is blocked, so FLUSHALL never arrives whole
+		{"split name", []string{"*1\r\n$8\r\nFLU", "SHALL\r\n"}, "*1\r\n$8\r\nFLU", true},
+		{"inline lower case", []string{"config set dir /tmp\r\n"}, "", true},
+		{"denied argument is fine", []string{"*2\r\n$3\r\nGET\r\n$8\r\nFLUSHALL\r\n"}, "*2\r\n$3\r\nGET\r\n$8\r\nFLUSHALL\r\n", false},
+		{"bad bulk", []string{"*1\r\nGET\r\n"}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			passed, err := feed(newRedis(nil), tc.chunks...)
+			if passed != tc.passed || (err != nil) != tc.denied {
+				t.Errorf("passed %q, err %v; want %q, denied %v", passed, err, tc.passed, tc.denied)
+			}
+		})
+	}
+}
+
+func TestRedisCustomDeny(t *testing.T) {
+	r := newRedis([]string{"debug"})
+	if _, err := feed(r, "FLUSHALL\r\n"); err != nil {
+		t.Errorf("FLUSHALL blocked by a custom list without it: %v", err)
+	}
+	if _, err := feed(r, "DEBUG SLEEP 1\r\n"); err == nil {
+		t.Error("DEBUG passed")
+	}
+}
+
+func TestRedisReplies(t *testing.T) {
+	out, err := newRedis(nil).Intercept(true, []byte("FLUSHALL\r\n"))
+	if err != nil || string(out) != "FLUSHALL\r\n" {
+		t.Errorf("backend output %q, %v; want it untouched", out, err)
+	}
+}
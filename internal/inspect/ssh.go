@@ -0,0 +1,87 @@
+package inspect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	maxSSHLine     = 255     // RFC 4253 4.2, CRLF included
+	maxSSHPreamble = 8 << 10 // lines a server may send before its version
+)
+
+var (
+	sshPrefix    = []byte("SSH-")
+	errSSHClient = errors.New("ssh: client did not send an SSH version line")
+)
+
+// ssh requires both sides to open with an SSH-2.0 version line and blocks
+// software matching a deny pattern, such as old or unwanted clients. Each
+// side's data is held back until its version line is complete.
+type ssh struct {
+	deny  []string // path.Match patterns for the software version
+	sides [2]sshSide
+}
+
+type sshSide struct {
+	buf  []byte // held back until the version line
+	off  int    // start of the line being read
+	done bool
+}
+
+func newSSH(deny []string) *ssh { return &ssh{deny: deny} }
+
+func (x *ssh) Intercept(toClient bool, p []byte) ([]byte, error) {
+	who, s := "client", &x.sides[0]
+	if toClient {
+		who, s = "server", &x.sides[1]
+	}
+	if s.done {
+		return p, nil
+	}
+	s.buf = append(s.buf, p...)
+	for {
+		line := s.buf[s.off:]
+		// a client must start with its version; fail fast on anything else
+		if !toClient && !bytes.HasPrefix(sshPrefix, line[:min(len(line), len(sshPrefix))]) {
+			return nil, errSSHClient
+		}
+		i := bytes.IndexByte(line, '\n')
+		if i < 0 {
+			if len(line) >= maxSSHLine || len(s.buf) > maxSSHPreamble {
+				return nil, fmt.Errorf("ssh: no version line from the %s", who)
+			}
+			return nil, nil
+		}
+		s.off += i + 1
+		if bytes.HasPrefix(line, sshPrefix) {
+			if err := x.check(who, string(bytes.TrimRight(line[:i], "\r"))); err != nil {
+				return nil, err
+			}
+			out := s.buf
+			s.buf, s.done = nil, true
+			return out, nil
+		}
+	}
+}
+
+// check parses SSH-protoversion-softwareversion [comments]
+func (x *ssh) check(who, line string) error {
+	proto, software, ok := strings.Cut(strings.TrimPrefix(line, "SSH-"), "-")
+	if !ok || software == "" {
+		return fmt.Errorf("ssh: malformed %s version %q", who, line)
+	}
+	if proto != "2.0" && proto != "1.99" {
+		return fmt.Errorf("ssh: %s speaks SSH %s", who, proto)
+	}
+	software, _, _ = strings.Cut(software, " ")
+	for _, pattern := range x.deny {
+		if ok, _ := path.Match(pattern, software); ok {
+			return fmt.Errorf("ssh: %s software %s is denied", who, software)
+		}
+	}
+	return nil
+}
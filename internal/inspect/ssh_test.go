@@ -0,0 +1,37 @@
+package inspect
+
+import "testing"
+
+func TestSSHVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		chunks []string
+		passed string
+		denied bool
+	}{
+		{"allowed", []string{"SSH-2.0-OpenSSH_9.6 Debian\r\n", "kex"}, "SSH-2.0-OpenSSH_9.6 Debian\r\nkex", false},
+		{"split", []string{"SSH-2.0-Open", "SSH_9.6\r\nkex"}, "SSH-2.0-OpenSSH_9.6\r\nkex", false},
+		{"denied software", []string{"SSH-2.0-libssh_0.9.6\r\n"}, "", true},
+		{"ssh 1", []string{"SSH-1.5-OpenSSH_2.0\r\n"}, "", true},
+		{"not ssh", []string{"GET / HTTP/1.1\r\n"}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			passed, err := feed(newSSH([]string{"libssh_0.*"}), tc.chunks...)
+			if passed != tc.passed || (err != nil) != tc.denied {
+				t.Errorf("passed %q, err %v; want %q, denied %v", passed, err, tc.passed, tc.denied)
+			}
+		})
+	}
+}
+
+func TestSSHServerPreamble(t *testing.T) {
+	// a server may send other lines before its version
+	x := newSSH(nil)
+	out, err := x.Intercept(true, []byte("welcome\r\nSSH-2.0-OpenSSH_9.6\r\n"))
+	if err != nil || string(out) != "welcome\r\nSSH-2.0-OpenSSH_9.6\r\n" {
+		t.Errorf("server output %q, %v", out, err)
+	}
+	if _, err := newSSH(nil).Intercept(true, []byte("SSH-2.0-\r\n")); err == nil {
+		t.Error("malformed server version passed")
+	}
+}
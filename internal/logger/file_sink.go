@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOptions configures a rotating log file
+type FileOptions struct {
+	Path       string
+	MaxSize    int64         // rotate once the file would exceed this many bytes (0 = never)
+	MaxAge     time.Duration // rotate once the file is older than this (0 = never)
+	MaxBackups int           // rotated files to keep (0 = keep all)
+	Compress   bool          // gzip rotated files
+}
+
+// fileSink appends to a file and rotates it by size and age
+type fileSink struct {
+	mu     sync.Mutex
+	opts   FileOptions
+	f      *os.File
+	size   int64
+	opened time.Time
+	wg     sync.WaitGroup // pending compress/prune jobs
+}
+
+// NewFileSink opens (or creates) opts.Path for appending
+func NewFileSink(opts FileOptions) (Sink, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("file sink: empty path")
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, err
+	}
+	s := &fileSink{opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	s.opened = time.Now()
+	return nil
+}
+
+func (s *fileSink) Write(t time.Time, _ Level, msg string) error {
+	line := formatLine(t, msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	var rotErr error
+	if s.shouldRotate(int64(len(line)), t) {
+		rotErr = s.rotate(t)
+		if s.f == nil {
+			return rotErr
+		}
+	}
+	n, err := io.WriteString(s.f, line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotErr
+}
+
+func (s *fileSink) shouldRotate(next int64, now time.Time) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.opts.MaxSize > 0 && s.size+next > s.opts.MaxSize {
+		return true
+	}
+	return s.opts.MaxAge > 0 && now.Sub(s.opened) >= s.opts.MaxAge
+}
+
+// rotate renames the current file with a timestamp suffix and reopens Path.
+// If the close, rename or reopen fails the sink keeps appending to Path so
+// that file logging does not stop on a transient error.
+func (s *fileSink) rotate(now time.Time) error {
+	err := s.f.Close()
+	s.f = nil
+	if err != nil {
+		return s.reopen(err)
+	}
+	backup := s.opts.Path + "." + now.Format("20060102-150405.000")
+	if err := os.Rename(s.opts.Path, backup); err != nil {
+		return s.reopen(err)
+	}
+	if err := s.open(); err != nil {
+		return s.reopen(err)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if s.opts.Compress {
+			if err := compressFile(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "logger: compress %s: %v\n", backup, err)
+			}
+		}
+		s.prune()
+	}()
+	return nil
+}
+
+// reopen recovers from a failed rotation by appending to Path again.
+// cause is returned either way so the caller sees the rotation failure.
+func (s *fileSink) reopen(cause error) error {
+	if err := s.open(); err != nil {
+		return fmt.Errorf("rotate: %w (reopen: %v)", cause, err)
+	}
+	return fmt.Errorf("rotate: %w", cause)
+}
+
+// prune removes the oldest backups beyond MaxBackups
+func (s *fileSink) prune() {
+	if s.opts.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(s.opts.Path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, m := range matches {
+		// skip in-flight compression output
+		if !strings.HasSuffix(m, ".gz.tmp") {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= s.opts.MaxBackups {
+		return
+	}
+	// timestamp suffix sorts chronologically
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-s.opts.MaxBackups] {
+		_ = os.Remove(old)
+	}
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.f != nil {
+		err = s.f.Close()
+		s.f = nil
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// compressFile gzips path into path.gz and removes the original
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
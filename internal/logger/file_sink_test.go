@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSink(t *testing.T, opts FileOptions) *fileSink {
+	t.Helper()
+	opts.Path = filepath.Join(t.TempDir(), "anylink.log")
+	s, err := NewFileSink(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s.(*fileSink)
+}
+
+// backups lists the rotated files next to the sink's log, oldest first
+func backups(t *testing.T, s *fileSink) []string {
+	t.Helper()
+	matches, err := filepath.Glob(s.opts.Path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotateBySize(t *testing.T) {
+	s := newTestSink(t, FileOptions{MaxSize: 64})
+	now := time.Now()
+	for i, msg := range []string{"first line", "second line", "third line"} {
+		if err := s.Write(now.Add(time.Duration(i)*time.Second), LevelInfo, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// each line is ~31 bytes: two fit, the third rotates
+	files := backups(t, s)
+	if len(files) != 1 {
+		t.Fatalf("backups %v, want 1", files)
+	}
+	if got := readFile(t, files[0]); !strings.Contains(got, "first line") || !strings.Contains(got, "second line") {
+		t.Errorf("backup holds %q", got)
+	}
+	if got := readFile(t, s.opts.Path); !strings.HasSuffix(got, "third line\n") || strings.Contains(got, "second") {
+		t.Errorf("current file holds %q", got)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	s := newTestSink(t, FileOptions{MaxAge: time.Hour})
+	now := time.Now()
+	if err := s.Write(now, LevelInfo, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(now.Add(30*time.Minute), LevelInfo, "still fresh"); err != nil {
+		t.Fatal(err)
+	}
+	if files := backups(t, s); len(files) != 0 {
+		t.Fatalf("rotated before max_age: %v", files)
+	}
+	if err := s.Write(now.Add(2*time.Hour), LevelInfo, "new"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	files := backups(t, s)
+	if len(files) != 1 {
+		t.Fatalf("backups %v, want 1", files)
+	}
+	if got := readFile(t, s.opts.Path); !strings.HasSuffix(got, "new\n") || strings.Contains(got, "old") {
+		t.Errorf("current file holds %q", got)
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	s := newTestSink(t, FileOptions{MaxSize: 1, Compress: true})
+	now := time.Now()
+	for i, msg := range []string{"compressed", "current"} {
+		if err := s.Write(now.Add(time.Duration(i)*time.Second), LevelInfo, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close() // waits for the compression
+
+	files := backups(t, s)
+	if len(files) != 1 || !strings.HasSuffix(files[0], ".gz") {
+		t.Fatalf("backups %v, want one .gz file", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(got), "compressed\n") {
+		t.Errorf("decompressed backup holds %q", got)
+	}
+}
+
+func TestRotateMaxBackups(t *testing.T) {
+	s := newTestSink(t, FileOptions{MaxSize: 1, MaxBackups: 2})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := s.Write(now.Add(time.Duration(i)*time.Second), LevelInfo, "line"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// four rotations, named by the write that caused them; the two newest
+	// are kept
+	files := backups(t, s)
+	if len(files) != 2 {
+		t.Fatalf("backups %v, want 2", files)
+	}
+	want := s.opts.Path + "." + now.Add(4*time.Second).Format("20060102-150405.000")
+	if files[1] != want {
+		t.Errorf("newest backup %s, want %s", files[1], want)
+	}
+}
+
+func TestRotateRecoversFromCloseError(t *testing.T) {
+	s := newTestSink(t, FileOptions{MaxAge: time.Hour})
+	now := time.Now()
+	if err := s.Write(now, LevelInfo, "before"); err != nil {
+		t.Fatal(err)
+	}
+	s.f.Close() // the rotation's Close now fails
+
+	if err := s.Write(now.Add(2*time.Hour), LevelInfo, "during"); err == nil {
+		t.Fatal("failed rotation was not reported")
+	}
+	if err := s.Write(now, LevelInfo, "after"); err != nil {
+		t.Fatalf("write after failed rotation: %v", err)
+	}
+	s.Close()
+	if got := readFile(t, s.opts.Path); !strings.Contains(got, "during") || !strings.Contains(got, "after") {
+		t.Errorf("log file holds %q, want the lines written after the failure", got)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Level represents the logging level
@@ -19,30 +21,63 @@ const (
 )
 
 var (
-	globalLevel Level = LevelInfo
-	globalMu    sync.RWMutex
+	globalLevel  Level = LevelInfo
+	moduleLevels       = make(map[string]Level)
+	globalMu     sync.RWMutex
+
+	// sinkMu guards outputs. write holds it shared for as long as it uses
+	// the sinks, so SetOutputs cannot close one under an in-flight write.
+	outputs []Output
+	sinkMu  sync.RWMutex
 )
 
-// SetLevel sets the global logging level
-func SetLevel(level string) {
-	globalMu.Lock()
-	defer globalMu.Unlock()
-	
-	switch level {
+// ParseLevel converts a level name (quiet|error|info|debug|trace) to a Level
+func ParseLevel(level string) (Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
 	case "quiet":
-		globalLevel = LevelQuiet
+		return LevelQuiet, true
 	case "error":
-		globalLevel = LevelError
+		return LevelError, true
 	case "info":
-		globalLevel = LevelInfo
+		return LevelInfo, true
 	case "debug":
-		globalLevel = LevelDebug
+		return LevelDebug, true
 	case "trace":
-		globalLevel = LevelTrace
-	default:
-		// Default to debug for backwards compatibility
-		globalLevel = LevelDebug
+		return LevelTrace, true
 	}
+	return LevelDebug, false
+}
+
+// String returns the level name
+func (lv Level) String() string {
+	switch lv {
+	case LevelQuiet:
+		return "quiet"
+	case LevelError:
+		return "error"
+	case LevelInfo:
+		return "info"
+	case LevelDebug:
+		return "debug"
+	case LevelTrace:
+		return "trace"
+	}
+	return fmt.Sprintf("level(%d)", int(lv))
+}
+
+// MarshalText encodes a level by name, as in the admin API
+func (lv Level) MarshalText() ([]byte, error) {
+	return []byte(lv.String()), nil
+}
+
+// SetLevel sets the global logging level
+func SetLevel(level string) {
+	// Unknown names default to debug for backwards compatibility
+	lv, _ := ParseLevel(level)
+
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalLevel = lv
 }
 
 // GetLevel returns the current logging level
@@ -52,6 +87,62 @@ func GetLevel() Level {
 	return globalLevel
 }
 
+// SetModuleLevel overrides the level for loggers created with the given prefix
+// (e.g. "bridge", "server"). An empty level removes the override.
+func SetModuleLevel(module, level string) error {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	if level == "" {
+		delete(moduleLevels, module)
+		return nil
+	}
+	lv, ok := ParseLevel(level)
+	if !ok {
+		return fmt.Errorf("unknown log level %q for module %s", level, module)
+	}
+	moduleLevels[module] = lv
+	return nil
+}
+
+// SetModuleLevels replaces all per-module overrides at once
+func SetModuleLevels(levels map[string]string) error {
+	parsed := make(map[string]Level, len(levels))
+	for module, level := range levels {
+		lv, ok := ParseLevel(level)
+		if !ok {
+			return fmt.Errorf("unknown log level %q for module %s", level, module)
+		}
+		parsed[module] = lv
+	}
+	globalMu.Lock()
+	moduleLevels = parsed
+	globalMu.Unlock()
+	return nil
+}
+
+// ModuleLevels returns a copy of the current per-module overrides
+func ModuleLevels() map[string]Level {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	out := make(map[string]Level, len(moduleLevels))
+	for k, v := range moduleLevels {
+		out[k] = v
+	}
+	return out
+}
+
+// SetOutputs replaces the active sinks. Previously configured sinks are closed.
+// With no outputs, logs go to stderr through the standard log package.
+func SetOutputs(outs ...Output) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	old := outputs
+	outputs = append([]Output(nil), outs...)
+	for _, o := range old {
+		_ = o.Sink.Close()
+	}
+}
+
 // Logger provides level-based logging
 type Logger struct {
 	prefix string
@@ -66,6 +157,9 @@ func New(prefix string) *Logger {
 func (l *Logger) shouldLog(level Level) bool {
 	globalMu.RLock()
 	defer globalMu.RUnlock()
+	if lv, ok := moduleLevels[l.prefix]; ok {
+		return level <= lv
+	}
 	return level <= globalLevel
 }
 
@@ -78,13 +172,32 @@ func (l *Logger) formatMessage(level string, format string, args ...interface{})
 	return fmt.Sprintf("[%s] %s", level, msg)
 }
 
+// write sends a formatted message to every sink whose level admits it.
+// Fatal messages use LevelQuiet so that every sink receives them.
+func write(level Level, msg string) {
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	if len(outputs) == 0 {
+		log.Printf("%s", msg)
+		return
+	}
+	now := time.Now()
+	for _, o := range outputs {
+		if level > o.Level {
+			continue
+		}
+		if err := o.Sink.Write(now, level, msg); err != nil {
+			fmt.Fprintf(os.Stderr, "logger: sink write failed: %v\n", err)
+		}
+	}
+}
+
 // Error logs error-level messages (always shown unless quiet)
 func (l *Logger) Error(format string, args ...interface{}) {
 	if !l.shouldLog(LevelError) {
 		return
 	}
-	msg := l.formatMessage("ERROR", format, args...)
-	log.Printf("%s", msg)
+	write(LevelError, l.formatMessage("ERROR", format, args...))
 }
 
 // Info logs info-level messages
@@ -92,8 +205,7 @@ func (l *Logger) Info(format string, args ...interface{}) {
 	if !l.shouldLog(LevelInfo) {
 		return
 	}
-	msg := l.formatMessage("INFO", format, args...)
-	log.Printf("%s", msg)
+	write(LevelInfo, l.formatMessage("INFO", format, args...))
 }
 
 // Debug logs debug-level messages
@@ -101,8 +213,7 @@ func (l *Logger) Debug(format string, args ...interface{}) {
 	if !l.shouldLog(LevelDebug) {
 		return
 	}
-	msg := l.formatMessage("DEBUG", format, args...)
-	log.Printf("%s", msg)
+	write(LevelDebug, l.formatMessage("DEBUG", format, args...))
 }
 
 // Trace logs trace-level messages (most verbose)
@@ -110,21 +221,19 @@ func (l *Logger) Trace(format string, args ...interface{}) {
 	if !l.shouldLog(LevelTrace) {
 		return
 	}
-	msg := l.formatMessage("TRACE", format, args...)
-	log.Printf("%s", msg)
+	write(LevelTrace, l.formatMessage("TRACE", format, args...))
 }
 
 // Fatal logs a fatal error and exits (always logged, even in quiet mode)
 func (l *Logger) Fatal(format string, args ...interface{}) {
-	msg := l.formatMessage("FATAL", format, args...)
-	log.Printf("%s", msg)
+	write(LevelQuiet, l.formatMessage("FATAL", format, args...))
+	SetOutputs() // flush and close sinks
 	os.Exit(1)
 }
 
 // Fatalf logs a fatal error with formatting and exits (always logged, even in quiet mode)
 func (l *Logger) Fatalf(format string, args ...interface{}) {
-	msg := l.formatMessage("FATAL", format, args...)
-	log.Fatalf("%s", msg)
+	l.Fatal(format, args...)
 }
 
 // Package-level convenience functions using default logger
@@ -164,4 +273,3 @@ func Fatal(format string, args ...interface{}) {
 func Fatalf(format string, args ...interface{}) {
 	defaultLogger.Fatalf(format, args...)
 }
-
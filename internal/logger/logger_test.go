@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memSink keeps written lines and whether it was closed
+type memSink struct {
+	mu     sync.Mutex
+	lines  []string
+	closed bool
+}
+
+func (s *memSink) Write(_ time.Time, _ Level, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, msg)
+	return nil
+}
+
+func (s *memSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memSink) state() ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...), s.closed
+}
+
+func TestSetOutputsClosesReplaced(t *testing.T) {
+	t.Cleanup(func() { SetOutputs() })
+	log := New("test")
+
+	a, b := &memSink{}, &memSink{}
+	SetOutputs(Output{Sink: a, Level: LevelInfo})
+	log.Info("to a")
+	SetOutputs(Output{Sink: b, Level: LevelError})
+	log.Info("filtered by b")
+	log.Error("to b")
+
+	if lines, closed := a.state(); !closed || len(lines) != 1 || !strings.Contains(lines[0], "to a") {
+		t.Errorf("replaced sink: lines %q, closed %v", lines, closed)
+	}
+	if lines, closed := b.state(); closed || len(lines) != 1 || !strings.Contains(lines[0], "to b") {
+		t.Errorf("active sink: lines %q, closed %v", lines, closed)
+	}
+
+	SetOutputs()
+	if _, closed := b.state(); !closed {
+		t.Error("sink still open after SetOutputs()")
+	}
+}
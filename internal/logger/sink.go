@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink is a log destination. Messages arrive already formatted
+// ("[LEVEL][prefix] msg") without a trailing newline.
+type Sink interface {
+	Write(t time.Time, level Level, msg string) error
+	Close() error
+}
+
+// Output pairs a sink with the most verbose level it accepts
+type Output struct {
+	Sink  Sink
+	Level Level
+}
+
+// streamSink writes timestamped lines to an io.Writer (stderr, stdout, ...)
+type streamSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink that writes lines to w in the standard log format.
+// w is not closed by the sink.
+func NewWriterSink(w io.Writer) Sink {
+	return &streamSink{w: w}
+}
+
+// NewStderrSink returns a sink writing to the process stderr
+func NewStderrSink() Sink {
+	return NewWriterSink(os.Stderr)
+}
+
+func (s *streamSink) Write(t time.Time, _ Level, msg string) error {
+	line := formatLine(t, msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, line)
+	return err
+}
+
+func (s *streamSink) Close() error {
+	return nil
+}
+
+// formatLine mirrors the standard log package prefix (log.LstdFlags)
+func formatLine(t time.Time, msg string) string {
+	return t.Format("2006/01/02 15:04:05 ") + msg + "\n"
+}
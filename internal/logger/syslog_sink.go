@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// default local syslog sockets, checked in order
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const syslogFacilityDaemon = 3
+
+// syslogSink sends RFC 3164 messages to the local syslog daemon over a unix socket
+type syslogSink struct {
+	mu   sync.Mutex
+	addr string
+	tag  string
+	conn net.Conn
+}
+
+// NewSyslogSink connects to a local syslog socket. An empty addr probes the
+// usual locations; an empty tag uses the executable name.
+func NewSyslogSink(addr, tag string) (Sink, error) {
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	s := &syslogSink{addr: addr, tag: tag}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	addrs := syslogSockets
+	if s.addr != "" {
+		addrs = []string{s.addr}
+	}
+	var lastErr error
+	for _, a := range addrs {
+		for _, network := range []string{"unixgram", "unix"} {
+			c, err := net.Dial(network, a)
+			if err == nil {
+				s.addr = a
+				s.conn = c
+				return nil
+			}
+			lastErr = err
+		}
+	}
+	return fmt.Errorf("syslog: %v", lastErr)
+}
+
+// severity maps a logger level to a syslog severity
+func severity(level Level) int {
+	switch level {
+	case LevelQuiet: // fatal
+		return 2 // crit
+	case LevelError:
+		return 3 // err
+	case LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+func (s *syslogSink) Write(t time.Time, level Level, msg string) error {
+	pri := syslogFacilityDaemon*8 + severity(level)
+	line := fmt.Sprintf("<%d>%s %s[%d]: %s", pri, t.Format(time.Stamp), s.tag, os.Getpid(), msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	// syslogd restarted: reconnect once
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,396 @@
+// Package policy decides which targets clients may tunnel to. A policy is
+// an ordered list of allow and deny rules over host names, IPs and CIDRs
+// with optional port sets; the first matching rule wins. Host names are
+// resolved once and every resolved address is checked, so callers dial
+// exactly the addresses the policy approved.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+// Action is what a matching rule does
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// ErrDenied is wrapped by every refusal, as opposed to resolution failures
+var ErrDenied = errors.New("target not allowed")
+
+// DefaultDenyRanges are refused unless an IP or CIDR rule inside the range
+// allows them: a host-name or wildcard rule never reaches loopback,
+// link-local (cloud metadata) or other well-known metadata addresses.
+var DefaultDenyRanges = []string{
+	"0.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"100.100.100.200/32", // Alibaba Cloud metadata
+	"168.63.129.16/32",   // Azure wire server
+	"::/128",
+	"::1/128",
+	"fe80::/10",
+	"fd00:ec2::254/128", // AWS IPv6 metadata
+}
+
+// Entry is one configured rule before parsing
+type Entry struct {
+	Action Action
+	Target string // host[:ports], see ParseRule
+}
+
+// Policy is a compiled, ordered rule list. It is safe for concurrent use.
+type Policy struct {
+	rules      []*Rule
+	denyRanges []netip.Prefix
+	hasIPRules bool
+
+	// Resolve looks up host names (default net.DefaultResolver)
+	Resolve func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// New compiles entries in order. denyRanges nil selects DefaultDenyRanges.
+func New(entries []Entry, denyRanges []string) (*Policy, error) {
+	p := &Policy{Resolve: lookup}
+	for i, e := range entries {
+		r, err := ParseRule(e.Action, e.Target)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		r.Index = i + 1
+		p.rules = append(p.rules, r)
+		p.hasIPRules = p.hasIPRules || r.prefix.IsValid()
+	}
+
+	if denyRanges == nil {
+		denyRanges = DefaultDenyRanges
+	}
+	for _, s := range denyRanges {
+		pfx, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("deny_ranges: %w", err)
+		}
+		p.denyRanges = append(p.denyRanges, pfx)
+	}
+	return p, nil
+}
+
+// FromConfig compiles target_policy followed by allowed_targets (as allow
+// rules) and deny_ranges
+func FromConfig(cfg *config.Config) (*Policy, error) {
+	var entries []Entry
+	for i, e := range cfg.TargetPolicy {
+		switch {
+		case e.Allow != "" && e.Deny == "":
+			entries = append(entries, Entry{Allow, e.Allow})
+		case e.Deny != "" && e.Allow == "":
+			entries = append(entries, Entry{Deny, e.Deny})
+		default:
+			return nil, fmt.Errorf("target_policy entry %d: set exactly one of allow or deny", i+1)
+		}
+	}
+	for _, t := range cfg.AllowedTargets {
+		entries = append(entries, Entry{Allow, t})
+	}
+	return New(entries, cfg.DenyRanges)
+}
+
+// Rules returns the compiled rules in evaluation order
+func (p *Policy) Rules() []*Rule { return p.rules }
+
+// DenyRanges returns the ranges only IP rules inside them can allow
+func (p *Policy) DenyRanges() []netip.Prefix { return p.denyRanges }
+
+// Verdict is the outcome for one resolved address
+type Verdict struct {
+	Addr      string // ip:port that would be dialed
+	Allowed   bool
+	Rule      *Rule        // deciding rule; nil when none matched
+	DenyRange netip.Prefix // set when the deny list refused an allowed address
+	Reason    string       // human-readable explanation
+}
+
+// Decision is the outcome for a target across all its addresses
+type Decision struct {
+	Target   string
+	Allowed  bool // every address allowed
+	Verdicts []Verdict
+}
+
+// Addrs returns the ip:port addresses to dial when the decision allows
+func (d Decision) Addrs() []string {
+	if !d.Allowed {
+		return nil
+	}
+	out := make([]string, len(d.Verdicts))
+	for i, v := range d.Verdicts {
+		out[i] = v.Addr
+	}
+	return out
+}
+
+// Err is nil when allowed, otherwise ErrDenied with the first refusal
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	for _, v := range d.Verdicts {
+		if !v.Allowed {
+			return fmt.Errorf("%w: %s: %s", ErrDenied, v.Addr, v.Reason)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDenied, d.Target)
+}
+
+// Check evaluates target ("host:port"). Host names are resolved once and
+// each address is checked on its own; the target is allowed only if all
+// are. The error is non-nil only when resolution fails.
+func (p *Policy) Check(ctx context.Context, target string) (Decision, error) {
+	d := Decision{Target: target}
+	host, portStr, err := net.SplitHostPort(target)
+	port, perr := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || host == "" || perr != nil || port == 0 {
+		d.Verdicts = []Verdict{{Addr: target, Reason: "not a host:port target"}}
+		return d, nil
+	}
+	name := normalizeName(host)
+
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else {
+		// without IP rules the name alone can refuse; skip the DNS query
+		if !p.hasIPRules && len(p.rules) > 0 {
+			if r := p.match(name, netip.Addr{}, uint16(port)); r == nil || r.Action == Deny {
+				d.Verdicts = []Verdict{p.refuse(target, r)}
+				return d, nil
+			}
+		}
+		if ips, err = p.Resolve(ctx, host); err != nil {
+			return d, fmt.Errorf("resolve %s: %w", host, err)
+		}
+	}
+
+	d.Allowed = len(ips) > 0
+	for _, ip := range ips {
+		// prefixes never contain zoned addresses: "[::1%lo]" must hit ::1/128,
+		// and the verdict's Addr, which callers dial, carries no zone either
+		v := p.verdict(name, ip.WithZone("").Unmap(), uint16(port))
+		d.Verdicts = append(d.Verdicts, v)
+		d.Allowed = d.Allowed && v.Allowed
+	}
+	return d, nil
+}
+
+// verdict applies the first matching rule and the deny list to one address
+func (p *Policy) verdict(name string, ip netip.Addr, port uint16) Verdict {
+	addr := netip.AddrPortFrom(ip, port).String()
+	r := p.match(name, ip, port)
+	if (r == nil && len(p.rules) > 0) || (r != nil && r.Action == Deny) {
+		return p.refuse(addr, r)
+	}
+
+	v := Verdict{Addr: addr, Allowed: true, Rule: r, Reason: "no rules configured (allow all)"}
+	if r != nil {
+		v.Reason = "allowed by " + r.String()
+	}
+	for _, dr := range p.denyRanges {
+		if !dr.Contains(ip) {
+			continue
+		}
+		if r != nil && r.prefix.IsValid() && r.prefix.Bits() >= dr.Bits() && dr.Contains(r.prefix.Addr()) {
+			v.Reason += " (inside deny range " + dr.String() + ")"
+			break
+		}
+		v.Allowed = false
+		v.DenyRange = dr
+		v.Reason = "in deny range " + dr.String() + "; only an IP or CIDR rule inside it can allow it"
+		if r != nil {
+			v.Reason = r.String() + " matched, but " + ip.String() + " is " + v.Reason
+		}
+		break
+	}
+	return v
+}
+
+func (p *Policy) refuse(addr string, r *Rule) Verdict {
+	if r == nil {
+		return Verdict{Addr: addr, Reason: "no rule matched (default deny)"}
+	}
+	return Verdict{Addr: addr, Rule: r, Reason: "denied by " + r.String()}
+}
+
+// match returns the first rule matching name or ip on port
+func (p *Policy) match(name string, ip netip.Addr, port uint16) *Rule {
+	for _, r := range p.rules {
+		if r.matches(name, ip, port) {
+			return r
+		}
+	}
+	return nil
+}
+
+func lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// Rule is one compiled policy entry
+type Rule struct {
+	Index  int // 1-based position in the policy
+	Action Action
+	Source string // as written
+
+	prefix netip.Prefix   // IP and CIDR rules
+	name   *regexp.Regexp // host-name rules
+	ports  []portRange    // nil = any port
+}
+
+type portRange struct{ lo, hi uint16 }
+
+// ParseRule parses "host[:ports]". host is a name with optional * and ?
+// wildcards, an IPv4 or IPv6 address or CIDR (IPv6 with ports in
+// brackets: "[2001:db8::/32]:443"); ports is "*", or a comma list of
+// ports and lo-hi ranges. No ports means any port.
+func ParseRule(action Action, text string) (*Rule, error) {
+	if action != Allow && action != Deny {
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+	r := &Rule{Action: action, Source: text}
+	host, ports, err := splitRule(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", text, err)
+	}
+	if r.ports, err = parsePorts(ports); err != nil {
+		return nil, fmt.Errorf("%q: %w", text, err)
+	}
+
+	if pfx, err := parsePrefix(host); err == nil {
+		r.prefix = pfx
+		return r, nil
+	} else if strings.Contains(host, "/") || strings.Contains(host, ":") {
+		return nil, fmt.Errorf("%q: %w", text, err)
+	}
+	name := normalizeName(host)
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789.-_*?") != "" {
+		return nil, fmt.Errorf("%q: invalid host pattern", text)
+	}
+	pattern := regexp.QuoteMeta(name)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	r.name = regexp.MustCompile("^" + pattern + "$")
+	return r, nil
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("rule %d: %s %q", r.Index, r.Action, r.Source)
+}
+
+// matches reports whether the rule covers name (or ip) on port. Name
+// rules also match the literal text of IP targets, so "*" matches all.
+func (r *Rule) matches(name string, ip netip.Addr, port uint16) bool {
+	if r.ports != nil && !inPorts(r.ports, port) {
+		return false
+	}
+	if r.prefix.IsValid() {
+		return ip.IsValid() && r.prefix.Contains(ip)
+	}
+	return r.name.MatchString(name)
+}
+
+func inPorts(ranges []portRange, port uint16) bool {
+	for _, pr := range ranges {
+		if port >= pr.lo && port <= pr.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRule separates the host pattern from the port list
+func splitRule(s string) (host, ports string, err error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return "", "", errors.New("missing ]")
+		}
+		host, rest := s[1:end], s[end+1:]
+		if rest == "" {
+			return host, "", nil
+		}
+		if rest[0] != ':' {
+			return "", "", errors.New("expected :ports after ]")
+		}
+		return host, rest[1:], nil
+	case strings.Count(s, ":") > 1: // bare IPv6, any port
+		return s, "", nil
+	}
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		return s[:i], s[i+1:], nil
+	}
+	return s, "", nil
+}
+
+func parsePorts(s string) ([]portRange, error) {
+	if s == "" || s == "*" {
+		return nil, nil
+	}
+	var out []portRange
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		a, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		b := a
+		if isRange {
+			if b, err = parsePort(hi); err != nil {
+				return nil, err
+			}
+			if b < a {
+				return nil, fmt.Errorf("port range %s is reversed", part)
+			}
+		}
+		out = append(out, portRange{a, b})
+	}
+	return out, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(n), nil
+}
+
+// parsePrefix accepts a CIDR or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if pfx, err := netip.ParsePrefix(s); err == nil {
+		if pfx.Addr().Is4In6() {
+			pfx = netip.PrefixFrom(pfx.Addr().Unmap(), max(pfx.Bits()-96, 0))
+		}
+		return pfx.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address or CIDR %q", s)
+	}
+	a = a.WithZone("").Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+func normalizeName(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func allow(target string) Entry { return Entry{Action: Allow, Target: target} }
+func deny(target string) Entry  { return Entry{Action: Deny, Target: target} }
+
+// testHosts is the fake DNS used by expectPolicy
+var testHosts = map[string][]netip.Addr{
+	"db-eu.corp":    {netip.MustParseAddr("10.1.2.3")},
+	"metadata.corp": {netip.MustParseAddr("169.254.169.254")},
+	"zoned.corp":    {netip.MustParseAddr("fe80::1%eth0")},
+}
+
+// expectPolicy checks each target against entries and deny ranges (nil =
+// default), resolving names from testHosts
+func expectPolicy(t *testing.T, denyRanges []string, entries []Entry, want map[string]bool) {
+	t.Helper()
+	p, err := New(entries, denyRanges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Resolve = func(_ context.Context, host string) ([]netip.Addr, error) {
+		if ips, ok := testHosts[host]; ok {
+			return ips, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	for target, ok := range want {
+		d, err := p.Check(context.Background(), target)
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if err := d.Err(); err != nil && !errors.Is(err, ErrDenied) {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if d.Allowed != ok {
+			t.Errorf("%s: allowed=%v, want %v (%v)", target, d.Allowed, ok, d.Err())
+		}
+	}
+}
+
+func TestFirstMatch(t *testing.T) {
+	expectPolicy(t, nil, []Entry{
+		deny("10.0.5.0/24"),
+		allow("10.0.0.0/8:5432,6379"),
+		allow("db-*.corp:1000-2000"),
+		allow("[2001:db8::/32]:443"),
+		deny("*"),
+	}, map[string]bool{
+		"10.1.2.3:5432":        true,
+		"10.1.2.3:6379":        true,
+		"10.1.2.3:22":          false,
+		"10.0.5.9:5432":        false, // denied before the /8 allow
+		"db-eu.corp:1500":      true,  // resolves to 10.1.2.3
+		"db-eu.corp:2001":      false,
+		"[2001:db8::7]:443":    true,
+		"[2001:db8::7]:80":     false,
+		"[2001:db9::7]:443":    false,
+		"[::ffff:10.1.2.3]:22": false,
+	})
+}
+
+func TestDefaultDenyList(t *testing.T) {
+	expectPolicy(t, nil, []Entry{
+		allow("127.0.0.1:22"), // exact rule inside 127.0.0.0/8 lifts the deny list
+		allow("0.0.0.0/0"),    // ... a wider one does not
+		allow("*"),
+	}, map[string]bool{
+		"127.0.0.1:22":                true,
+		"127.0.0.1:23":                false,
+		"127.0.0.2:80":                false,
+		"169.254.169.254:80":          false,
+		"[::ffff:169.254.169.254]:80": false,
+		"100.100.100.200:80":          false,
+		"[::1]:22":                    false,
+		"[fe80::1]:22":                false,
+		"metadata.corp:80":            false, // resolves to 169.254.169.254
+	})
+}
+
+func TestDenyListOverride(t *testing.T) {
+	expectPolicy(t, []string{"10.9.0.0/16"}, []Entry{allow("*")}, map[string]bool{
+		"169.254.169.254:80": true,
+		"127.0.0.1:80":       true,
+		"10.9.8.7:80":        false,
+	})
+}
+
+// TestZonedAddrs checks that an IPv6 zone does not take an address out of
+// the deny ranges or out of IP rules
+func TestZonedAddrs(t *testing.T) {
+	zoned := map[string]bool{
+		"[::1%lo]:22":       false,
+		"[fe80::1%eth0]:80": false,
+		"[fe80::1%25]:80":   false,
+		"zoned.corp:80":     false, // resolves to fe80::1%eth0
+	}
+	expectPolicy(t, nil, nil, zoned)
+	expectPolicy(t, nil, []Entry{allow("*")}, zoned)
+	expectPolicy(t, nil, []Entry{deny("::1"), allow("*")}, map[string]bool{
+		"[::1%lo]:22": false,
+	})
+	expectPolicy(t, nil, []Entry{allow("[::1]:22")}, map[string]bool{
+		"[::1%lo]:22": true,
+	})
+
+	p, err := New([]Entry{allow("[::1]:22")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := p.Check(context.Background(), "[::1%lo]:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs := d.Addrs(); len(addrs) != 1 || addrs[0] != "[::1]:22" {
+		t.Fatalf("dial addrs %v, want [[::1]:22]", addrs)
+	}
+}
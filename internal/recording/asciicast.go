@@ -0,0 +1,64 @@
+package recording
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+)
+
+// castHeader is the first line of an asciicast v2 file; players ignore the
+// anylink key
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title"`
+	Anylink   Meta   `json:"anylink"`
+}
+
+// castEncoder writes asciicast v2: a JSON header line, then one
+// [seconds, type, data] line per event. Event data must be a string, so a
+// UTF-8 sequence split across reads is held back until the rest arrives.
+type castEncoder struct {
+	cols, rows int
+	pending    [2][]byte // partial sequences, client → backend and backend → client
+}
+
+func (e *castEncoder) header(m Meta) []byte {
+	b, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     e.cols,
+		Height:    e.rows,
+		Timestamp: m.Start.Unix(),
+		Title:     m.Target,
+		Anylink:   m,
+	})
+	return append(b, '\n')
+}
+
+func (e *castEncoder) event(t time.Duration, typ byte, data []byte) []byte {
+	if typ != 'm' {
+		dir := 0
+		if typ == 'o' {
+			dir = 1
+		}
+		data = append(e.pending[dir], data...)
+		cut := len(data)
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+		e.pending[dir] = append([]byte(nil), data[cut:]...)
+		data = data[:cut]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal([]any{t.Seconds(), string(typ), string(data)})
+	return append(b, '\n')
+}
@@ -0,0 +1,35 @@
+package recording
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+// rawMagic starts a raw recording. It is followed by a 4-byte big-endian
+// length and the JSON Meta, then by records of
+//
+//	[8B microseconds since start][1B type][4B length][data]
+//
+// all big-endian, with the types of Event.
+const rawMagic = "ANYLREC1"
+
+type rawEncoder struct{}
+
+func (rawEncoder) header(m Meta) []byte {
+	meta, _ := json.Marshal(m)
+	b := append([]byte(rawMagic), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(rawMagic):], uint32(len(meta)))
+	return append(b, meta...)
+}
+
+func (rawEncoder) event(t time.Duration, typ byte, data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	b := make([]byte, 13, 13+len(data))
+	binary.BigEndian.PutUint64(b, uint64(t.Microseconds()))
+	b[8] = typ
+	binary.BigEndian.PutUint32(b[9:], uint32(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,147 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Reader reads the events of a recording in either format
+type Reader struct {
+	Format Format
+	Meta   Meta
+
+	f  *os.File
+	br *bufio.Reader
+}
+
+// Open opens a recording, telling the format from its first bytes
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{f: f, br: bufio.NewReader(f)}
+	if err := r.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+func (r *Reader) readHeader() error {
+	magic, err := r.br.Peek(len(rawMagic))
+	if err == nil && string(magic) == rawMagic {
+		r.Format = Raw
+		var hdr [len(rawMagic) + 4]byte
+		if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+			return err
+		}
+		meta := make([]byte, binary.BigEndian.Uint32(hdr[len(rawMagic):]))
+		if _, err := io.ReadFull(r.br, meta); err != nil {
+			return err
+		}
+		return json.Unmarshal(meta, &r.Meta)
+	}
+	r.Format = Asciicast
+	line, err := r.br.ReadBytes('\n')
+	if err != nil {
+		return errors.New("not a recording")
+	}
+	var h castHeader
+	if err := json.Unmarshal(line, &h); err != nil || h.Version != 2 {
+		return errors.New("not a recording")
+	}
+	r.Meta = h.Anylink
+	if r.Meta.Start.IsZero() {
+		r.Meta.Start = time.Unix(h.Timestamp, 0)
+	}
+	return nil
+}
+
+// Next returns the next event, or io.EOF after the last one
+func (r *Reader) Next() (Event, error) {
+	if r.Format == Raw {
+		var hdr [13]byte
+		if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF // cut off mid-record
+			}
+			return Event{}, err
+		}
+		ev := Event{
+			Time: time.Duration(binary.BigEndian.Uint64(hdr[:8])) * time.Microsecond,
+			Type: hdr[8],
+			Data: make([]byte, binary.BigEndian.Uint32(hdr[9:])),
+		}
+		if _, err := io.ReadFull(r.br, ev.Data); err != nil {
+			return Event{}, io.EOF
+		}
+		return ev, nil
+	}
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Event{}, err
+		}
+		var fields []any
+		if json.Unmarshal(line, &fields) != nil || len(fields) != 3 {
+			if err != nil {
+				return Event{}, io.EOF // cut off mid-line
+			}
+			continue
+		}
+		t, _ := fields[0].(float64)
+		typ, _ := fields[1].(string)
+		data, _ := fields[2].(string)
+		if typ == "" {
+			continue
+		}
+		return Event{Time: time.Duration(t * float64(time.Second)), Type: typ[0], Data: []byte(data)}, nil
+	}
+}
+
+// Close closes the recording file
+func (r *Reader) Close() error { return r.f.Close() }
+
+// PlayOptions control Play
+type PlayOptions struct {
+	Speed   float64       // playback speed; 0 is 1
+	MaxIdle time.Duration // cap on pauses between events; 0 keeps them
+	Input   bool          // also write client → backend data
+}
+
+// Play writes the recording's output to w, pausing between events as
+// recorded
+func Play(w io.Writer, r *Reader, opts PlayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	var last time.Duration
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ev.Type != 'o' && !(opts.Input && ev.Type == 'i') {
+			continue
+		}
+		wait := time.Duration(float64(ev.Time-last) / opts.Speed)
+		if opts.MaxIdle > 0 && wait > opts.MaxIdle {
+			wait = opts.MaxIdle
+		}
+		last = ev.Time
+		time.Sleep(wait)
+		if _, err := w.Write(ev.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Package recording captures tunnel data in both directions with
+// timestamps, for audit and replay. Terminal sessions are written as
+// asciicast v2, which asciinema can play; anything else in a raw format of
+// length-prefixed records. A Store keeps recordings in one directory
+// within size limits.
+package recording
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/logger"
+)
+
+// Format is how a recording is encoded
+type Format string
+
+const (
+	Asciicast Format = "asciicast" // asciicast v2 (.cast)
+	Raw       Format = "raw"       // length-prefixed records (.rec)
+)
+
+const (
+	defaultMaxSize  = 64 << 20
+	defaultMaxTotal = 1 << 30
+	defaultCols     = 80
+	defaultRows     = 24
+)
+
+// ParseFormat validates a configured format name
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case Asciicast, Raw:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown recording format %q (want asciicast or raw)", s)
+}
+
+func (f Format) ext() string {
+	if f == Asciicast {
+		return ".cast"
+	}
+	return ".rec"
+}
+
+// Meta describes the recorded tunnel
+type Meta struct {
+	Target    string    `json:"target"`
+	Transport string    `json:"transport"`
+	Client    string    `json:"client"`
+	Start     time.Time `json:"start"`
+}
+
+// Event is one chunk of tunnel data
+type Event struct {
+	Time time.Duration // since the recording started
+	Type byte          // 'o' backend → client, 'i' client → backend, 'm' marker
+	Data []byte
+}
+
+// Options configure a Store; zero values select the defaults
+type Options struct {
+	MaxSize  int64 // bytes of data per recording (default 64 MiB)
+	MaxTotal int64 // bytes in the directory before the oldest are deleted (default 1 GiB)
+	Cols     int   // asciicast terminal size (default 80x24)
+	Rows     int
+}
+
+// Store creates recordings in a directory. Before each new recording the
+// least recently written finished ones are deleted until the directory
+// fits MaxTotal.
+type Store struct {
+	dir  string
+	opts Options
+	log  *logger.Logger
+
+	mu   sync.Mutex
+	open map[string]bool // paths being written
+}
+
+// NewStore creates dir if needed; recordings are readable by the owner only
+func NewStore(dir string, opts Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	if opts.MaxTotal <= 0 {
+		opts.MaxTotal = defaultMaxTotal
+	}
+	if opts.Cols <= 0 || opts.Rows <= 0 {
+		opts.Cols, opts.Rows = defaultCols, defaultRows
+	}
+	return &Store{dir: dir, opts: opts, log: logger.New("recording"), open: make(map[string]bool)}, nil
+}
+
+// Open starts a recording of the tunnel described by meta
+func (s *Store) Open(format Format, meta Meta) (*Recorder, error) {
+	if meta.Start.IsZero() {
+		meta.Start = time.Now()
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := fmt.Sprintf("%s-%s-%s%s", meta.Start.UTC().Format("20060102T150405Z"),
+		safeName(meta.Target), hex.EncodeToString(suffix), format.ext())
+	path := filepath.Join(s.dir, name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	var enc encoder
+	if format == Asciicast {
+		enc = &castEncoder{cols: s.opts.Cols, rows: s.opts.Rows}
+	} else {
+		enc = &rawEncoder{}
+	}
+	r := &Recorder{store: s, path: path, f: f, enc: enc, start: meta.Start, max: s.opts.MaxSize}
+	if _, err := f.Write(enc.header(meta)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	s.open[path] = true
+	return r, nil
+}
+
+// prune deletes the oldest finished recordings until the directory fits
+// MaxTotal; callers hold mu
+func (s *Store) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	type file struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []file
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || (!strings.HasSuffix(e.Name(), ".cast") && !strings.HasSuffix(e.Name(), ".rec")) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{filepath.Join(s.dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	// least recently written first
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	for _, f := range files {
+		if total <= s.opts.MaxTotal {
+			return
+		}
+		if s.open[f.path] {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			s.log.Error("pruning %s: %v", f.path, err)
+			continue
+		}
+		s.log.Debug("pruned %s", f.path)
+		total -= f.size
+	}
+}
+
+func (s *Store) closed(path string) {
+	s.mu.Lock()
+	delete(s.open, path)
+	s.mu.Unlock()
+}
+
+// safeName turns a target into a file name part
+func safeName(target string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, target)
+}
+
+// encoder writes one recording format
+type encoder interface {
+	header(Meta) []byte
+	// event encodes data sent at t; it may hold back a partial UTF-8
+	// sequence until the next call
+	event(t time.Duration, typ byte, data []byte) []byte
+}
+
+// Recorder writes one tunnel's events. Record is safe for concurrent use
+// by both copy directions; each event is written straight through, so a
+// crash loses at most the event being written.
+type Recorder struct {
+	store *Store
+	path  string
+	start time.Time
+	max   int64
+
+	mu        sync.Mutex
+	f         *os.File
+	enc       encoder
+	written   int64
+	truncated bool
+}
+
+// Path is the recording's file
+func (r *Recorder) Path() string { return r.path }
+
+// Record appends data; toClient is backend output. Data beyond MaxSize is
+// dropped after a marker event.
+func (r *Recorder) Record(toClient bool, data []byte) {
+	typ := byte('i')
+	if toClient {
+		typ = 'o'
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil || r.truncated {
+		return
+	}
+	t := time.Since(r.start)
+	if r.written+int64(len(data)) > r.max {
+		data = data[:r.max-r.written]
+		r.truncated = true
+	}
+	r.write(r.enc.event(t, typ, data))
+	r.written += int64(len(data))
+	if r.truncated {
+		r.write(r.enc.event(t, 'm', []byte("recording truncated at max_size")))
+		r.store.log.Info("recording %s reached max_size; the rest of the session is not recorded", r.path)
+	}
+}
+
+// write appends an encoded event; callers hold mu
+func (r *Recorder) write(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	if _, err := r.f.Write(b); err != nil {
+		r.store.log.Error("recording %s: %v", r.path, err)
+		r.truncated = true
+	}
+}
+
+// Close finishes the recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	r.store.closed(r.path)
+	return err
+}
@@ -0,0 +1,155 @@
+package recording
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	s, err := NewStore(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// events reads a finished recording back
+func events(t *testing.T, path string) (*Reader, []Event) {
+	t.Helper()
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	var evs []Event
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			return r, evs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	s := newTestStore(t, Options{})
+	for _, format := range []Format{Asciicast, Raw} {
+		t.Run(string(format), func(t *testing.T) {
+			meta := Meta{Target: "db:5432", Transport: "ws", Client: "10.0.0.7:41000"}
+			rec, err := s.Open(format, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec.Record(false, []byte("select 1;"))
+			rec.Record(true, []byte("1 ✓\r\n"))
+			rec.Close()
+			if fi, err := os.Stat(rec.Path()); err != nil || fi.Mode().Perm() != 0o600 {
+				t.Fatalf("recording file: %v, %v", fi, err)
+			}
+
+			r, evs := events(t, rec.Path())
+			if r.Format != format || r.Meta.Target != meta.Target || r.Meta.Transport != meta.Transport || r.Meta.Client != meta.Client {
+				t.Errorf("header: %s %+v", r.Format, r.Meta)
+			}
+			if len(evs) != 2 || evs[0].Type != 'i' || string(evs[0].Data) != "select 1;" ||
+				evs[1].Type != 'o' || string(evs[1].Data) != "1 ✓\r\n" {
+				t.Errorf("events %q", evs)
+			}
+		})
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	s := newTestStore(t, Options{MaxSize: 10})
+	rec, err := s.Open(Raw, Meta{Target: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(false, []byte("123456"))
+	rec.Record(true, []byte("abcdef"))
+	rec.Record(true, []byte("dropped"))
+	rec.Close()
+
+	_, evs := events(t, rec.Path())
+	if len(evs) != 3 || string(evs[0].Data) != "123456" || string(evs[1].Data) != "abcd" || evs[2].Type != 'm' {
+		t.Errorf("events %q, want the first 10 bytes and a marker", evs)
+	}
+}
+
+func TestStoreMaxTotal(t *testing.T) {
+	s := newTestStore(t, Options{MaxTotal: 1000})
+	var recs []*Recorder
+	for i := 0; i < 4; i++ {
+		r, err := s.Open(Raw, Meta{Target: fmt.Sprintf("t%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Record(true, bytes.Repeat([]byte{'x'}, 600))
+		recs = append(recs, r)
+		if i != 2 {
+			r.Close() // the third stays open while the fourth starts
+		}
+		time.Sleep(10 * time.Millisecond) // distinct modification times
+	}
+	recs[2].Close()
+
+	// the oldest finished recordings go; the open one is kept
+	for i, want := range []bool{false, false, true, true} {
+		_, err := os.Stat(recs[i].Path())
+		if got := err == nil; got != want {
+			t.Errorf("recording %d kept %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	s := newTestStore(t, Options{})
+	for _, format := range []Format{Asciicast, Raw} {
+		rec, err := s.Open(format, Meta{Target: "replay"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Record(true, []byte("$ "))
+		time.Sleep(20 * time.Millisecond)
+		rec.Record(false, []byte("id\r"))
+		rec.Record(true, []byte("id\r\nuid=0\r\n"))
+		rec.Close()
+
+		for _, input := range []bool{false, true} {
+			want := "$ id\r\nuid=0\r\n"
+			if input {
+				want = "$ id\rid\r\nuid=0\r\n"
+			}
+			r, err := Open(rec.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			err = Play(&out, r, PlayOptions{Speed: 10, Input: input})
+			r.Close()
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if out.String() != want {
+				t.Errorf("%s replay (input %v) wrote %q, want %q", format, input, out.String(), want)
+			}
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("asciicast"); err != nil || f != Asciicast {
+		t.Errorf("asciicast: %v, %v", f, err)
+	}
+	if _, err := ParseFormat("mp4"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/logger"
+)
+
+// startAdmin serves the admin API on cfg.AdminAddr. It exposes backend
+// addresses and tunnel plaintext and must not be reachable by tunnel
+// clients: a listener that is not on loopback needs admin_token, and the
+// token, when set, guards every endpoint.
+func (s *Server) startAdmin() error {
+	if s.cfg.AdminAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.cfg.AdminAddr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.AdminToken == "" && !isLoopback(ln.Addr()) {
+		ln.Close()
+		return fmt.Errorf("admin_addr %s is not a loopback address; set admin_token to expose the admin API", s.cfg.AdminAddr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /backends", s.adminAuth(s.handleBackends))
+	mux.HandleFunc("GET /pool", s.adminAuth(s.handlePool))
+	mux.HandleFunc("GET /udp", s.adminAuth(s.handleUDP))
+	mux.HandleFunc("GET /tunnels", s.adminAuth(s.handleTunnels))
+	mux.HandleFunc("GET /captures", s.adminAuth(s.handleCaptures))
+	mux.HandleFunc("POST /captures", s.adminAuth(s.handleStartCapture))
+	mux.HandleFunc("GET /captures/{id}", s.adminAuth(s.handleCaptureFile))
+	mux.HandleFunc("DELETE /captures/{id}", s.adminAuth(s.handleStopCapture))
+	mux.HandleFunc("GET /logging/levels", s.adminAuth(s.handleLogLevels))
+	mux.HandleFunc("PUT /logging/levels", s.adminAuth(s.handleSetLogLevel))
+
+	s.adminLn = ln
+	s.admin = &http.Server{Handler: mux}
+	go func() {
+		if err := s.admin.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.log.Error("admin API error: %v", err)
+		}
+	}()
+	s.log.Info("🔧 Admin API on %s", ln.Addr())
+	return nil
+}
+
+// isLoopback reports whether a bound address only accepts local clients
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// adminAuth requires the admin_token bearer token, if one is configured
+func (s *Server) adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminToken == "" {
+			h(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="anylink-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleBackends reports pool backend health
+func (s *Server) handleBackends(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tcpPool.Status())
+}
+
+// handlePool reports connection pool counters per backend
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tcpPool.Stats())
+}
+
+// handleUDP lists open UDP associations
+func (s *Server) handleUDP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.udp.Flows())
+}
+
+// handleTunnels lists the bridged tunnels and their IDs
+func (s *Server) handleTunnels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Tunnels())
+}
+
+// handleCaptures lists captures, running and finished
+func (s *Server) handleCaptures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.captures.list())
+}
+
+// handleStartCapture starts a pcapng capture of the tunnels matching
+// ?target=, ?client= (IP or CIDR) and ?conn= for ?duration=
+func (s *Server) handleStartCapture(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := parseCaptureFilter(q.Get("target"), q.Get("client"), q.Get("conn"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var d time.Duration
+	if v := q.Get("duration"); v != "" {
+		if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+			http.Error(w, "duration: want a positive Go duration such as 30s", http.StatusBadRequest)
+			return
+		}
+	}
+	c, err := s.captures.start(f, d)
+	if errors.Is(err, errCaptureDuration) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.log.Error("starting capture: %v", err)
+		http.Error(w, "cannot create capture file", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c.status())
+}
+
+// handleCaptureFile serves a capture's pcapng file, which grows while the
+// capture runs
+func (s *Server) handleCaptureFile(w http.ResponseWriter, r *http.Request) {
+	c := s.captureByID(w, r)
+	if c == nil {
+		return
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		http.Error(w, "capture file gone", http.StatusGone)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(c.path)+`"`)
+	_, _ = io.Copy(w, f)
+}
+
+// handleStopCapture ends a capture before its duration runs out
+func (s *Server) handleStopCapture(w http.ResponseWriter, r *http.Request) {
+	c := s.captureByID(w, r)
+	if c == nil {
+		return
+	}
+	c.stop("admin")
+	writeJSON(w, http.StatusOK, c.status())
+}
+
+func (s *Server) captureByID(w http.ResponseWriter, r *http.Request) *captureSession {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	c := s.captures.get(id)
+	if err != nil || c == nil {
+		http.Error(w, "no such capture", http.StatusNotFound)
+		return nil
+	}
+	return c
+}
+
+// logLevels is the body of /logging/levels
+type logLevels struct {
+	Level   logger.Level            `json:"level"`
+	Modules map[string]logger.Level `json:"modules"`
+}
+
+// handleLogLevels reports the global level and the per-module overrides
+func (s *Server) handleLogLevels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevels{Level: logger.GetLevel(), Modules: logger.ModuleLevels()})
+}
+
+// handleSetLogLevel sets ?level= for ?module=, or the global level without
+// a module; an empty level drops a module's override. A SIGHUP reload
+// puts the configured levels back.
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	module, level := q.Get("module"), q.Get("level")
+	if module != "" {
+		if err := logger.SetModuleLevel(module, level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.log.Info("🔧 Log level of %s set to %q through the admin API", module, level)
+	} else {
+		if _, ok := logger.ParseLevel(level); !ok {
+			http.Error(w, "level: want quiet, error, info, debug or trace", http.StatusBadRequest)
+			return
+		}
+		logger.SetLevel(level)
+		s.log.Info("🔧 Global log level set to %q through the admin API", level)
+	}
+	s.handleLogLevels(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
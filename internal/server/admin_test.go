@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+const testAdminToken = "admin-secret"
+
+func TestAdminRefusesPublicAddrWithoutToken(t *testing.T) {
+	env := newTestEnv(t)
+	srv, err := startSelfTestServer(env, false, func(cfg *config.Config) {
+		cfg.AdminAddr = "0.0.0.0:0"
+	})
+	if err == nil {
+		srv.Shutdown(context.Background())
+		t.Fatal("admin API started on a wildcard address without admin_token")
+	}
+	if !strings.Contains(err.Error(), "admin_token") {
+		t.Fatalf("error %q does not mention admin_token", err)
+	}
+
+	srv, err = startSelfTestServer(env, false, func(cfg *config.Config) {
+		cfg.AdminAddr = "0.0.0.0:0"
+		cfg.AdminToken = testAdminToken
+	})
+	if err != nil {
+		t.Fatalf("with admin_token: %v", err)
+	}
+	srv.Shutdown(context.Background())
+}
+
+func TestAdminToken(t *testing.T) {
+	srv, _ := startTestServer(t, func(cfg *config.Config) {
+		cfg.AdminAddr = "127.0.0.1:0"
+		cfg.AdminToken = testAdminToken
+		cfg.Capture.Dir = t.TempDir()
+	})
+	admin := "http://" + srv.AdminAddr().String()
+
+	do := func(method, path, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, admin+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	guarded := []struct{ method, path string }{
+		{http.MethodGet, "/backends"},
+		{http.MethodGet, "/pool"},
+		{http.MethodGet, "/udp"},
+		{http.MethodGet, "/tunnels"},
+		{http.MethodGet, "/logging/levels"},
+		{http.MethodGet, "/captures"},
+		{http.MethodPost, "/captures?duration=1s"},
+		{http.MethodGet, "/captures/1"},
+		{http.MethodDelete, "/captures/1"},
+		{http.MethodPut, "/logging/levels?module=bridge&level=trace"},
+	}
+	for _, e := range guarded {
+		for _, token := range []string{"", "wrong"} {
+			if got := do(e.method, e.path, token); got != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: status %d, want 401", e.method, e.path, token, got)
+			}
+		}
+	}
+
+	if got := do(http.MethodPost, "/captures?duration=1s", testAdminToken); got != http.StatusCreated {
+		t.Errorf("start capture: status %d, want 201", got)
+	}
+	if got := do(http.MethodGet, "/captures/1", testAdminToken); got != http.StatusOK {
+		t.Errorf("download capture: status %d, want 200", got)
+	}
+	if got := do(http.MethodDelete, "/captures/1", testAdminToken); got != http.StatusOK {
+		t.Errorf("stop capture: status %d, want 200", got)
+	}
+	if got := do(http.MethodPut, "/logging/levels?module=bridge&level=", testAdminToken); got != http.StatusOK {
+		t.Errorf("set log level: status %d, want 200", got)
+	}
+	for _, path := range []string{"/backends", "/pool", "/udp", "/tunnels", "/logging/levels"} {
+		if got := do(http.MethodGet, path, testAdminToken); got != http.StatusOK {
+			t.Errorf("GET %s: status %d, want 200", path, got)
+		}
+	}
+}
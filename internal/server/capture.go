@@ -0,0 +1,475 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/capture"
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+const (
+	defaultCaptureDuration    = 30 * time.Second
+	defaultCaptureMaxDuration = 10 * time.Minute
+	defaultCaptureMaxBytes    = 100 << 20
+	defaultCaptureMaxFiles    = 20
+	defaultCaptureMaxAge      = 24 * time.Hour
+)
+
+// addresses standing in for endpoints that are not IP addresses, such as
+// a service backend behind an HTTP poll session (RFC 5737 test networks)
+var (
+	unknownClient  = netip.MustParseAddr("192.0.2.1")
+	unknownBackend = netip.MustParseAddr("198.51.100.1")
+)
+
+// TunnelInfo describes a bridged tunnel for GET /tunnels; ID is what
+// capture filters refer to as conn
+type TunnelInfo struct {
+	ID        uint64    `json:"id"`
+	Target    string    `json:"target"`
+	Transport string    `json:"transport"`
+	Client    string    `json:"client"`
+	Backend   string    `json:"backend"`
+	Start     time.Time `json:"start"`
+}
+
+// tunnelTap is the bridge Recorder of every bridged tunnel. It feeds the
+// session recording, if any, and each capture whose filter matches the
+// tunnel, so a capture also picks up tunnels that were already open.
+type tunnelTap struct {
+	s    *Server
+	info TunnelInfo
+	conn capture.Conn
+	rec  bridge.Recorder
+}
+
+// tap registers a tunnel to target over backend and returns its Recorder.
+// When the tunnel's recording cannot be started the tunnel is not
+// registered and the error carries errcode.RecordFailed.
+func (s *Server) tap(target, transport, client string, backend net.Addr, route *config.RouteConfig) (bridge.Recorder, error) {
+	rec, err := s.recorder(target, transport, client, backend, route)
+	if err != nil {
+		return nil, err
+	}
+	t := &tunnelTap{
+		s: s,
+		info: TunnelInfo{
+			ID:        s.tunnelSeq.Add(1),
+			Target:    target,
+			Transport: transport,
+			Client:    client,
+			Start:     time.Now(),
+		},
+		rec: rec,
+	}
+	if backend != nil {
+		t.info.Backend = backend.String()
+	}
+	t.conn = capture.Conn{
+		Client:  endpoint(client, "", unknownClient),
+		Backend: endpoint(t.info.Backend, target, unknownBackend),
+		Comment: fmt.Sprintf("conn %d: %s via %s", t.info.ID, target, transport),
+	}
+	s.tunnelsMu.Lock()
+	s.tunnels[t.info.ID] = t
+	s.tunnelsMu.Unlock()
+	s.log.Debug("tunnel %d: %s via %s from %s", t.info.ID, target, transport, client)
+	return t, nil
+}
+
+// endpoint parses addr as an IP endpoint; failing that it keeps the port
+// of fallback (a target) on a stand-in address
+func endpoint(addr, fallback string, stand netip.Addr) netip.AddrPort {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap
+	}
+	if ap, err := netip.ParseAddrPort(fallback); err == nil {
+		return ap
+	}
+	var port uint64
+	if _, p, err := net.SplitHostPort(fallback); err == nil {
+		port, _ = strconv.ParseUint(p, 10, 16)
+	}
+	return netip.AddrPortFrom(stand, uint16(port))
+}
+
+func (t *tunnelTap) Record(toClient bool, p []byte) {
+	if t.rec != nil {
+		t.rec.Record(toClient, p)
+	}
+	for _, c := range t.s.captures.running() {
+		if c.filter.matches(&t.info) {
+			c.data(t, toClient, p)
+		}
+	}
+}
+
+func (t *tunnelTap) Close() error {
+	for _, c := range t.s.captures.running() {
+		c.end(t.info.ID)
+	}
+	t.s.tunnelsMu.Lock()
+	delete(t.s.tunnels, t.info.ID)
+	t.s.tunnelsMu.Unlock()
+	if t.rec != nil {
+		return t.rec.Close()
+	}
+	return nil
+}
+
+// Tunnels lists the bridged tunnels by ID
+func (s *Server) Tunnels() []TunnelInfo {
+	s.tunnelsMu.Lock()
+	list := make([]TunnelInfo, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		list = append(list, t.info)
+	}
+	s.tunnelsMu.Unlock()
+	slices.SortFunc(list, func(a, b TunnelInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return list
+}
+
+// captureFilter selects tunnels; empty fields match everything
+type captureFilter struct {
+	Target string `json:"target,omitempty"`
+	Client string `json:"client,omitempty"` // IP or CIDR
+	Conn   uint64 `json:"conn,omitempty"`
+
+	prefix netip.Prefix // Client; a single IP is a /32 or /128
+}
+
+// parseCaptureFilter reads target, client (IP or CIDR) and conn
+func parseCaptureFilter(target, client, conn string) (captureFilter, error) {
+	f := captureFilter{Target: target, Client: client}
+	if client != "" {
+		if ip, err := netip.ParseAddr(client); err == nil {
+			f.prefix = netip.PrefixFrom(ip, ip.BitLen())
+		} else if f.prefix, err = netip.ParsePrefix(client); err != nil {
+			return f, fmt.Errorf("client: want an IP or CIDR, got %q", client)
+		}
+	}
+	if conn != "" {
+		id, err := strconv.ParseUint(conn, 10, 64)
+		if err != nil || id == 0 {
+			return f, fmt.Errorf("conn: want a tunnel ID, got %q", conn)
+		}
+		f.Conn = id
+	}
+	return f, nil
+}
+
+func (f captureFilter) matches(t *TunnelInfo) bool {
+	if f.Target != "" && f.Target != t.Target {
+		return false
+	}
+	if f.Conn != 0 && f.Conn != t.ID {
+		return false
+	}
+	if f.prefix.IsValid() {
+		ap, err := netip.ParseAddrPort(t.Client)
+		if err != nil || !f.prefix.Contains(ap.Addr().Unmap()) {
+			return false
+		}
+	}
+	return true
+}
+
+// CaptureStatus reports a capture for the admin API
+type CaptureStatus struct {
+	ID      uint64        `json:"id"`
+	Filter  captureFilter `json:"filter"`
+	File    string        `json:"file"`
+	Started time.Time     `json:"started"`
+	Until   time.Time     `json:"until"`
+	Running bool          `json:"running"`
+	Stopped string        `json:"stopped,omitempty"` // why it ended: duration, max_bytes, admin, shutdown or a write error
+	Packets int64         `json:"packets"`
+	Bytes   int64         `json:"bytes"`
+}
+
+// captureSession writes the tunnels matching its filter to one pcapng file
+// until its duration runs out, the file reaches max_bytes or it is stopped
+type captureSession struct {
+	table    *captureTable
+	id       uint64
+	filter   captureFilter
+	path     string
+	started  time.Time
+	until    time.Time
+	maxBytes int64
+	timer    *time.Timer
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *capture.Writer
+	stopped string
+}
+
+func (c *captureSession) data(t *tunnelTap, toClient bool, p []byte) {
+	c.mu.Lock()
+	if c.stopped != "" {
+		c.mu.Unlock()
+		return
+	}
+	err := c.w.Data(t.info.ID, t.conn, toClient, p)
+	full := c.w.Written() >= c.maxBytes
+	c.mu.Unlock()
+	if err != nil {
+		c.stop(err.Error())
+	} else if full {
+		c.stop("max_bytes")
+	}
+}
+
+func (c *captureSession) end(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped == "" {
+		_ = c.w.End(id)
+	}
+}
+
+// stop finishes the capture; the first reason sticks
+func (c *captureSession) stop(reason string) {
+	c.mu.Lock()
+	if c.stopped != "" {
+		c.mu.Unlock()
+		return
+	}
+	c.stopped = reason
+	c.timer.Stop()
+	err := c.f.Close()
+	c.mu.Unlock()
+	c.table.refresh()
+	if err != nil {
+		c.table.s.log.Error("capture %d: %v", c.id, err)
+	}
+	c.table.s.log.Info("📼 Capture %d stopped (%s): %s", c.id, reason, c.path)
+}
+
+func (c *captureSession) status() CaptureStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CaptureStatus{
+		ID:      c.id,
+		Filter:  c.filter,
+		File:    c.path,
+		Started: c.started,
+		Until:   c.until,
+		Running: c.stopped == "",
+		Stopped: c.stopped,
+		Packets: c.w.Packets(),
+		Bytes:   c.w.Written(),
+	}
+}
+
+// captureTable holds the captures started since the server came up; taps
+// read the running ones on every chunk without locking
+type captureTable struct {
+	s   *Server
+	seq atomic.Uint64
+	act atomic.Pointer[[]*captureSession]
+
+	mu  sync.Mutex
+	all []*captureSession
+}
+
+var errCaptureDuration = errors.New("duration exceeds capture.max_duration")
+
+func newCaptureTable(s *Server) *captureTable {
+	t := &captureTable{s: s}
+	t.act.Store(&[]*captureSession{})
+	return t
+}
+
+func (t *captureTable) running() []*captureSession { return *t.act.Load() }
+
+// start opens a capture file and runs it for d (zero for the default)
+func (t *captureTable) start(f captureFilter, d time.Duration) (*captureSession, error) {
+	cfg := t.s.cfg.Capture
+	limit := cfg.MaxDuration
+	if limit <= 0 {
+		limit = defaultCaptureMaxDuration
+	}
+	if d <= 0 {
+		d = min(defaultCaptureDuration, limit)
+	}
+	if d > limit {
+		return nil, errCaptureDuration
+	}
+	maxBytes := int64(cfg.MaxBytes)
+	if maxBytes <= 0 {
+		maxBytes = defaultCaptureMaxBytes
+	}
+	dir := t.dir()
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	id := t.seq.Add(1)
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("anylink-%s-%d.pcapng", now.UTC().Format("20060102T150405Z"), id))
+	// plaintext tunnel data: owner only
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := capture.NewWriter(file, fmt.Sprintf("anylink capture %d", id))
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	c := &captureSession{
+		table:    t,
+		id:       id,
+		filter:   f,
+		path:     path,
+		started:  now,
+		until:    now.Add(d),
+		maxBytes: maxBytes,
+		f:        file,
+		w:        w,
+	}
+	c.mu.Lock()
+	c.timer = time.AfterFunc(d, func() { c.stop("duration") })
+	c.mu.Unlock()
+
+	t.mu.Lock()
+	t.all = append(t.all, c)
+	t.publish()
+	t.prune()
+	t.mu.Unlock()
+	t.s.log.Info("📼 Capture %d to %s for %v", id, path, d)
+	return c, nil
+}
+
+func (t *captureTable) dir() string {
+	if dir := t.s.cfg.Capture.Dir; dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// prune deletes the finished capture files in the capture dir, this run's
+// or an earlier one's, beyond capture.max_files or older than
+// capture.max_age, and forgets their captures; callers hold mu
+func (t *captureTable) prune() {
+	cfg := t.s.cfg.Capture
+	keep, maxAge := cfg.MaxFiles, cfg.MaxAge
+	if keep <= 0 {
+		keep = defaultCaptureMaxFiles
+	}
+	if maxAge <= 0 {
+		maxAge = defaultCaptureMaxAge
+	}
+	running := make(map[string]bool)
+	for _, c := range t.running() {
+		running[c.path] = true
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(t.dir(), "anylink-*.pcapng"))
+	type file struct {
+		path string
+		mod  time.Time
+	}
+	var files []file
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || running[p] {
+			continue
+		}
+		files = append(files, file{p, info.ModTime()})
+	}
+	// most recently written first
+	slices.SortFunc(files, func(a, b file) int { return b.mod.Compare(a.mod) })
+	now := time.Now()
+	for i, f := range files {
+		if i < keep && now.Sub(f.mod) <= maxAge {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			t.s.log.Error("pruning capture %s: %v", f.path, err)
+			continue
+		}
+		t.s.log.Debug("pruned capture %s", f.path)
+	}
+
+	// the list keeps at most keep finished captures, all with a file
+	finished := 0
+	for i := len(t.all) - 1; i >= 0; i-- {
+		c := t.all[i]
+		if running[c.path] {
+			continue
+		}
+		finished++
+		if _, err := os.Stat(c.path); finished > keep || err != nil {
+			t.all = slices.Delete(t.all, i, i+1)
+		}
+	}
+}
+
+// publish stores the running captures for taps; callers hold mu
+func (t *captureTable) publish() {
+	var act []*captureSession
+	for _, c := range t.all {
+		c.mu.Lock()
+		if c.stopped == "" {
+			act = append(act, c)
+		}
+		c.mu.Unlock()
+	}
+	t.act.Store(&act)
+}
+
+// refresh drops stopped captures from the running list and prunes
+// finished files
+func (t *captureTable) refresh() {
+	t.mu.Lock()
+	t.publish()
+	t.prune()
+	t.mu.Unlock()
+}
+
+func (t *captureTable) get(id uint64) *captureSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.all {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (t *captureTable) list() []CaptureStatus {
+	t.mu.Lock()
+	all := slices.Clone(t.all)
+	t.mu.Unlock()
+	list := make([]CaptureStatus, len(all))
+	for i, c := range all {
+		list[i] = c.status()
+	}
+	return list
+}
+
+// stopAll ends the running captures on shutdown
+func (t *captureTable) stopAll() {
+	for _, c := range t.running() {
+		c.stop("shutdown")
+	}
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/capture"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// maxCaptureChunk bounds how far one bridge read can overshoot max_bytes
+const maxCaptureChunk = 40 << 10
+
+// startCaptureServer starts a test server with the admin API writing
+// captures to a fresh dir, and returns the admin base URL
+func startCaptureServer(t *testing.T, tweak func(*config.Config)) (*Server, *selfTestEnv, string) {
+	t.Helper()
+	dir := t.TempDir()
+	srv, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.AdminAddr = "127.0.0.1:0"
+		cfg.Capture.Dir = dir
+		if tweak != nil {
+			tweak(cfg)
+		}
+	})
+	return srv, env, "http://" + srv.AdminAddr().String()
+}
+
+func TestCaptureWSByTarget(t *testing.T) {
+	_, env, admin := startCaptureServer(t, nil)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/"+env.echoAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	other, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/"+selfTestService, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	// both tunnels exist before the capture starts
+	for _, c := range []*websocket.Conn{ws, other} {
+		if err := wsEchoConn(ctx, c, randomPayload(16)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var st CaptureStatus
+	if err := adminJSON(ctx, http.MethodPost, admin+"/captures?duration=10s&target="+env.echoAddr, http.StatusCreated, &st); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(st.File); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("capture file: %v, %v; want mode 0600", fi, err)
+	}
+	payload := randomPayload(100 << 10)
+	if err := wsEchoConn(ctx, ws, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsEchoConn(ctx, other, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+	if err := waitTunnels(ctx, admin, 1); err != nil {
+		t.Fatal(err)
+	}
+	pkts, err := stopCapture(ctx, admin, st.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flows := splitFlows(pkts)
+	if len(flows) != 1 {
+		t.Fatalf("%d flows captured, want the echo tunnel only", len(flows))
+	}
+	f := flows[0]
+	if want := netip.MustParseAddrPort(env.echoAddr); f.backend != want {
+		t.Errorf("backend %v, want %v", f.backend, want)
+	}
+	if !strings.Contains(f.comment, env.echoAddr) || !f.fin || !f.valid {
+		t.Errorf("flow comment %q, fin %v, valid checksums %v", f.comment, f.fin, f.valid)
+	}
+	if !bytes.Equal(f.up, payload) || !bytes.Equal(f.down, payload) {
+		t.Errorf("captured %d bytes up and %d down, want %d each", len(f.up), len(f.down), len(payload))
+	}
+}
+
+func TestCaptureQUICByClientAndConn(t *testing.T) {
+	_, env, admin := startCaptureServer(t, nil)
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		var streams []quic.Stream
+		for i := 0; i < 2; i++ {
+			stream, err := conn.OpenStreamSync(ctx)
+			if err != nil {
+				return fmt.Errorf("QUIC stream: %v", err)
+			}
+			defer stream.Close()
+			if dl, ok := ctx.Deadline(); ok {
+				_ = stream.SetDeadline(dl)
+			}
+			if err := streamEcho(stream, env.echoAddr, randomPayload(16)); err != nil {
+				return err
+			}
+			streams = append(streams, stream)
+		}
+		var tunnels []TunnelInfo
+		if err := adminJSON(ctx, http.MethodGet, admin+"/tunnels", http.StatusOK, &tunnels); err != nil {
+			return err
+		}
+		if len(tunnels) != 2 || tunnels[0].Transport != "quic" {
+			return fmt.Errorf("tunnels %+v, want two quic tunnels", tunnels)
+		}
+
+		var all, one CaptureStatus
+		if err := adminJSON(ctx, http.MethodPost, admin+"/captures?client=127.0.0.0/8", http.StatusCreated, &all); err != nil {
+			return err
+		}
+		second := fmt.Sprint(tunnels[1].ID)
+		if err := adminJSON(ctx, http.MethodPost, admin+"/captures?client=127.0.0.1&conn="+second, http.StatusCreated, &one); err != nil {
+			return err
+		}
+		payloads := [][]byte{randomPayload(1000), randomPayload(2000)}
+		for i, stream := range streams {
+			if err := echoOn(stream, payloads[i]); err != nil {
+				return err
+			}
+		}
+
+		pkts, err := stopCapture(ctx, admin, all.ID)
+		if err != nil {
+			return err
+		}
+		flows := splitFlows(pkts)
+		// one QUIC connection: the capture gives each stream its own port
+		if len(flows) != 2 || flows[0].client == flows[1].client {
+			return fmt.Errorf("%d flows from %v, want two with distinct client ports", len(flows), clients(flows))
+		}
+		if pkts, err = stopCapture(ctx, admin, one.ID); err != nil {
+			return err
+		}
+		flows = splitFlows(pkts)
+		if len(flows) != 1 || !bytes.Equal(flows[0].up, payloads[1]) || !bytes.Equal(flows[0].down, payloads[1]) {
+			return fmt.Errorf("conn filter captured %d flows, want tunnel %s only", len(flows), second)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaptureLimits(t *testing.T) {
+	_, env, admin := startCaptureServer(t, func(cfg *config.Config) {
+		cfg.Capture.MaxDuration = time.Minute
+		cfg.Capture.MaxBytes = 8 << 10
+	})
+	ctx := testContext(t)
+	for _, bad := range []string{"duration=2m", "duration=soon", "client=nobody", "conn=x"} {
+		if err := adminJSON(ctx, http.MethodPost, admin+"/captures?"+bad, http.StatusBadRequest, nil); err != nil {
+			t.Errorf("%s: %v", bad, err)
+		}
+	}
+
+	var short, full CaptureStatus
+	if err := adminJSON(ctx, http.MethodPost, admin+"/captures?duration=50ms&target=nothing", http.StatusCreated, &short); err != nil {
+		t.Fatal(err)
+	}
+	if err := adminJSON(ctx, http.MethodPost, admin+"/captures", http.StatusCreated, &full); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsEcho(ctx, websocket.DefaultDialer, env.wsURL+"/"+env.echoAddr, randomPayload(64<<10)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var list []CaptureStatus
+		if err := adminJSON(ctx, http.MethodGet, admin+"/captures", http.StatusOK, &list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 2 && list[0].Stopped == "duration" && list[1].Stopped == "max_bytes" {
+			if list[1].Bytes > 8<<10+maxCaptureChunk {
+				t.Errorf("capture grew to %d bytes", list[1].Bytes)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("captures %+v, want stopped by duration and max_bytes", list)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestCaptureRetention(t *testing.T) {
+	const maxFiles = 2
+	dir := t.TempDir()
+	// finished captures of an earlier run: one too old, one recent
+	stale := filepath.Join(dir, "anylink-20260101T000000Z-1.pcapng")
+	earlier := filepath.Join(dir, "anylink-20260101T000000Z-2.pcapng")
+	other := filepath.Join(dir, "notes.pcapng")
+	for _, p := range []string{stale, earlier, other} {
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(earlier, time.Now().Add(-time.Minute), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	_, _, admin := startCaptureServer(t, func(cfg *config.Config) {
+		cfg.Capture.Dir = dir
+		cfg.Capture.MaxFiles = maxFiles
+		cfg.Capture.MaxAge = time.Hour
+	})
+	ctx := testContext(t)
+
+	var files []string
+	for i := 0; i < 4; i++ {
+		var st CaptureStatus
+		if err := adminJSON(ctx, http.MethodPost, admin+"/captures", http.StatusCreated, &st); err != nil {
+			t.Fatal(err)
+		}
+		if err := adminJSON(ctx, http.MethodDelete, fmt.Sprintf("%s/captures/%d", admin, st.ID), http.StatusOK, nil); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, st.File)
+		time.Sleep(10 * time.Millisecond) // distinct modification times
+	}
+
+	// the newest maxFiles finished captures stay, on disk and in the list
+	for p, want := range map[string]bool{
+		stale: false, earlier: false, other: true,
+		files[0]: false, files[1]: false, files[2]: true, files[3]: true,
+	} {
+		_, err := os.Stat(p)
+		if got := err == nil; got != want {
+			t.Errorf("%s kept %v, want %v", filepath.Base(p), got, want)
+		}
+	}
+	var list []CaptureStatus
+	if err := adminJSON(ctx, http.MethodGet, admin+"/captures", http.StatusOK, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != maxFiles || list[0].File != files[2] || list[1].File != files[3] {
+		t.Errorf("captures %+v, want the last %d", list, maxFiles)
+	}
+	if err := adminJSON(ctx, http.MethodGet, admin+"/captures/1", http.StatusNotFound, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// adminJSON calls the admin API, expects status and decodes the body into v
+func adminJSON(ctx context.Context, method, url string, status int, v any) error {
+	req, _ := http.NewRequestWithContext(ctx, method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s, want %d", method, url, resp.Status, bytes.TrimSpace(body), status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// waitTunnels waits until n tunnels are open
+func waitTunnels(ctx context.Context, admin string, n int) error {
+	for {
+		var tunnels []TunnelInfo
+		if err := adminJSON(ctx, http.MethodGet, admin+"/tunnels", http.StatusOK, &tunnels); err != nil {
+			return err
+		}
+		if len(tunnels) == n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d tunnels open, want %d", len(tunnels), n)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// stopCapture ends capture id and decodes its file
+func stopCapture(ctx context.Context, admin string, id uint64) ([]capture.Packet, error) {
+	url := fmt.Sprintf("%s/captures/%d", admin, id)
+	if err := adminJSON(ctx, http.MethodDelete, url, http.StatusOK, nil); err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return capture.ReadPackets(resp.Body)
+}
+
+// echoOn writes payload to an open tunnel stream and reads it back
+func echoOn(stream io.ReadWriter, payload []byte) error {
+	if _, err := stream.Write(payload); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(stream, got); err != nil {
+		return fmt.Errorf("read: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("echo mismatch (%d bytes)", len(payload))
+	}
+	return nil
+}
+
+// capturedFlow is one synthetic TCP connection in a capture
+type capturedFlow struct {
+	client, backend netip.AddrPort
+	comment         string
+	up, down        []byte
+	fin, valid      bool
+}
+
+// splitFlows groups packets by connection in order of their SYNs
+func splitFlows(pkts []capture.Packet) []*capturedFlow {
+	var flows []*capturedFlow
+	byClient := map[netip.AddrPort]*capturedFlow{}
+	for _, p := range pkts {
+		if p.SYN() && !p.FIN() && p.Ack == 0 {
+			f := &capturedFlow{client: p.Src, backend: p.Dst, comment: p.Comment, valid: true}
+			flows = append(flows, f)
+			byClient[p.Src] = f
+		}
+		f := byClient[p.Src]
+		if f == nil {
+			f = byClient[p.Dst]
+		}
+		if f == nil {
+			continue
+		}
+		f.valid = f.valid && p.Valid
+		f.fin = f.fin || p.FIN()
+		if p.Src == f.client {
+			f.up = append(f.up, p.Payload...)
+		} else {
+			f.down = append(f.down, p.Payload...)
+		}
+	}
+	return flows
+}
+
+func clients(flows []*capturedFlow) []netip.AddrPort {
+	var c []netip.AddrPort
+	for _, f := range flows {
+		c = append(c, f.client)
+	}
+	return c
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/policy"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// proxyHandler serves CONNECT requests in front of the WS mux. Plain
+// CONNECT host:port (HTTP/1.1 or HTTP/2) is a proxy tunnel; an HTTP/2
+// extended CONNECT with :protocol websocket (RFC 8441) is rewritten into
+// the HTTP/1.1 upgrade the WS handlers expect.
+func (s *Server) proxyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch p := r.Header.Get(":protocol"); {
+		case p == "":
+			s.handleConnect(w, r)
+		case strings.EqualFold(p, "websocket"):
+			next.ServeHTTP(h2WebSocket(w, r))
+		default:
+			http.Error(w, "unsupported protocol", http.StatusNotImplemented)
+		}
+	})
+}
+
+// handleConnect bridges a CONNECT host:port request to the backend through
+// the target policy and pool
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(r.Context(), r.Header), "connect.tunnel",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrTransport.String("connect"), tracing.AttrTarget.String(r.Host)))
+	defer span.End()
+
+	if !s.proxyAuthorized(r) {
+		span.SetStatus(codes.Error, "proxy auth required")
+		w.Header().Set("Proxy-Authenticate", `Basic realm="anylink"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+	target := r.Host
+	addrs, err := s.authorizeTarget(ctx, target)
+	if err != nil {
+		status, msg := http.StatusForbidden, "target not allowed"
+		if !errors.Is(err, policy.ErrDenied) {
+			status, msg = http.StatusBadGateway, "cannot resolve target"
+		}
+		s.log.Debug("refusing CONNECT %s: %v", target, err)
+		span.SetStatus(codes.Error, msg)
+		http.Error(w, msg, status)
+		return
+	}
+	backend, err := s.dialTarget(ctx, target, addrs, s.clientKey(target, r.RemoteAddr, r.Header), nil)
+	if err != nil {
+		span.SetStatus(codes.Error, "connect failed")
+		s.log.Error("dial %s: %v", target, err)
+		http.Error(w, "connect failed", http.StatusBadGateway)
+		return
+	}
+	// before the 200: a recording that fails refuses the tunnel
+	rec, err := s.tap(target, "connect", r.RemoteAddr, backend.RemoteAddr(), nil)
+	if err != nil {
+		backend.Close()
+		span.SetStatus(codes.Error, "recording failed")
+		s.log.Error("%v", err)
+		http.Error(w, "recording failed", http.StatusServiceUnavailable)
+		return
+	}
+
+	var client net.Conn
+	if r.ProtoMajor == 1 {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			backend.Close()
+			rec.Close()
+			s.log.Error("CONNECT hijack: %v", err)
+			return
+		}
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			backend.Close()
+			rec.Close()
+			conn.Close()
+			return
+		}
+		client = &bufferedConn{Conn: conn, r: brw.Reader}
+	} else {
+		// HTTP/2: the tunnel is this stream; others share the connection
+		w.WriteHeader(http.StatusOK)
+		client = newStreamConn(w, r)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			backend.Close()
+			rec.Close()
+			return
+		}
+	}
+
+	_, bSpan := tracing.Start(ctx, "bridge", tracing.AttrTransport.String("connect"), tracing.AttrTarget.String(target))
+	cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(nil))
+	cfg.Recorder = rec
+	b := bridge.NewTCPBridge(client, backend, cfg)
+	defer b.Close()
+	b.Wg().Wait()
+	endBridgeSpan(bSpan, b)
+}
+
+// proxyAuthorized checks Proxy-Authorization against proxy.auth_tokens:
+// "Bearer <token>", or Basic with the token as password (any user name)
+func (s *Server) proxyAuthorized(r *http.Request) bool {
+	tokens := s.cfg.Proxy.AuthTokens
+	if len(tokens) == 0 {
+		return true
+	}
+	auth := r.Header.Get("Proxy-Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		// reuse Request.BasicAuth's parsing on the proxy header
+		_, token, ok = (&http.Request{Header: http.Header{"Authorization": {auth}}}).BasicAuth()
+	}
+	if !ok || token == "" {
+		return false
+	}
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// streamConn adapts an HTTP/2 request stream to net.Conn: reads come from
+// the request body, writes are flushed as DATA frames
+type streamConn struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	body   io.ReadCloser
+	local  net.Addr
+	remote net.Addr
+}
+
+func newStreamConn(w http.ResponseWriter, r *http.Request) *streamConn {
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	return &streamConn{w: w, rc: http.NewResponseController(w), body: r.Body, local: local, remote: remote}
+}
+
+func (c *streamConn) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = c.rc.Flush()
+	}
+	return n, err
+}
+
+// Close stops reading; the stream ends when the handler returns
+func (c *streamConn) Close() error { return c.body.Close() }
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.local }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	_ = c.rc.SetWriteDeadline(t)
+	return c.rc.SetReadDeadline(t)
+}
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return c.rc.SetReadDeadline(t) }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return c.rc.SetWriteDeadline(t) }
+
+// h2WebSocket rewrites an RFC 8441 request as a GET upgrade. RFC 8441 has
+// no Sec-WebSocket-Key, so one is made up for the upgrader.
+func h2WebSocket(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	up := r.Clone(r.Context())
+	up.Method = http.MethodGet
+	up.Header.Del(":protocol")
+	up.Header.Set("Connection", "Upgrade")
+	up.Header.Set("Upgrade", "websocket")
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	up.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	return &h2Upgrade{ResponseWriter: w, req: r}, up
+}
+
+// h2Upgrade lets websocket.Upgrader "hijack" an HTTP/2 stream. The
+// upgrader's HTTP/1.1 101 response is turned into a 200 on the stream.
+type h2Upgrade struct {
+	http.ResponseWriter
+	req *http.Request
+}
+
+func (u *h2Upgrade) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c := &h2UpgradeConn{streamConn: newStreamConn(u.ResponseWriter, u.req)}
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+// h2UpgradeConn swallows the 101 handshake written before any frames
+type h2UpgradeConn struct {
+	*streamConn
+	handshake bool
+}
+
+func (c *h2UpgradeConn) Write(p []byte) (int, error) {
+	if c.handshake {
+		return c.streamConn.Write(p)
+	}
+	c.handshake = true
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(p)), nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		return 0, errors.New("unexpected upgrade response")
+	}
+	for _, k := range []string{"Sec-Websocket-Protocol", "Sec-Websocket-Extensions"} {
+		if v := resp.Header.Get(k); v != "" {
+			c.w.Header().Set(textproto.CanonicalMIMEHeaderKey(k), v)
+		}
+	}
+	c.w.WriteHeader(http.StatusOK)
+	return len(p), c.rc.Flush()
+}
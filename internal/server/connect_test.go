@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	_ "github.com/DanielcoderX/anylink/internal/xconnect" // as in cmd/anylink
+)
+
+func TestConnectAuth(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	for _, auth := range []string{"", "Bearer wrong", basicAuth("user", "wrong")} {
+		if _, _, err := connectHTTP1(ctx, env, env.echoAddr, auth, http.StatusProxyAuthRequired); err != nil {
+			t.Errorf("auth %q: %v", auth, err)
+		}
+	}
+	c, _, err := connectHTTP1(ctx, env, env.echoAddr, basicAuth("user", selfTestToken), http.StatusOK)
+	if err != nil {
+		t.Fatalf("basic auth: %v", err)
+	}
+	c.Close()
+}
+
+func TestConnectACLDeny(t *testing.T) {
+	_, env := startTestServer(t)
+	if _, _, err := connectHTTP1(testContext(t), env, env.deniedAddr, "Bearer "+selfTestToken, http.StatusForbidden); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWSOverH2(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	if err := wsOverH2(ctx, env, env.echoAddr, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsOverH2(ctx, env, env.deniedAddr, http.StatusForbidden); err != nil {
+		t.Fatalf("denied target: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+func TestCloseCodeBackendRefusedWS(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/"+env.deadAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := wsExpectCode(ctx, ws, errcode.BackendRefused); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseCodeQUIC(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		if err := quicExpectCode(ctx, conn, env.deniedAddr, errcode.PolicyDenied); err != nil {
+			t.Errorf("denied target: %v", err)
+		}
+		if err := quicExpectCode(ctx, conn, env.deadAddr, errcode.BackendRefused); err != nil {
+			t.Errorf("dead target: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseCodeIdleTimeout(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+selfTestIdleRoute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := wsExpectCode(ctx, ws, errcode.IdleTimeout); err != nil { // sends nothing
+		t.Fatal(err)
+	}
+}
+
+// TestCloseCodeServerDraining shuts a server down under open WS and QUIC
+// tunnels
+func TestCloseCodeServerDraining(t *testing.T) {
+	srv, env := startTestServer(t)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/"+env.echoAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := wsEchoConn(ctx, ws, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+	err = withQUIC(ctx, env, func(conn quic.Connection) error {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			return err
+		}
+		dl, _ := ctx.Deadline()
+		_ = stream.SetDeadline(dl)
+		if err := streamEcho(stream, env.echoAddr, randomPayload(64)); err != nil {
+			return err
+		}
+
+		_ = srv.Shutdown(ctx)
+		if err := wsExpectCode(ctx, ws, errcode.ServerDraining); err != nil {
+			t.Errorf("ws: %v", err)
+		}
+		_, err = io.ReadAll(stream)
+		if err := expectCode(err, errcode.FromQUIC, errcode.ServerDraining); err != nil {
+			t.Errorf("quic: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wsExpectCode reads until the server closes ws and checks its close code
+func wsExpectCode(ctx context.Context, ws *websocket.Conn, want errcode.Code) error {
+	if dl, ok := ctx.Deadline(); ok {
+		_ = ws.SetReadDeadline(dl)
+	}
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			return expectCode(err, errcode.FromWS, want)
+		}
+	}
+}
+
+// quicExpectCode opens a stream to target and expects the server to reset
+// it with want
+func quicExpectCode(ctx context.Context, conn quic.Connection, target string, want errcode.Code) error {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("QUIC stream: %v", err)
+	}
+	defer stream.CancelWrite(0)
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+	if _, err := io.WriteString(stream, target+"\n"); err != nil {
+		return expectCode(err, errcode.FromQUIC, want)
+	}
+	_, err = io.ReadAll(stream)
+	return expectCode(err, errcode.FromQUIC, want)
+}
+
+// expectCode decodes the close reason carried by err
+func expectCode(err error, decode func(error) (errcode.Code, bool), want errcode.Code) error {
+	got, ok := decode(err)
+	if !ok {
+		return fmt.Errorf("no close reason in %v", err)
+	}
+	if got != want {
+		return fmt.Errorf("close reason %q (%d), want %q (%d)", got, got, want, want)
+	}
+	return nil
+}
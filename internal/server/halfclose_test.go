@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// The half-close tests send a payload, half-close and expect the whole
+// echo before EOF: the echo backend only closes once it has read the
+// client's EOF.
+
+func TestHalfCloseQUIC(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			return fmt.Errorf("QUIC stream: %v", err)
+		}
+		defer stream.CancelRead(0)
+		if dl, ok := ctx.Deadline(); ok {
+			_ = stream.SetDeadline(dl)
+		}
+		if _, err := io.WriteString(stream, env.echoAddr+"\n"); err != nil {
+			return err
+		}
+		// Close sends FIN and leaves the receive side open
+		return halfCloseEcho(stream, stream.Close, randomPayload(selfTestLargeSize))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHalfCloseWS(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/"+env.echoAddr+"?halfclose=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	dl, _ := ctx.Deadline()
+	_ = ws.SetReadDeadline(dl)
+	_ = ws.SetWriteDeadline(dl)
+	st := &wsStream{ws: ws}
+	if err := halfCloseEcho(st, st.CloseWrite, randomPayload(selfTestLargeSize)); err != nil {
+		t.Fatal(err)
+	}
+	// both directions are done, so the server ends the WebSocket
+	if _, _, err := ws.NextReader(); !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+		t.Fatalf("after both EOFs: %v", err)
+	}
+}
+
+func TestHalfCloseTCP(t *testing.T) {
+	_, env := startTestServer(t)
+	c, err := tcpDial(testContext(t), env.tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, env.echoAddr+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := halfCloseEcho(c, c.(*net.TCPConn).CloseWrite, randomPayload(selfTestLargeSize)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHalfCloseMux(t *testing.T) {
+	_, env := startTestServer(t)
+	c, err := muxDial(testContext(t), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	st, err := c.open(env.echoAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := halfCloseEcho(st, st.CloseWrite, randomPayload(4*selfTestMuxWindow)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// halfCloseEcho writes payload, calls closeWrite and reads until EOF
+func halfCloseEcho(rw io.ReadWriter, closeWrite func() error, payload []byte) error {
+	werr := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(payload)
+		if err == nil {
+			err = closeWrite()
+		}
+		werr <- err
+	}()
+	got, err := io.ReadAll(rw)
+	if err != nil {
+		return fmt.Errorf("read after %d bytes: %v", len(got), err)
+	}
+	if err := <-werr; err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("echo before EOF: got %d of %d bytes", len(got), len(payload))
+	}
+	return nil
+}
+
+// wsStream reads and writes streamID-1 frames as a byte stream; an EOF
+// control frame ends it. Close drops the TCP connection without a close
+// handshake.
+type wsStream struct {
+	ws      *websocket.Conn
+	pending []byte
+	eof     bool
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		_, rdr, err := s.ws.NextReader()
+		if err != nil {
+			return 0, err
+		}
+		streamID, data, err := bridge.ReadWSFrame(rdr)
+		if err != nil {
+			return 0, err
+		}
+		if streamID == 1 {
+			s.pending = data
+		} else if typ, id, _, err := bridge.ParseMuxControl(data); err == nil && typ == bridge.MuxEOF && id == 1 {
+			s.eof = true
+		}
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *wsStream) Write(p []byte) (int, error) {
+	for off := 0; off < len(p); off += selfTestChunk {
+		if err := bridge.WriteWSFrame(s.ws, 1, p[off:min(off+selfTestChunk, len(p))]); err != nil {
+			return off, err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *wsStream) Close() error { return s.ws.NetConn().Close() }
+
+// CloseWrite sends the EOF control frame for stream 1
+func (s *wsStream) CloseWrite() error {
+	return bridge.WriteWSFrame(s.ws, 0, bridge.MuxControl(bridge.MuxEOF, 1, nil))
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// probePayload is echoed through the QUIC bridge by readiness checks
+var probePayload = []byte("anylink-readyz")
+
+// CheckResult is one readiness check outcome
+type CheckResult struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is the /readyz response body
+type HealthReport struct {
+	Status string        `json:"status"` // ok | fail | draining
+	Checks []CheckResult `json:"checks"`
+}
+
+// startEchoServer runs an in-process TCP echo loop on an ephemeral loopback port
+func startEchoServer() (net.Listener, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}(c)
+		}
+	}()
+	return ln, nil
+}
+
+// handleHealthz reports liveness: the process is up and serving HTTP
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// handleReadyz answers 200 or 503 from a readiness report that is at most
+// health.cache old
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.cachedReadiness(r.Context())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// cachedReadiness returns the last report while it is fresh and runs the
+// checks otherwise. Concurrent callers wait for one run instead of each
+// probing, so the public /readyz cannot be used to multiply QUIC handshakes.
+func (s *Server) cachedReadiness(ctx context.Context) HealthReport {
+	ttl := s.cfg.Health.Cache
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	if s.readyAt.IsZero() || time.Since(s.readyAt) >= ttl {
+		// a client hanging up must not cache a cancelled probe
+		s.ready = s.Readiness(context.WithoutCancel(ctx))
+		s.readyAt = time.Now()
+	}
+	report := s.ready
+	if s.draining.Load() {
+		report.Status = "draining"
+	}
+	return report
+}
+
+// Readiness probes the QUIC listener (handshake + echo through a real
+// bridge), the served TLS certificate and any configured backends.
+func (s *Server) Readiness(ctx context.Context) HealthReport {
+	timeout := s.cfg.Health.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	report := HealthReport{Status: "ok"}
+	add := func(name string, start time.Time, err error) {
+		res := CheckResult{Name: name, OK: err == nil, Duration: time.Since(start).String()}
+		if err != nil {
+			res.Error = err.Error()
+			report.Status = "fail"
+		}
+		report.Checks = append(report.Checks, res)
+	}
+
+	start := time.Now()
+	state, err := s.probeQUIC(ctx)
+	add("quic", start, err)
+
+	start = time.Now()
+	add("tls", start, checkPeerCert(state, time.Now()))
+
+	for _, target := range s.cfg.Health.Backends {
+		start = time.Now()
+		add("backend:"+target, start, probeBackend(ctx, target))
+	}
+
+	if s.draining.Load() {
+		report.Status = "draining"
+	}
+	return report
+}
+
+// probeQUIC dials our own QUIC listener and echoes probePayload through the
+// in-process echo server, returning the handshake state for the TLS check.
+func (s *Server) probeQUIC(ctx context.Context) (*tls.ConnectionState, error) {
+	if s.quic == nil || s.echo == nil {
+		return nil, fmt.Errorf("QUIC listener not running")
+	}
+	raddr, err := net.ResolveUDPAddr("udp", loopbackAddr(s.quic.Addr()))
+	if err != nil {
+		return nil, err
+	}
+	// bind to the destination IP so the server sees exactly this address
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: raddr.IP})
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	probe := pc.LocalAddr().String()
+	s.probes.Store(probe, struct{}{})
+	defer s.probes.Delete(probe)
+
+	conn, err := quic.Dial(ctx, pc, raddr,
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"anylink-quic"}},
+		&quic.Config{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.CloseWithError(0, "probe done")
+	state := conn.ConnectionState().TLS
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return &state, err
+	}
+	defer stream.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+
+	msg := append([]byte(s.echo.Addr().String()+"\n"), probePayload...)
+	if _, err := stream.Write(msg); err != nil {
+		return &state, err
+	}
+	buf := make([]byte, len(probePayload))
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return &state, fmt.Errorf("echo read: %v", err)
+	}
+	if !bytes.Equal(buf, probePayload) {
+		return &state, fmt.Errorf("echo mismatch: %q", buf)
+	}
+	return &state, nil
+}
+
+// checkPeerCert validates the certificate the QUIC listener actually served
+func checkPeerCert(state *tls.ConnectionState, now time.Time) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	leaf := state.PeerCertificates[0]
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate not valid until %s", leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// probeBackend opens and closes a TCP connection to target
+func probeBackend(ctx context.Context, target string) error {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// loopbackAddr turns a wildcard listen address into a dialable loopback one
+func loopbackAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
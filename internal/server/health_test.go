@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/quic-go/quic-go"
+)
+
+func TestReadyzCached(t *testing.T) {
+	srv, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Health.Cache = selfTestTimeout
+	})
+
+	var first HealthReport
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(env.httpURL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report HealthReport
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: %+v", resp.StatusCode, report)
+		}
+		if i == 0 {
+			first = report
+			continue
+		}
+		// durations differ on every run, so equal ones mean a cache hit
+		if report.Checks[0].Duration != first.Checks[0].Duration {
+			t.Errorf("request %d ran the checks again", i)
+		}
+	}
+
+	srv.draining.Store(true)
+	defer srv.draining.Store(false)
+	resp, err := http.Get(env.httpURL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("draining: status %d, want 503 despite the cached report", resp.StatusCode)
+	}
+}
+
+func TestProbeEchoNotReachableByClients(t *testing.T) {
+	srv, env := startTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		return quicExpectDenied(ctx, conn, srv.echo.Addr().String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := srv.Readiness(ctx); report.Status != "ok" {
+		t.Fatalf("readiness probe failed: %+v", report)
+	}
+}
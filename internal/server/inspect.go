@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/DanielcoderX/anylink/internal/inspect"
+)
+
+// checkInspectors validates inspect.targets and the routes' inspect lists,
+// so that building them per tunnel cannot fail, and indexes the targets
+func (s *Server) checkInspectors() error {
+	for target, list := range s.cfg.Inspect.Targets {
+		for _, c := range list {
+			if _, err := inspect.New(c); err != nil {
+				return fmt.Errorf("inspect target %s: %v", target, err)
+			}
+		}
+	}
+	for path, rt := range s.cfg.Routes {
+		for _, c := range rt.Inspect {
+			if _, err := inspect.New(c); err != nil {
+				return fmt.Errorf("route %s: inspect: %v", path, err)
+			}
+		}
+	}
+	s.inspectTargets = newTargetIndex(slices.Collect(maps.Keys(s.cfg.Inspect.Targets)), s.cfg.Services, s.policy.Resolve)
+	return nil
+}
+
+// intercept puts a tunnel's backend connection behind fresh interceptors:
+// those of the inspect.targets entry matching target or the address c
+// dialed, then those of route
+func (s *Server) intercept(c net.Conn, target string, route *config.RouteConfig) net.Conn {
+	var list []config.InspectorConfig
+	if key, ok := s.inspectTargets.match(target, c.RemoteAddr()); ok {
+		list = s.cfg.Inspect.Targets[key]
+	}
+	if route != nil {
+		list = append(slices.Clip(list), route.Inspect...)
+	}
+	chain := make([]bridge.Interceptor, 0, len(list))
+	for _, ic := range list {
+		i, _ := inspect.New(ic) // checked by checkInspectors
+		chain = append(chain, i)
+	}
+	return bridge.Intercept(c, target, chain...)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// testRedisRoute puts the echo backend behind a redis interceptor
+const testRedisRoute = "/redis"
+
+// The echo backend answers each side's data with the same bytes, so the
+// interceptors see plausible traffic in both directions.
+
+func TestInspectRedisWSRoute(t *testing.T) {
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Routes[testRedisRoute] = config.RouteConfig{
+			Target:  cfg.Routes[selfTestRoute].Target,
+			Inspect: []config.InspectorConfig{{Type: "redis"}},
+		}
+	})
+	ctx := testContext(t)
+	url := env.wsURL + testRedisRoute
+
+	// a RESP command split inside its name, after allowed ones
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for _, p := range []string{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "PING\r\n", "*1\r\n$8\r\nFLU"} {
+		if err := wsEchoConn(ctx, ws, []byte(p)); err != nil {
+			t.Fatalf("allowed %q: %v", p, err)
+		}
+	}
+	if err := wsSend(ws, "SHALL\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsExpectCode(ctx, ws, errcode.PolicyDenied); err != nil {
+		t.Errorf("FLUSHALL: %v", err)
+	}
+
+	// an inline command, in lower case
+	inline, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inline.Close()
+	if err := wsSend(inline, "config set dir /tmp\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsExpectCode(ctx, inline, errcode.PolicyDenied); err != nil {
+		t.Errorf("inline CONFIG: %v", err)
+	}
+}
+
+func TestInspectPostgresQUICTarget(t *testing.T) {
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Inspect.Targets = map[string][]config.InspectorConfig{
+			cfg.Routes[selfTestRoute].Target: {{Type: "postgres", Users: []string{"analyst"}}},
+		}
+	})
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		// SSLRequest, then the startup message the backend's "N" lets
+		// through, then queries
+		ssl := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), 80877103)
+		payload := append(append(ssl, pgStartup("analyst", "sales")...), randomPayload(1024)...)
+		if err := quicEcho(ctx, conn, env.echoAddr, payload); err != nil {
+			return fmt.Errorf("allowed user: %v", err)
+		}
+		for _, startup := range [][]byte{pgStartup("postgres", ""), {0x16, 0x03, 0x01, 0x02, 0x00, 0x01, 0x00, 0x01}} {
+			if err := quicExpectBlocked(ctx, conn, env.echoAddr, startup); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspectSSHMux(t *testing.T) {
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Inspect.Targets = map[string][]config.InspectorConfig{
+			cfg.Routes[selfTestRoute].Target: {{Type: "ssh", DenySoftware: []string{"libssh_0.*"}}},
+		}
+	})
+	ctx := testContext(t)
+	c, err := muxDial(ctx, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	st, err := c.open(env.echoAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := tunnelEcho(st, append([]byte("SSH-2.0-OpenSSH_9.6 Debian\r\n"), randomPayload(4096)...)); err != nil {
+		t.Fatalf("allowed client: %v", err)
+	}
+	for _, banner := range []string{"SSH-2.0-libssh_0.9.6\r\n", "SSH-1.5-OpenSSH_2.0\r\n", "GET / HTTP/1.1\r\n"} {
+		st, err := c.open(env.echoAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.Write([]byte(banner)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) > 0 || st.closeReason() != errcode.PolicyDenied {
+			t.Errorf("%q: %d bytes, closed with %q", banner, len(got), st.closeReason())
+		}
+	}
+}
+
+// TestInspectTargetSpelling reaches an inspected backend under other
+// spellings than its inspect.targets key: the interceptors must still run
+func TestInspectTargetSpelling(t *testing.T) {
+	env := newTestEnv(t)
+	_, port, _ := net.SplitHostPort(env.echoAddr)
+	for _, tc := range []struct {
+		name, key, target string
+	}{
+		{"mapped IP", env.echoAddr, net.JoinHostPort("::ffff:127.0.0.1", port)},
+		{"name key", "LocalHost.:" + port, env.echoAddr},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, env := startTestServer(t, func(cfg *config.Config) {
+				cfg.Inspect.Targets = map[string][]config.InspectorConfig{tc.key: {{Type: "redis"}}}
+				cfg.AllowedTargets = append(cfg.AllowedTargets, tc.target)
+			})
+			ctx := testContext(t)
+			err := withQUIC(ctx, env, func(conn quic.Connection) error {
+				return quicExpectBlocked(ctx, conn, tc.target, []byte("FLUSHALL\r\n"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// wsSend sends p as one data frame of a WS tunnel
+func wsSend(ws *websocket.Conn, p string) error {
+	if err := bridge.WriteWSFrame(ws, 1, []byte(p)); err != nil {
+		return fmt.Errorf("WS write: %v", err)
+	}
+	return nil
+}
+
+// quicExpectBlocked sends p on a stream to target and expects a reset with
+// errcode.PolicyDenied before anything comes back
+func quicExpectBlocked(ctx context.Context, conn quic.Connection, target string, p []byte) error {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("QUIC stream: %v", err)
+	}
+	defer stream.CancelWrite(0)
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+	if _, err := stream.Write(append([]byte(target+"\n"), p...)); err != nil {
+		return expectCode(err, errcode.FromQUIC, errcode.PolicyDenied)
+	}
+	got, err := io.ReadAll(stream)
+	if len(got) > 0 {
+		return fmt.Errorf("%q: %d bytes passed", p[:min(len(p), 16)], len(got))
+	}
+	return expectCode(err, errcode.FromQUIC, errcode.PolicyDenied)
+}
+
+// pgStartup builds a protocol 3.0 startup message
+func pgStartup(user, database string) []byte {
+	var params bytes.Buffer
+	params.WriteString("user\x00" + user + "\x00")
+	if database != "" {
+		params.WriteString("database\x00" + database + "\x00")
+	}
+	params.WriteByte(0)
+	msg := binary.BigEndian.AppendUint32(nil, uint32(8+params.Len()))
+	msg = binary.BigEndian.AppendUint32(msg, 3<<16)
+	return append(msg, params.Bytes()...)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errMuxUDP = errors.New("udp targets are not supported on mux streams")
+
+// muxRequested reports whether a WS request asks for multiplexed streams
+func (s *Server) muxRequested(r *http.Request) bool {
+	return s.cfg.Mux.Enable && r.URL.Query().Get("mux") == "1"
+}
+
+// tunnelWSMux upgrades the request and serves multiplexed streams on it.
+// Each stream's target passes the same policy and pool as a WS tunnel.
+func (s *Server) tunnelWSMux(ctx context.Context, span trace.Span, w http.ResponseWriter, r *http.Request,
+	upgrader *websocket.Upgrader) {
+	_, upSpan := tracing.Start(ctx, "ws.upgrade")
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		upSpan.RecordError(err)
+		upSpan.SetStatus(codes.Error, "upgrade failed")
+		upSpan.End()
+		s.log.Error("WS upgrade error: %v", err)
+		return
+	}
+	upSpan.End()
+
+	cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(nil))
+	cfg.MuxWindow, cfg.MuxMaxStreams = s.cfg.Mux.Window, s.cfg.Mux.MaxStreams
+	cfg.Dial = reasonDial(func(target string) (net.Conn, error) {
+		if _, ok := udpTarget(target); ok {
+			return nil, errcode.Wrap(errcode.ProtocolError, errMuxUDP)
+		}
+		addrs, err := s.authorizeTarget(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return s.dialTarget(ctx, target, addrs, s.clientKey(target, r.RemoteAddr, r.Header), nil)
+	})
+	m := bridge.NewWSMux(ws, cfg)
+	defer m.Close()
+	m.Wg().Wait()
+	sent, received := m.Bytes()
+	span.SetAttributes(tracing.AttrBytesSent.Int64(sent), tracing.AttrBytesRecv.Int64(received))
+}
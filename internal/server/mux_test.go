@@ -0,0 +1,69 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+)
+
+// TestMuxSlowStream stalls one stream: the server sends it one window only
+// and other streams keep moving
+func TestMuxSlowStream(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	c, err := muxDial(ctx, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// never read from slow
+	slow, err := c.open(env.echoAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go slow.Write(randomPayload(8 * selfTestMuxWindow))
+	for slow.buffered() < selfTestMuxWindow {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("slow stream got %d bytes, want a full window", slow.buffered())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	fast, err := c.open(env.echoAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+	if err := tunnelEcho(fast, randomPayload(4*selfTestMuxWindow)); err != nil {
+		t.Fatalf("fast stream behind a stalled one: %v", err)
+	}
+	if n := slow.buffered(); n != selfTestMuxWindow {
+		t.Fatalf("server sent %d bytes on a stalled stream, window is %d", n, selfTestMuxWindow)
+	}
+}
+
+func TestMuxACLDeny(t *testing.T) {
+	_, env := startTestServer(t)
+	c, err := muxDial(testContext(t), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	st, err := c.open(env.deniedAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Errorf("denied stream carried %d bytes", len(got))
+	}
+	if r := st.closeReason(); r != errcode.PolicyDenied {
+		t.Errorf("denied stream closed with %q", r)
+	}
+}
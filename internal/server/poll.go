@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/policy"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	pollKeepalive     = 15 * time.Second // idle GET: SSE comment, or long-poll 204
+	pollDefaultResume = 30 * time.Second
+	pollOffsetHeader  = "X-Anylink-Offset"
+)
+
+// pollSession is an open fallback tunnel. One GET reads it at a time; a
+// new GET (a resume) ends the previous one.
+type pollSession struct {
+	conn   *bridge.PollConn
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// attach makes ctx's request the session's reader
+func (ps *pollSession) attach(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	ps.mu.Lock()
+	if ps.cancel != nil {
+		ps.cancel()
+	}
+	ps.cancel = cancel
+	ps.mu.Unlock()
+	return ctx, cancel
+}
+
+func (ps *pollSession) close() {
+	ps.conn.Close()
+	ps.mu.Lock()
+	if ps.cancel != nil {
+		ps.cancel()
+	}
+	ps.mu.Unlock()
+}
+
+// pollResume is how long a session waits for its client to come back
+func (s *Server) pollResume() time.Duration {
+	if w := s.cfg.Poll.ResumeWindow; w > 0 {
+		return w
+	}
+	return pollDefaultResume
+}
+
+func (s *Server) pollPath() string {
+	if p := strings.TrimSuffix(s.cfg.Poll.Path, "/"); p != "" {
+		return p
+	}
+	return "/poll"
+}
+
+// registerPoll adds the fallback transport endpoints to mux:
+//
+//	POST   {path}?target=host:port  open a session, 201 {"session": id}
+//	GET    {path}/{id}?offset=n     downstream from byte n (SSE, chunked or ?poll=1)
+//	POST   {path}/{id}?seq=n&ack=m  upstream body n, applied in seq order;
+//	                                downstream bytes before m received
+//	DELETE {path}/{id}              close the session
+func (s *Server) registerPoll(mux *http.ServeMux) {
+	p := s.pollPath()
+	mux.HandleFunc("POST "+p, s.handlePollOpen)
+	mux.HandleFunc("GET "+p+"/{id}", s.handlePollDown)
+	mux.HandleFunc("POST "+p+"/{id}", s.handlePollUp)
+	mux.HandleFunc("DELETE "+p+"/{id}", s.handlePollClose)
+}
+
+// handlePollOpen authorizes and dials the target like a WS tunnel, then
+// bridges it to a new session that outlives this request
+func (s *Server) handlePollOpen(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(r.Context(), r.Header), "poll.tunnel",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrTransport.String("poll")))
+	defer span.End()
+
+	if s.cfg.RoutesOnly {
+		span.SetStatus(codes.Error, "no such route")
+		http.NotFound(w, r)
+		return
+	}
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		span.SetStatus(codes.Error, "missing target")
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return
+	}
+	span.SetAttributes(tracing.AttrTarget.String(target))
+	if _, ok := udpTarget(target); ok {
+		span.SetStatus(codes.Error, "udp target")
+		http.Error(w, "UDP targets are not supported over HTTP fallback", http.StatusBadRequest)
+		return
+	}
+	addrs, err := s.authorizeTarget(ctx, target)
+	if err != nil {
+		status, msg := http.StatusForbidden, "target not allowed"
+		if !errors.Is(err, policy.ErrDenied) {
+			status, msg = http.StatusBadGateway, "cannot resolve target"
+		}
+		s.log.Debug("refusing %s: %v", target, err)
+		span.SetStatus(codes.Error, msg)
+		http.Error(w, msg, status)
+		return
+	}
+	backend, err := s.dialTarget(ctx, target, addrs, s.clientKey(target, r.RemoteAddr, r.Header), nil)
+	if err != nil {
+		span.SetStatus(codes.Error, "connect failed")
+		s.log.Error("dial %s: %v", target, err)
+		http.Error(w, "connect failed", http.StatusBadGateway)
+		return
+	}
+	rec, err := s.tap(target, "poll", r.RemoteAddr, backend.RemoteAddr(), nil)
+	if err != nil {
+		backend.Close()
+		span.SetStatus(codes.Error, "recording failed")
+		s.log.Error("%v", err)
+		http.Error(w, "recording failed", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := newPollID()
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	ps := &pollSession{conn: bridge.NewPollConn(local, remote, s.cfg.Poll.BufferSize)}
+	s.pollsMu.Lock()
+	s.polls[id] = ps
+	s.pollsMu.Unlock()
+
+	bctx := trace.ContextWithSpan(context.Background(), span)
+	go func() {
+		_, bSpan := tracing.Start(bctx, "bridge", tracing.AttrTransport.String("poll"), tracing.AttrTarget.String(target))
+		cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(nil))
+		cfg.Recorder = rec
+		b := bridge.NewPollBridge(ps.conn, backend, cfg)
+		b.Wg().Wait()
+		endBridgeSpan(bSpan, b)
+		b.Close()
+	}()
+	s.log.Debug("poll session %s to %s", id, target)
+	writeJSON(w, http.StatusCreated, map[string]string{"session": id})
+}
+
+// handlePollDown streams downstream bytes from ?offset= (or SSE's
+// Last-Event-ID) on. The offset tells the server what the client already
+// has, so a reconnecting client loses nothing. Three framings:
+//
+//	Accept: text/event-stream  SSE, one base64 event per chunk, id = next offset
+//	?poll=1                    long poll: one chunk, 204 after a quiet period
+//	otherwise                  raw bytes, chunked, until the session ends
+func (s *Server) handlePollDown(w http.ResponseWriter, r *http.Request) {
+	ps := s.pollSession(r.PathValue("id"))
+	if ps == nil {
+		http.NotFound(w, r)
+		return
+	}
+	off, err := pollOffset(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// asking for off confirms everything before it
+	if err := ps.conn.Ack(off); err != nil {
+		http.Error(w, "offset not buffered", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	ctx, detach := ps.attach(r.Context())
+	defer detach()
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	longPoll := r.URL.Query().Get("poll") == "1"
+	rc := http.NewResponseController(w)
+	if !longPoll {
+		startPollStream(w, off, sse)
+		_ = rc.Flush()
+	}
+	// an attached GET must keep the session inside the resume window
+	keepalive := min(pollKeepalive, s.pollResume()/3)
+	for {
+		wait, cancel := context.WithTimeout(ctx, keepalive)
+		data, err := ps.conn.Next(wait, off)
+		cancel()
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			ps.conn.Touch()
+			if longPoll {
+				w.Header().Set(pollOffsetHeader, strconv.FormatUint(off, 10))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if sse {
+				_, _ = io.WriteString(w, ": keepalive\n\n")
+				_ = rc.Flush()
+			}
+			continue
+		case errors.Is(err, io.EOF):
+			// closed and fully delivered; a raw stream just ends cleanly
+			s.removePoll(r.PathValue("id"))
+			if longPoll {
+				http.Error(w, "session closed", http.StatusGone)
+			} else if sse {
+				_, _ = io.WriteString(w, "event: close\ndata:\n\n")
+			}
+			return
+		case err != nil:
+			// replaced by a newer GET, the client left, or the client
+			// acknowledged past this stream
+			return
+		}
+
+		if longPoll {
+			w.Header().Set(pollOffsetHeader, strconv.FormatUint(off, 10))
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Cache-Control", "no-store")
+			_, _ = w.Write(data)
+			return
+		}
+		off += uint64(len(data))
+		if sse {
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", off, base64.StdEncoding.EncodeToString(data))
+		} else {
+			_, err = w.Write(data)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// startPollStream writes the headers of a streamed GET starting at off
+func startPollStream(w http.ResponseWriter, off uint64, sse bool) {
+	h := w.Header()
+	h.Set(pollOffsetHeader, strconv.FormatUint(off, 10))
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no") // ask nginx-style proxies not to buffer
+	if sse {
+		h.Set("Content-Type", "text/event-stream")
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePollUp queues one upstream body. ?ack=n confirms downstream bytes
+// received on a stream so the server can release them; an empty body is
+// an unsequenced keepalive or ack.
+func (s *Server) handlePollUp(w http.ResponseWriter, r *http.Request) {
+	ps := s.pollSession(r.PathValue("id"))
+	if ps == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if v := r.URL.Query().Get("ack"); v != "" {
+		off, err := strconv.ParseUint(v, 10, 64)
+		if err == nil {
+			err = ps.conn.Ack(off)
+		}
+		if err != nil {
+			http.Error(w, "invalid ack", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := s.cfg.Poll.BufferSize
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(limit)))
+	if err != nil {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var seq uint64
+	if len(data) > 0 {
+		if seq, err = strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64); err != nil {
+			http.Error(w, "missing or invalid seq", http.StatusBadRequest)
+			return
+		}
+	}
+	switch err := ps.conn.Push(seq, data); {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, bridge.ErrPollSeq):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, bridge.ErrPollFull):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "session closed", http.StatusGone)
+	}
+}
+
+func (s *Server) handlePollClose(w http.ResponseWriter, r *http.Request) {
+	ps := s.removePoll(r.PathValue("id"))
+	if ps == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ps.close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) pollSession(id string) *pollSession {
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+	return s.polls[id]
+}
+
+func (s *Server) removePoll(id string) *pollSession {
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+	ps := s.polls[id]
+	delete(s.polls, id)
+	return ps
+}
+
+// pollSweepLoop closes sessions whose client has not been seen within the
+// resume window, e.g. after a GET dropped and never came back
+func (s *Server) pollSweepLoop() {
+	window := s.pollResume()
+	ticker := time.NewTicker(window / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.pollsMu.Lock()
+		for id, ps := range s.polls {
+			if time.Since(ps.conn.LastSeen()) > window {
+				s.log.Debug("closing abandoned poll session %s", id)
+				ps.close()
+				delete(s.polls, id)
+			}
+		}
+		s.pollsMu.Unlock()
+	}
+}
+
+// closePolls ends every session, including their streamed GETs
+func (s *Server) closePolls() {
+	s.pollsMu.Lock()
+	defer s.pollsMu.Unlock()
+	for id, ps := range s.polls {
+		ps.close()
+		delete(s.polls, id)
+	}
+}
+
+// pollOffset reads the resume offset from ?offset= or Last-Event-ID
+func pollOffset(r *http.Request) (uint64, error) {
+	v := r.URL.Query().Get("offset")
+	if v == "" {
+		v = r.Header.Get("Last-Event-ID")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	off, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid offset")
+	}
+	return off, nil
+}
+
+// newPollID returns a random session ID; it is the only credential for
+// the session, so it must be unguessable
+func newPollID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
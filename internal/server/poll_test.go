@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestPollResumeAndReorder(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	id, err := pollOpen(ctx, env, env.echoAddr, http.StatusCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pollDelete(env, id)
+	// out of order, plus a retried duplicate
+	a, b := randomPayload(1000), randomPayload(1000)
+	for _, p := range []struct {
+		seq  int
+		data []byte
+	}{{1, b}, {0, a}, {0, a}} {
+		if err := pollSend(ctx, env, id, p.seq, p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := append(a, b...)
+
+	// read half, drop the GET, resume from the offset received
+	first, err := pollStream(ctx, env, id, 0, len(want)/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := pollStream(ctx, env, id, len(first), len(want)-len(first))
+	if err != nil {
+		t.Fatalf("resume at %d: %v", len(first), err)
+	}
+	if got := append(first, rest...); !bytes.Equal(got, want) {
+		t.Fatal("resumed stream mismatch")
+	}
+}
+
+func TestPollLongPoll(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	id, err := pollOpen(ctx, env, env.echoAddr, http.StatusCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := randomPayload(4096)
+	if err := pollSend(ctx, env, id, 0, payload); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for len(got) < len(payload) {
+		u := fmt.Sprintf("%s/poll/%s?poll=1&offset=%d", env.httpURL, id, len(got))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("long poll status %d", resp.StatusCode)
+		}
+		got = append(got, body...)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("long-poll echo mismatch")
+	}
+	if err := pollDelete(env, id); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, env.httpURL+"/poll/"+id+"?poll=1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("closed session: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPollACLDeny(t *testing.T) {
+	_, env := startTestServer(t)
+	if _, err := pollOpen(testContext(t), env, env.deniedAddr, http.StatusForbidden); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pollStream reads n raw bytes from a streamed GET starting at off, then
+// drops the connection
+func pollStream(ctx context.Context, env *selfTestEnv, id string, off, n int) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	u := fmt.Sprintf("%s/poll/%s?offset=%d", env.httpURL, id, off)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET status %d", resp.StatusCode)
+	}
+	if h := resp.Header.Get(pollOffsetHeader); h != strconv.Itoa(off) {
+		return nil, fmt.Errorf("stream starts at offset %s, want %d", h, off)
+	}
+	got := make([]byte, n)
+	if _, err := io.ReadFull(resp.Body, got); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
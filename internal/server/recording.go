@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/DanielcoderX/anylink/internal/recording"
+)
+
+// openRecordings checks the configured recording formats and opens the
+// store when any route or target is recorded
+func (s *Server) openRecordings() error {
+	rc := s.cfg.Recording
+	wanted := false
+	for target, f := range rc.Targets {
+		if _, err := recording.ParseFormat(f); err != nil {
+			return fmt.Errorf("recording target %s: %v", target, err)
+		}
+		wanted = true
+	}
+	for path, rt := range s.cfg.Routes {
+		if rt.Record == "" {
+			continue
+		}
+		if _, err := recording.ParseFormat(rt.Record); err != nil {
+			return fmt.Errorf("route %s: %v", path, err)
+		}
+		wanted = true
+	}
+	if !wanted {
+		return nil
+	}
+	if rc.Dir == "" {
+		return fmt.Errorf("recording.dir is required to record sessions")
+	}
+	store, err := recording.NewStore(rc.Dir, recording.Options{
+		MaxSize:  int64(rc.MaxSize),
+		MaxTotal: int64(rc.MaxTotal),
+		Cols:     rc.Cols,
+		Rows:     rc.Rows,
+	})
+	if err != nil {
+		return fmt.Errorf("recording: %w", err)
+	}
+	s.records = store
+	s.recordTargets = newTargetIndex(slices.Collect(maps.Keys(rc.Targets)), s.cfg.Services, s.policy.Resolve)
+	return nil
+}
+
+// recorder starts a recording of a tunnel to target, whose backend
+// connection goes to backend, when its route (nil for client-chosen
+// targets) or recording.targets asks for one. It returns a nil interface
+// otherwise. A recording that cannot be created is an error wrapped with
+// errcode.RecordFailed: the tunnel must not run unrecorded.
+func (s *Server) recorder(target, transport, client string, backend net.Addr, route *config.RouteConfig) (bridge.Recorder, error) {
+	if s.records == nil {
+		return nil, nil
+	}
+	var format string
+	if key, ok := s.recordTargets.match(target, backend); ok {
+		format = s.cfg.Recording.Targets[key]
+	}
+	if route != nil && route.Record != "" {
+		format = route.Record
+	}
+	if format == "" {
+		return nil, nil
+	}
+	rec, err := s.records.Open(recording.Format(format), recording.Meta{
+		Target:    target,
+		Transport: transport,
+		Client:    client,
+	})
+	if err != nil {
+		return nil, errcode.Wrap(errcode.RecordFailed, fmt.Errorf("recording %s: %w", target, err))
+	}
+	s.log.Debug("recording %s to %s", target, rec.Path())
+	return rec, nil
+}
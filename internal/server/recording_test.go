@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/DanielcoderX/anylink/internal/recording"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// testRecordRoute records its tunnels as asciicast
+const testRecordRoute = "/rec"
+
+func TestRecordingWSRoute(t *testing.T) {
+	dir := t.TempDir()
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Recording = config.RecordingConfig{Dir: dir}
+		cfg.Routes[testRecordRoute] = config.RouteConfig{Target: cfg.Routes[selfTestRoute].Target, Record: string(recording.Asciicast)}
+	})
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+testRecordRoute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// split a multi-byte rune across frames: the recording must still hold
+	// valid UTF-8
+	text := []byte("ls -l ✓\r\n")
+	cut := bytes.IndexRune(text, '✓') + 1
+	for _, p := range [][]byte{text[:cut], text[cut:]} {
+		if err := wsEchoConn(ctx, ws, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = expectRecording(ctx, dir, func(r *recording.Reader, in, out []byte, _ bool) error {
+		if r.Format != recording.Asciicast || r.Meta.Target != env.echoAddr || r.Meta.Transport != "ws" {
+			return fmt.Errorf("header: %s recording of %q via %q", r.Format, r.Meta.Target, r.Meta.Transport)
+		}
+		if !bytes.Equal(in, text) || !bytes.Equal(out, text) {
+			return fmt.Errorf("recorded input %q, output %q, want %q", in, out, text)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordingQUICMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	const maxSize = 4096
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Recording = config.RecordingConfig{
+			Dir:     dir,
+			Targets: map[string]string{cfg.Routes[selfTestRoute].Target: string(recording.Raw)},
+			MaxSize: maxSize,
+		}
+	})
+	ctx := testContext(t)
+	payload := randomPayload(2 * maxSize)
+	if err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		return quicEcho(ctx, conn, env.echoAddr, payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err := expectRecording(ctx, dir, func(r *recording.Reader, in, out []byte, truncated bool) error {
+		if r.Format != recording.Raw || r.Meta.Transport != "quic" {
+			return fmt.Errorf("header: %s recording via %q", r.Format, r.Meta.Transport)
+		}
+		if !truncated || len(in)+len(out) != maxSize {
+			return fmt.Errorf("recorded %d bytes (truncated %v), want %d", len(in)+len(out), truncated, maxSize)
+		}
+		if !bytes.HasPrefix(payload, in) || !bytes.HasPrefix(payload, out) {
+			return fmt.Errorf("recorded data differs from the tunnel's")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecordingTargetSpelling reaches a recorded target under another
+// spelling than its recording.targets key: it must still be recorded
+func TestRecordingTargetSpelling(t *testing.T) {
+	env := newTestEnv(t)
+	_, port, _ := net.SplitHostPort(env.echoAddr)
+	for _, tc := range []struct {
+		name, key, target string
+	}{
+		{"mapped IP", env.echoAddr, net.JoinHostPort("::ffff:127.0.0.1", port)},
+		{"name key", "LocalHost.:" + port, env.echoAddr},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, env := startTestServer(t, func(cfg *config.Config) {
+				cfg.Recording = config.RecordingConfig{Dir: dir, Targets: map[string]string{tc.key: string(recording.Raw)}}
+				cfg.AllowedTargets = append(cfg.AllowedTargets, tc.target)
+			})
+			ctx := testContext(t)
+			if err := withQUIC(ctx, env, func(conn quic.Connection) error {
+				return quicEcho(ctx, conn, tc.target, []byte("recorded"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+			err := expectRecording(ctx, dir, func(r *recording.Reader, in, out []byte, _ bool) error {
+				if r.Meta.Target != tc.target || string(in) != "recorded" {
+					return fmt.Errorf("recorded %q to %q", in, r.Meta.Target)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestRecordingOpenFailure removes the recording dir: recorded tunnels must
+// be refused rather than run unrecorded
+func TestRecordingOpenFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rec")
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Recording = config.RecordingConfig{Dir: dir}
+		cfg.Routes[testRecordRoute] = config.RouteConfig{Target: cfg.Routes[selfTestRoute].Target, Record: string(recording.Raw)}
+		cfg.Recording.Targets = map[string]string{cfg.Routes[selfTestRoute].Target: string(recording.Raw)}
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	ctx := testContext(t)
+
+	t.Run("ws", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+testRecordRoute, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		if err := wsExpectCode(ctx, ws, errcode.RecordFailed); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("quic", func(t *testing.T) {
+		err := withQUIC(ctx, env, func(conn quic.Connection) error {
+			return quicExpectCode(ctx, conn, env.echoAddr, errcode.RecordFailed)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("connect", func(t *testing.T) {
+		if _, _, err := connectHTTP1(ctx, env, env.echoAddr, "Bearer "+selfTestToken, http.StatusServiceUnavailable); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// expectRecording waits for the single recording in dir to satisfy check,
+// which sees the concatenated input and output and whether it was
+// truncated. Events are written as they happen, so this polls rather than
+// waiting for the tunnel to end.
+func expectRecording(ctx context.Context, dir string, check func(r *recording.Reader, in, out []byte, truncated bool) error) error {
+	for {
+		err := readRecording(dir, check)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func readRecording(dir string, check func(r *recording.Reader, in, out []byte, truncated bool) error) error {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		return fmt.Errorf("%d recordings, want 1", len(files))
+	}
+	r, err := recording.Open(files[0])
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var in, out []byte
+	truncated := false
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch ev.Type {
+		case 'i':
+			in = append(in, ev.Data...)
+		case 'o':
+			out = append(out, ev.Data...)
+		case 'm':
+			truncated = true
+		}
+	}
+	return check(r, in, out, truncated)
+}
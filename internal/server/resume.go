@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// resumeToken returns the token of a WS reattach request
+// (?resume=<token>&offset=<n>); ?resume=new is an ordinary tunnel
+func (s *Server) resumeToken(r *http.Request) (string, bool) {
+	token := r.URL.Query().Get("resume")
+	if s.resume == nil || token == "" || token == "new" {
+		return "", false
+	}
+	return token, true
+}
+
+// resumeWS reattaches a WS client to its tunnel on rt (nil for "/"); a
+// token opened on another path is unknown here. The offset is checked
+// before upgrading so a lost tunnel is an HTTP error the client can act on.
+func (s *Server) resumeWS(ctx context.Context, span trace.Span, w http.ResponseWriter, r *http.Request,
+	upgrader *websocket.Upgrader, token string, rt *route) {
+	span.SetAttributes(tracing.AttrResume.String("attach"))
+	offset, err := strconv.ParseUint(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		span.SetStatus(codes.Error, "bad offset")
+		http.Error(w, "missing or invalid offset", http.StatusBadRequest)
+		return
+	}
+	att, err := s.resume.Attach(token, rt.key(), offset)
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, bridge.ErrResumeOffset) {
+			status = http.StatusRequestedRangeNotSatisfiable
+		}
+		s.log.Debug("refusing resume: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, err.Error(), status)
+		return
+	}
+	span.SetAttributes(tracing.AttrTarget.String(att.Target()))
+
+	_, upSpan := tracing.Start(ctx, "ws.upgrade")
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		upSpan.RecordError(err)
+		upSpan.SetStatus(codes.Error, "upgrade failed")
+		upSpan.End()
+		s.log.Error("WS upgrade error: %v", err)
+		att.Close()
+		return
+	}
+	upSpan.End()
+	defer ws.Close()
+
+	// a reattached tunnel whose recording fails stays detached and resumable
+	rec, err := s.tap(att.Target(), "ws", ws.RemoteAddr().String(), att.RemoteAddr(), rt.config())
+	if err != nil {
+		att.Close()
+		span.SetStatus(codes.Error, "recording failed")
+		s.log.Error("%v", err)
+		closeWS(ws, closeReason(err))
+		return
+	}
+	if err := bridge.WriteWSFrame(ws, 0, []byte(att.Line())); err != nil {
+		rec.Close()
+		att.Close()
+		return
+	}
+	s.bridgeWS(ctx, ws, att, rec, att.Target(), rt.config(), r.URL.Query().Get("halfclose") == "1")
+}
+
+// dialResume handles a QUIC or WebTransport stream whose first line is a
+// resume handshake; ok is false for an ordinary target. The reply line
+// goes to the stream before any backend bytes.
+func (s *Server) dialResume(span trace.Span, line string, reply io.Writer, dial func(target string) (net.Conn, error)) (c net.Conn, ok bool, err error) {
+	token, target, offset, ok, err := bridge.ParseResumeLine(line)
+	if !ok {
+		return nil, false, nil
+	}
+	if err == nil && s.resume == nil {
+		err = errors.New("resume is not enabled")
+	}
+	var att *bridge.Attachment
+	if err == nil && token == "" {
+		span.SetAttributes(tracing.AttrResume.String("new"), tracing.AttrTarget.String(target))
+		var backend net.Conn
+		if backend, err = dial(target); err == nil {
+			att, err = s.resume.New(target, "", backend)
+		}
+	} else if err == nil {
+		span.SetAttributes(tracing.AttrResume.String("attach"))
+		if att, err = s.resume.Attach(token, "", offset); err == nil {
+			span.SetAttributes(tracing.AttrTarget.String(att.Target()))
+		}
+	}
+	if err != nil {
+		// best effort: tells the client why before the stream closes
+		_, _ = io.WriteString(reply, "resume failed "+err.Error()+"\n")
+		return nil, true, err
+	}
+	if _, err := io.WriteString(reply, att.Line()+"\n"); err != nil {
+		att.Close()
+		return nil, true, err
+	}
+	return att, true, nil
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+func TestResumeWSReattach(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	var token string
+	err := resumeRoundTrip(func(tok string, offset int) (io.ReadWriteCloser, string, error) {
+		u := env.wsURL + "/" + env.echoAddr + "?resume=new"
+		if tok != "new" {
+			u = fmt.Sprintf("%s/?resume=%s&offset=%d", env.wsURL, tok, offset)
+		}
+		token = tok
+		return wsResumeDial(ctx, u)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tunnel is waiting again; an offset it cannot replay is refused
+	u := fmt.Sprintf("%s/?resume=%s&offset=%d", env.wsURL, token, 1<<40)
+	if err := wsExpectStatus(ctx, websocket.DefaultDialer, u, nil, http.StatusRequestedRangeNotSatisfiable); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeQUICReattach(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	err := resumeRoundTrip(func(tok string, offset int) (io.ReadWriteCloser, string, error) {
+		line := "resume new " + env.echoAddr
+		if tok != "new" {
+			line = fmt.Sprintf("resume %s %d", tok, offset)
+		}
+		return quicResumeDial(ctx, env, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeUnknownToken(t *testing.T) {
+	_, env := startTestServer(t)
+	if err := wsExpectStatus(testContext(t), websocket.DefaultDialer, env.wsURL+"/?resume=0123&offset=0", nil, http.StatusNotFound); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resumeRoute is a route without auth for the route binding tests
+const resumeRoute = "/resume"
+
+func withResumeRoute(routesOnly bool) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Routes[resumeRoute] = config.RouteConfig{Target: cfg.Routes[selfTestRoute].Target}
+		cfg.RoutesOnly = routesOnly
+	}
+}
+
+// TestResumeWrongRoute checks that a token only reattaches on the path it
+// was opened on, and that a refused attempt leaves the tunnel running
+func TestResumeWrongRoute(t *testing.T) {
+	_, env := startTestServer(t, withResumeRoute(false))
+	ctx := testContext(t)
+
+	rw, line, err := wsResumeDial(ctx, env.wsURL+resumeRoute+"?resume=new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+	token, _, err := parseResumeReply(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", selfTestIdleRoute} {
+		u := fmt.Sprintf("%s%s?resume=%s&offset=0", env.wsURL, path, token)
+		if err := wsExpectStatus(ctx, websocket.DefaultDialer, u, nil, http.StatusNotFound); err != nil {
+			t.Errorf("route token on %s: %v", path, err)
+		}
+	}
+	if err := resumeEcho(rw); err != nil {
+		t.Fatalf("tunnel after refused resumes: %v", err)
+	}
+
+	rw2, line, err := wsResumeDial(ctx, env.wsURL+"/"+env.echoAddr+"?resume=new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw2.Close()
+	token, _, err = parseResumeReply(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := fmt.Sprintf("%s%s?resume=%s&offset=0", env.wsURL, resumeRoute, token)
+	if err := wsExpectStatus(ctx, websocket.DefaultDialer, u, nil, http.StatusNotFound); err != nil {
+		t.Errorf("client-chosen token on %s: %v", resumeRoute, err)
+	}
+}
+
+// TestResumeRoutesOnly resumes a route tunnel with routes_only set: the
+// route reattaches, "/" refuses the token like any other request
+func TestResumeRoutesOnly(t *testing.T) {
+	_, env := startTestServer(t, withResumeRoute(true))
+	ctx := testContext(t)
+
+	err := resumeRoundTrip(func(tok string, offset int) (io.ReadWriteCloser, string, error) {
+		if tok == "new" {
+			return wsResumeDial(ctx, env.wsURL+resumeRoute+"?resume=new")
+		}
+		u := fmt.Sprintf("%s/?resume=%s&offset=%d", env.wsURL, tok, offset)
+		if err := wsExpectStatus(ctx, websocket.DefaultDialer, u, nil, http.StatusNotFound); err != nil {
+			return nil, "", fmt.Errorf("resume on /: %v", err)
+		}
+		return wsResumeDial(ctx, fmt.Sprintf("%s%s?resume=%s&offset=%d", env.wsURL, resumeRoute, tok, offset))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resumeEcho checks that rw still echoes
+func resumeEcho(rw io.ReadWriter) error {
+	payload := randomPayload(1024)
+	if _, err := rw.Write(payload); err != nil {
+		return err
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(rw, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("echo mismatch")
+	}
+	return nil
+}
+
+// resumeRoundTrip opens a resumable echo tunnel, reads half the echo, drops
+// the transport and reattaches with the received offset. The rest of the
+// echo must arrive exactly once, with the client resending only what the
+// backend never got.
+func resumeRoundTrip(open func(token string, offset int) (io.ReadWriteCloser, string, error)) error {
+	rw, line, err := open("new", 0)
+	if err != nil {
+		return err
+	}
+	token, received, err := parseResumeReply(line)
+	if err != nil {
+		rw.Close()
+		return err
+	}
+	if received != 0 {
+		rw.Close()
+		return fmt.Errorf("new tunnel reports %d bytes received", received)
+	}
+	payload := randomPayload(8192)
+	if _, err := rw.Write(payload); err != nil {
+		rw.Close()
+		return fmt.Errorf("write: %v", err)
+	}
+	first := make([]byte, len(payload)/2)
+	_, err = io.ReadFull(rw, first)
+	rw.Close() // dropped without a goodbye
+	if err != nil {
+		return fmt.Errorf("read: %v", err)
+	}
+
+	rw, line, err = open(token, len(first))
+	if err != nil {
+		return fmt.Errorf("reattach: %v", err)
+	}
+	defer rw.Close()
+	tok, received, err := parseResumeReply(line)
+	if err != nil {
+		return err
+	}
+	if tok != token || received > len(payload) {
+		return fmt.Errorf("reattach reply %q", line)
+	}
+	werr := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(payload[received:])
+		werr <- err
+	}()
+	rest := make([]byte, len(payload)-len(first))
+	if _, err := io.ReadFull(rw, rest); err != nil {
+		return fmt.Errorf("read after reattach: %v", err)
+	}
+	if err := <-werr; err != nil {
+		return fmt.Errorf("resend: %v", err)
+	}
+	if !bytes.Equal(append(first, rest...), payload) {
+		return fmt.Errorf("resumed echo mismatch")
+	}
+	return nil
+}
+
+// parseResumeReply parses the server's "resume <token> <received>"
+func parseResumeReply(line string) (token string, received int, err error) {
+	if _, err := fmt.Sscanf(strings.TrimSpace(line), "resume %s %d", &token, &received); err != nil {
+		return "", 0, fmt.Errorf("resume reply %q: %v", line, err)
+	}
+	return token, received, nil
+}
+
+// wsResumeDial upgrades url and returns the tunnel with the reply from the
+// streamID-0 control frame
+func wsResumeDial(ctx context.Context, url string) (io.ReadWriteCloser, string, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("WS dial: %v", err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = ws.SetReadDeadline(dl)
+		_ = ws.SetWriteDeadline(dl)
+	}
+	_, rdr, err := ws.NextReader()
+	if err == nil {
+		var streamID uint32
+		var data []byte
+		if streamID, data, err = bridge.ReadWSFrame(rdr); err == nil && streamID == 0 {
+			return &wsStream{ws: ws}, string(data), nil
+		}
+	}
+	ws.Close()
+	return nil, "", fmt.Errorf("no resume control frame: %v", err)
+}
+
+// quicResumeDial sends line on a stream of a fresh QUIC connection and
+// returns the stream with the reply line. Close drops the connection.
+func quicResumeDial(ctx context.Context, env *selfTestEnv, line string) (io.ReadWriteCloser, string, error) {
+	conn, err := quic.DialAddr(ctx, env.quicAddr,
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{"anylink-quic"}}, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("QUIC dial: %v", err)
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, "", fmt.Errorf("QUIC stream: %v", err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+	br := bufio.NewReader(stream)
+	reply := ""
+	if _, err = io.WriteString(stream, line+"\n"); err == nil {
+		reply, err = br.ReadString('\n')
+	}
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, "", fmt.Errorf("resume handshake: %v", err)
+	}
+	return &quicStream{Reader: br, stream: stream, conn: conn}, reply, nil
+}
+
+type quicStream struct {
+	io.Reader
+	stream quic.Stream
+	conn   quic.Connection
+}
+
+func (s *quicStream) Write(p []byte) (int, error) { return s.stream.Write(p) }
+func (s *quicStream) Close() error                { return s.conn.CloseWithError(1, "dropped") }
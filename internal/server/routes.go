@@ -0,0 +1,160 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// reservedPaths are served by the server itself
+var reservedPaths = []string{"/", "/healthz", "/readyz"}
+
+// route is a compiled RouteConfig
+type route struct {
+	path     string
+	cfg      config.RouteConfig
+	upgrader websocket.Upgrader
+}
+
+// routeHandlers compiles cfg.Routes into handlers keyed by path. Route
+// targets are operator-defined, like services, so they bypass the target
+// policy; clients cannot influence where a route connects.
+func (s *Server) routeHandlers() (map[string]http.Handler, error) {
+	out := make(map[string]http.Handler, len(s.cfg.Routes))
+	for path, rc := range s.cfg.Routes {
+		if !strings.HasPrefix(path, "/") || slices.Contains(reservedPaths, path) {
+			return nil, fmt.Errorf("route %q: path must start with / and not be one of %v", path, reservedPaths)
+		}
+		if s.cfg.Poll.Enable && (path == s.pollPath() || strings.HasPrefix(path, s.pollPath()+"/")) {
+			return nil, fmt.Errorf("route %q: path is used by the HTTP fallback (poll.path)", path)
+		}
+		if err := s.checkFixedTarget(rc.Target); err != nil {
+			return nil, fmt.Errorf("route %s: %w", path, err)
+		}
+		rt := &route{path: path, cfg: rc}
+		rt.upgrader = websocket.Upgrader{
+			ReadBufferSize:   8192,
+			WriteBufferSize:  8192,
+			HandshakeTimeout: rc.HandshakeTimeout,
+			Subprotocols:     rc.Subprotocols,
+			CheckOrigin:      rt.checkOrigin,
+		}
+		out[path] = s.serveRoute(rt)
+	}
+	return out, nil
+}
+
+// checkFixedTarget validates an operator-chosen route target
+func (s *Server) checkFixedTarget(target string) error {
+	if hostport, ok := udpTarget(target); ok {
+		if _, _, err := net.SplitHostPort(hostport); err != nil {
+			return fmt.Errorf("target %q: UDP targets must be udp/host:port", target)
+		}
+		return nil
+	}
+	if _, isService := s.cfg.Services[target]; isService {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("target %q is neither host:port nor a service", target)
+	}
+	return nil
+}
+
+// key identifies rt in the resume table; "" is the client-chosen "/"
+func (rt *route) key() string {
+	if rt == nil {
+		return ""
+	}
+	return rt.path
+}
+
+// config returns rt's settings, nil for client-chosen targets
+func (rt *route) config() *config.RouteConfig {
+	if rt == nil {
+		return nil
+	}
+	return &rt.cfg
+}
+
+func (s *Server) serveRoute(rt *route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(tracing.Extract(r.Context(), r.Header), "ws.tunnel",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				tracing.AttrTransport.String("ws"),
+				tracing.AttrRoute.String(rt.path),
+				tracing.AttrTarget.String(rt.cfg.Target)))
+		defer span.End()
+
+		if !rt.authorized(r) {
+			span.SetStatus(codes.Error, "unauthorized")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="anylink"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if len(rt.cfg.Subprotocols) > 0 && !slices.ContainsFunc(websocket.Subprotocols(r), func(p string) bool {
+			return slices.Contains(rt.cfg.Subprotocols, p)
+		}) {
+			span.SetStatus(codes.Error, "unsupported subprotocol")
+			http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+			return
+		}
+		if token, ok := s.resumeToken(r); ok {
+			s.resumeWS(ctx, span, w, r, &rt.upgrader, token, rt)
+			return
+		}
+		if hostport, ok := udpTarget(rt.cfg.Target); ok {
+			s.tunnelWSUDP(ctx, span, w, r, &rt.upgrader, rt.cfg.Target, []string{hostport})
+			return
+		}
+		s.tunnelWS(ctx, span, w, r, &rt.upgrader, rt.cfg.Target, []string{rt.cfg.Target}, rt)
+	}
+}
+
+// authorized checks the bearer token from the Authorization header or,
+// for browsers that cannot set headers on WebSockets, ?access_token=
+func (rt *route) authorized(r *http.Request) bool {
+	if len(rt.cfg.AuthTokens) == 0 {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return false
+	}
+	valid := false
+	for _, t := range rt.cfg.AuthTokens {
+		// compare every token so timing does not reveal which one matched
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// checkOrigin admits the configured origins, or any when none are set
+func (rt *route) checkOrigin(r *http.Request) bool {
+	return originAllowed(rt.cfg.Origins, r)
+}
+
+func originAllowed(origins []string, r *http.Request) bool {
+	if len(origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	return slices.ContainsFunc(origins, func(o string) bool {
+		return strings.EqualFold(o, origin)
+	})
+}
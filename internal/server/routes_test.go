@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/webtransport-go"
+)
+
+func TestRouteAccessToken(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	h := http.Header{"Origin": {selfTestOrigin}}
+	ws, _, err := routeDialer.DialContext(ctx, env.wsURL+selfTestRoute+"?access_token="+selfTestToken, h)
+	if err != nil {
+		t.Fatalf("WS dial: %v", err)
+	}
+	defer ws.Close()
+	if err := wsEchoConn(ctx, ws, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouteAuth(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	url := env.wsURL + selfTestRoute
+
+	noToken := routeHeader()
+	noToken.Del("Authorization")
+	badToken := routeHeader()
+	badToken.Set("Authorization", "Bearer wrong")
+	badOrigin := routeHeader()
+	badOrigin.Set("Origin", "https://evil.example")
+	for _, tc := range []struct {
+		name   string
+		d      *websocket.Dialer
+		h      http.Header
+		status int
+	}{
+		{"without token", routeDialer, noToken, http.StatusUnauthorized},
+		{"wrong token", routeDialer, badToken, http.StatusUnauthorized},
+		{"foreign origin", routeDialer, badOrigin, http.StatusForbidden},
+		{"without subprotocol", websocket.DefaultDialer, routeHeader(), http.StatusBadRequest},
+	} {
+		if err := wsExpectStatus(ctx, tc.d, url, tc.h, tc.status); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+// startRoutesOnly runs a routes_only server: client-chosen targets must be
+// refused on every transport while routes keep working
+func startRoutesOnly(t *testing.T) *selfTestEnv {
+	t.Helper()
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.RoutesOnly = true
+	})
+	return env
+}
+
+func TestRoutesOnlyWS(t *testing.T) {
+	env := startRoutesOnly(t)
+	ctx := testContext(t)
+	if err := wsExpectStatus(ctx, websocket.DefaultDialer, env.wsURL+"/"+env.echoAddr, nil, http.StatusNotFound); err != nil {
+		t.Fatal(err)
+	}
+	ws, _, err := routeDialer.DialContext(ctx, env.wsURL+selfTestRoute, routeHeader())
+	if err != nil {
+		t.Fatalf("route: %v", err)
+	}
+	defer ws.Close()
+	if err := wsEchoConn(ctx, ws, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutesOnlyQUIC(t *testing.T) {
+	env := startRoutesOnly(t)
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		if err := quicExpectDenied(ctx, conn, env.echoAddr); err != nil {
+			return err
+		}
+		return quicExpectDenied(ctx, conn, selfTestService)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutesOnlyWebTransport(t *testing.T) {
+	env := startRoutesOnly(t)
+	ctx := testContext(t)
+	err := withWebTransport(ctx, env, func(sess *webtransport.Session) error {
+		stream, err := wtStream(ctx, sess)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		return streamExpectDenied(ctx, stream, env.echoAddr)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutesOnlyConnect(t *testing.T) {
+	env := startRoutesOnly(t)
+	if _, _, err := connectHTTP1(testContext(t), env, env.echoAddr, "Bearer "+selfTestToken, http.StatusForbidden); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutesOnlyPoll(t *testing.T) {
+	env := startRoutesOnly(t)
+	if _, err := pollOpen(testContext(t), env, env.echoAddr, http.StatusNotFound); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutesOnlyTCP(t *testing.T) {
+	env := startRoutesOnly(t)
+	ctx := testContext(t)
+	c, err := tcpDial(ctx, env.tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(env.echoAddr + "\nping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(selfTestTimeout))
+	if n, err := c.Read(make([]byte, 4)); !errors.Is(err, io.EOF) {
+		t.Fatalf("header target: read %d bytes, err %v; want the connection closed", n, err)
+	}
+
+	route, err := tcpDial(ctx, env.tcpRoute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer route.Close()
+	if err := connEcho(route, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+}
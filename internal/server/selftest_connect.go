@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
+)
+
+// connectChecks drive the CONNECT proxy mode over HTTP/1.1 and h2c
+var connectChecks = []selfTestCheck{
+	{"connect http1 echo", func(ctx context.Context, env *selfTestEnv) error {
+		c, br, err := connectHTTP1(ctx, env, env.echoAddr, "Bearer "+selfTestToken, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		return tunnelEcho(&bufferedConn{Conn: c, r: br}, randomPayload(selfTestLargeSize))
+	}},
+	{"connect h2c concurrent", func(ctx context.Context, env *selfTestEnv) error {
+		// all tunnels share one HTTP/2 connection
+		tr := h2cTransport()
+		defer tr.CloseIdleConnections()
+		return concurrently(selfTestConcurrent, func() error {
+			pr, pw := io.Pipe()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodConnect, env.httpURL, pr)
+			req.Host = env.echoAddr
+			req.Header.Set("Proxy-Authorization", basicAuth("selftest", selfTestToken))
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				pw.Close()
+				return fmt.Errorf("CONNECT: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				pw.Close()
+				return fmt.Errorf("status %d, want 200", resp.StatusCode)
+			}
+			if resp.ProtoMajor != 2 {
+				pw.Close()
+				return fmt.Errorf("negotiated %s, want HTTP/2", resp.Proto)
+			}
+			return tunnelEcho(&pipeConn{Reader: resp.Body, w: pw}, randomPayload(64*1024))
+		})
+	}},
+	{"ws over h2 (rfc 8441)", func(ctx context.Context, env *selfTestEnv) error {
+		// on by default through package xconnect; an operator may still
+		// turn it off
+		if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+			return skipError("disabled by GODEBUG")
+		}
+		return wsOverH2(ctx, env, env.echoAddr, http.StatusOK)
+	}},
+}
+
+// wsOverH2 sends an RFC 8441 extended CONNECT for a WS tunnel to target
+// and expects status; on 200 it runs a WS echo over the stream
+func wsOverH2(ctx context.Context, env *selfTestEnv, target string, status int) error {
+	// net/http refuses pseudo-headers on http URLs; x/net/http2 sends them
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return tcpDial(ctx, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodConnect, env.httpURL+"/"+target, pr)
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		pw.Close()
+		return fmt.Errorf("extended CONNECT: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		pw.Close()
+		return fmt.Errorf("status %d, want %d", resp.StatusCode, status)
+	}
+	if status != http.StatusOK {
+		pw.Close()
+		return nil
+	}
+	// run the WS client over the stream; it still does its HTTP/1.1
+	// handshake, which h2ClientConn answers locally
+	conn := &h2ClientConn{pipeConn: pipeConn{Reader: resp.Body, w: pw}}
+	d := &websocket.Dialer{NetDialContext: func(context.Context, string, string) (net.Conn, error) { return conn, nil }}
+	ws, _, err := d.DialContext(ctx, env.wsURL+"/"+target, nil)
+	if err != nil {
+		pw.Close()
+		return fmt.Errorf("WS over h2: %v", err)
+	}
+	defer ws.Close()
+	return wsEchoConn(ctx, ws, randomPayload(64*1024))
+}
+
+// connectHTTP1 sends CONNECT target over a fresh HTTP/1.1 connection and
+// expects status. On 200 the conn and its reader are returned for the
+// tunnel; otherwise the conn is closed.
+func connectHTTP1(ctx context.Context, env *selfTestEnv, target, auth string, status int) (net.Conn, *bufio.Reader, error) {
+	c, err := tcpDial(ctx, strings.TrimPrefix(env.httpURL, "http://"))
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = c.SetDeadline(time.Now().Add(selfTestTimeout))
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	if _, err := io.WriteString(c, req+"\r\n"); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("CONNECT response: %v", err)
+	}
+	if resp.StatusCode != status {
+		c.Close()
+		return nil, nil, fmt.Errorf("status %d, want %d", resp.StatusCode, status)
+	}
+	if status != http.StatusOK {
+		c.Close()
+		return nil, nil, nil
+	}
+	return c, br, nil
+}
+
+// tunnelEcho writes payload while reading the echo, so large payloads
+// cannot fill both directions
+func tunnelEcho(rw io.ReadWriter, payload []byte) error {
+	werr := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(payload)
+		werr <- err
+	}()
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(rw, got); err != nil {
+		return fmt.Errorf("read: %v", err)
+	}
+	if err := <-werr; err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("echo mismatch (%d bytes)", len(payload))
+	}
+	return nil
+}
+
+// h2cTransport speaks HTTP/2 with prior knowledge to plain-text listeners
+func h2cTransport() *http.Transport {
+	p := new(http.Protocols)
+	p.SetUnencryptedHTTP2(true)
+	return &http.Transport{Protocols: p}
+}
+
+func basicAuth(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// pipeConn is a net.Conn over an HTTP/2 CONNECT stream: the response body
+// and the request body pipe. Deadlines are left to the request context.
+type pipeConn struct {
+	io.Reader
+	w *io.PipeWriter
+}
+
+func (c *pipeConn) Write(p []byte) (int, error)      { return c.w.Write(p) }
+func (c *pipeConn) Close() error                     { return c.w.Close() }
+func (c *pipeConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (c *pipeConn) SetDeadline(time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(time.Time) error { return nil }
+
+// h2ClientConn answers the WS client's HTTP/1.1 upgrade request with a
+// local 101, the client-side mirror of h2UpgradeConn
+type h2ClientConn struct {
+	pipeConn
+	handshake bool
+	pending   bytes.Buffer
+}
+
+func (c *h2ClientConn) Write(p []byte) (int, error) {
+	if c.handshake {
+		return c.pipeConn.Write(p)
+	}
+	c.handshake = true
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil {
+		return 0, err
+	}
+	sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	fmt.Fprintf(&c.pending, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	return len(p), nil
+}
+
+func (c *h2ClientConn) Read(p []byte) (int, error) {
+	if c.pending.Len() > 0 {
+		return c.pending.Read(p)
+	}
+	return c.pipeConn.Read(p)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/gorilla/websocket"
+)
+
+// selfTestMuxWindow is the self-test server's mux.window
+const selfTestMuxWindow = 32 * 1024
+
+// muxChecks drive multiplexed WebSockets and their flow control
+var muxChecks = []selfTestCheck{
+	{"mux concurrent streams", func(ctx context.Context, env *selfTestEnv) error {
+		c, err := muxDial(ctx, env)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// each stream moves several windows each way, so credit must flow
+		return concurrently(selfTestConcurrent, func() error {
+			st, err := c.open(env.echoAddr)
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+			return tunnelEcho(st, randomPayload(4*selfTestMuxWindow))
+		})
+	}},
+}
+
+// muxClient is a minimal client for ?mux=1 WebSockets
+type muxClient struct {
+	ws     *websocket.Conn
+	wmu    sync.Mutex
+	window int
+
+	mu      sync.Mutex
+	streams map[uint32]*muxClientStream
+	nextID  uint32
+}
+
+// muxDial opens a multiplexed WebSocket and reads the server settings
+func muxDial(ctx context.Context, env *selfTestEnv) (*muxClient, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/?mux=1", nil)
+	if err != nil {
+		return nil, fmt.Errorf("WS dial: %v", err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = ws.SetReadDeadline(dl)
+		_ = ws.SetWriteDeadline(dl)
+	}
+	_, rdr, err := ws.NextReader()
+	if err == nil {
+		var payload []byte
+		if _, payload, err = bridge.ReadWSFrame(rdr); err == nil {
+			typ, _, body, perr := bridge.ParseMuxControl(payload)
+			if perr != nil || typ != bridge.MuxSettings || len(body) < 8 {
+				err = fmt.Errorf("first frame is not settings")
+			} else {
+				c := &muxClient{ws: ws, window: int(binary.BigEndian.Uint32(body)), streams: make(map[uint32]*muxClientStream)}
+				go c.readLoop()
+				return c, nil
+			}
+		}
+	}
+	ws.Close()
+	return nil, fmt.Errorf("mux settings: %v", err)
+}
+
+func (c *muxClient) Close() error { return c.ws.Close() }
+
+// send writes one frame; gorilla allows a single writer at a time
+func (c *muxClient) send(streamID uint32, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return bridge.WriteWSFrame(c.ws, streamID, p)
+}
+
+func (c *muxClient) control(typ byte, streamID uint32, body []byte) error {
+	return c.send(0, bridge.MuxControl(typ, streamID, body))
+}
+
+// open starts a stream to target with the server's initial window
+func (c *muxClient) open(target string) (*muxClientStream, error) {
+	c.mu.Lock()
+	c.nextID++
+	st := &muxClientStream{c: c, id: c.nextID, credit: c.window, changed: make(chan struct{})}
+	c.streams[st.id] = st
+	c.mu.Unlock()
+	return st, c.control(bridge.MuxOpen, st.id, []byte(target))
+}
+
+func (c *muxClient) readLoop() {
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, st := range c.streams {
+			st.update(func() { st.closed = true })
+		}
+	}()
+	for {
+		_, rdr, err := c.ws.NextReader()
+		if err != nil {
+			return
+		}
+		id, payload, err := bridge.ReadWSFrame(rdr)
+		if err != nil {
+			return
+		}
+		if id != 0 {
+			if st := c.stream(id); st != nil {
+				st.update(func() { st.buf.Write(payload) })
+			}
+			continue
+		}
+		typ, sid, body, err := bridge.ParseMuxControl(payload)
+		if err != nil {
+			return
+		}
+		st := c.stream(sid)
+		if st == nil {
+			continue
+		}
+		switch typ {
+		case bridge.MuxWindow:
+			st.update(func() { st.credit += int(binary.BigEndian.Uint32(body)) })
+		case bridge.MuxEOF:
+			st.update(func() { st.closed = true })
+		case bridge.MuxClose:
+			st.update(func() { st.closed, st.reason = true, errcode.FromMux(body) })
+		}
+	}
+}
+
+func (c *muxClient) stream(id uint32) *muxClientStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[id]
+}
+
+// muxClientStream buffers downstream data until Read, which returns the
+// credit; Write waits for credit from the server
+type muxClientStream struct {
+	c  *muxClient
+	id uint32
+
+	mu      sync.Mutex
+	changed chan struct{}
+	buf     bytes.Buffer
+	credit  int
+	closed  bool
+	reason  errcode.Code // from the server's MuxClose
+}
+
+func (st *muxClientStream) update(fn func()) {
+	st.mu.Lock()
+	fn()
+	close(st.changed)
+	st.changed = make(chan struct{})
+	st.mu.Unlock()
+}
+
+// wait blocks until cond holds; callers hold mu
+func (st *muxClientStream) wait(cond func() bool) {
+	for !cond() {
+		ch := st.changed
+		st.mu.Unlock()
+		<-ch
+		st.mu.Lock()
+	}
+}
+
+func (st *muxClientStream) buffered() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.buf.Len()
+}
+
+func (st *muxClientStream) closeReason() errcode.Code {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.reason
+}
+
+func (st *muxClientStream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	st.wait(func() bool { return st.buf.Len() > 0 || st.closed })
+	if st.buf.Len() == 0 {
+		st.mu.Unlock()
+		return 0, io.EOF
+	}
+	n, _ := st.buf.Read(p)
+	st.mu.Unlock()
+	return n, st.c.control(bridge.MuxWindow, st.id, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (st *muxClientStream) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		st.mu.Lock()
+		st.wait(func() bool { return st.credit > 0 || st.closed })
+		if st.closed {
+			st.mu.Unlock()
+			return n, errors.New("stream closed")
+		}
+		k := min(st.credit, len(p)-n, selfTestChunk)
+		st.credit -= k
+		st.mu.Unlock()
+		if err := st.c.send(st.id, p[n:n+k]); err != nil {
+			return n, err
+		}
+		n += k
+	}
+	return n, nil
+}
+
+func (st *muxClientStream) Close() error {
+	return st.c.control(bridge.MuxClose, st.id, nil)
+}
+
+func (st *muxClientStream) CloseWrite() error {
+	return st.c.control(bridge.MuxEOF, st.id, nil)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// selfTestPollBuffer is the self-test server's poll.buffer_size
+const selfTestPollBuffer = 64 * 1024
+
+// pollChecks drive the HTTP fallback transport
+var pollChecks = []selfTestCheck{
+	{"poll sse echo", func(ctx context.Context, env *selfTestEnv) error {
+		id, err := pollOpen(ctx, env, env.echoAddr, http.StatusCreated)
+		if err != nil {
+			return err
+		}
+		defer pollDelete(env, id)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, env.httpURL+"/poll/"+id, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// four times the session buffer: only moves if the reader acks
+		payload := randomPayload(4 * selfTestPollBuffer)
+		werr := make(chan error, 1)
+		go func() {
+			for seq, off := 0, 0; off < len(payload); seq, off = seq+1, off+selfTestChunk {
+				if err := pollSend(ctx, env, id, seq, payload[off:min(off+selfTestChunk, len(payload))]); err != nil {
+					werr <- err
+					return
+				}
+			}
+			werr <- nil
+		}()
+		got, err := readSSE(resp.Body, len(payload), func(off int) error {
+			return pollAck(ctx, env, id, off)
+		})
+		if err != nil {
+			return err
+		}
+		if err := <-werr; err != nil {
+			return err
+		}
+		if !bytes.Equal(got, payload) {
+			return fmt.Errorf("SSE echo mismatch")
+		}
+		return nil
+	}},
+}
+
+// pollOpen creates a session to target and expects status
+func pollOpen(ctx context.Context, env *selfTestEnv, target string, status int) (string, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, env.httpURL+"/poll?target="+url.QueryEscape(target), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		return "", fmt.Errorf("open: status %d, want %d", resp.StatusCode, status)
+	}
+	if status != http.StatusCreated {
+		return "", nil
+	}
+	var v struct {
+		Session string `json:"session"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", err
+	}
+	return v.Session, nil
+}
+
+// pollSend POSTs one upstream body, retrying while the buffer is full
+func pollSend(ctx context.Context, env *selfTestEnv, id string, seq int, data []byte) error {
+	u := env.httpURL + "/poll/" + id + "?seq=" + strconv.Itoa(seq)
+	for {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNoContent:
+			return nil
+		case http.StatusServiceUnavailable:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Millisecond):
+			}
+		default:
+			return fmt.Errorf("POST seq %d: status %d", seq, resp.StatusCode)
+		}
+	}
+}
+
+// pollAck confirms downstream bytes before off with an empty POST
+func pollAck(ctx context.Context, env *selfTestEnv, id string, off int) error {
+	u := env.httpURL + "/poll/" + id + "?ack=" + strconv.Itoa(off)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("ack %d: status %d", off, resp.StatusCode)
+	}
+	return nil
+}
+
+func pollDelete(env *selfTestEnv, id string) error {
+	req, _ := http.NewRequest(http.MethodDelete, env.httpURL+"/poll/"+id, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("DELETE: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// readSSE decodes base64 data events until n bytes have arrived, calling
+// ack with the offset after each event
+func readSSE(r io.Reader, n int, ack func(off int) error) ([]byte, error) {
+	var got []byte
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for len(got) < n && sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("SSE data: %v", err)
+		}
+		got = append(got, b...)
+		if err := ack(len(got)); err != nil {
+			return nil, err
+		}
+	}
+	if len(got) < n {
+		return nil, fmt.Errorf("SSE stream ended after %d/%d bytes: %v", len(got), n, sc.Err())
+	}
+	return got, nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// route settings used by startSelfTestServer
+const (
+	selfTestRoute       = "/echo"
+	selfTestToken       = "selftest-token"
+	selfTestOrigin      = "https://app.example"
+	selfTestSubprotocol = "anylink.v1"
+)
+
+// routeDialer offers the route's subprotocol
+var routeDialer = &websocket.Dialer{Subprotocols: []string{selfTestSubprotocol}}
+
+// routeHeader returns handshake headers that satisfy the self-test route
+func routeHeader() http.Header {
+	return http.Header{
+		"Authorization": {"Bearer " + selfTestToken},
+		"Origin":        {selfTestOrigin},
+	}
+}
+
+// routeChecks exercise a fixed-target WS endpoint from cfg.Routes
+var routeChecks = []selfTestCheck{
+	{"ws route echo", func(ctx context.Context, env *selfTestEnv) error {
+		ws, _, err := routeDialer.DialContext(ctx, env.wsURL+selfTestRoute, routeHeader())
+		if err != nil {
+			return fmt.Errorf("WS dial: %v", err)
+		}
+		defer ws.Close()
+		if ws.Subprotocol() != selfTestSubprotocol {
+			return fmt.Errorf("negotiated subprotocol %q, want %q", ws.Subprotocol(), selfTestSubprotocol)
+		}
+		return wsEchoConn(ctx, ws, randomPayload(64))
+	}},
+}
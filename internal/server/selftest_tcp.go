@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// tcpChecks drive the raw TCP entry points
+var tcpChecks = []selfTestCheck{
+	{"tcp header echo", func(ctx context.Context, env *selfTestEnv) error {
+		c, err := tcpDial(ctx, env.tcpAddr)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// payload in the same segment as the header must not be lost
+		payload := randomPayload(64)
+		if _, err := c.Write(append([]byte(env.echoAddr+"\n"), payload...)); err != nil {
+			return err
+		}
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(c, got); err != nil {
+			return err
+		}
+		if string(got) != string(payload) {
+			return fmt.Errorf("echo mismatch")
+		}
+		return connEcho(c, randomPayload(selfTestLargeSize))
+	}},
+	{"tcp route echo", func(ctx context.Context, env *selfTestEnv) error {
+		c, err := tcpDial(ctx, env.tcpRoute)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		return connEcho(c, randomPayload(64))
+	}},
+}
+
+func tcpDial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func connEcho(c net.Conn, payload []byte) error {
+	_ = c.SetDeadline(time.Now().Add(selfTestTimeout))
+	if _, err := c.Write(payload); err != nil {
+		return err
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(c, got); err != nil {
+		return err
+	}
+	if string(got) != string(payload) {
+		return fmt.Errorf("echo mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// udpChecks relay datagrams to a UDP echo backend over every transport
+var udpChecks = []selfTestCheck{
+	{"ws udp echo", func(ctx context.Context, env *selfTestEnv) error {
+		ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/udp/"+env.udpEcho, nil)
+		if err != nil {
+			return fmt.Errorf("WS dial: %v", err)
+		}
+		defer ws.Close()
+		if dl, ok := ctx.Deadline(); ok {
+			_ = ws.SetReadDeadline(dl)
+		}
+		// one message per datagram, boundaries kept
+		for _, size := range []int{1, 512, 1400} {
+			payload := randomPayload(size)
+			if err := bridge.WriteWSFrame(ws, 1, payload); err != nil {
+				return err
+			}
+			_, rdr, err := ws.NextReader()
+			if err != nil {
+				return fmt.Errorf("WS read: %v", err)
+			}
+			_, got, err := bridge.ReadWSFrame(rdr)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, payload) {
+				return fmt.Errorf("datagram of %d bytes came back as %d bytes", size, len(got))
+			}
+		}
+		return nil
+	}},
+	{"quic udp datagrams", func(ctx context.Context, env *selfTestEnv) error {
+		return withQUIC(ctx, env, func(conn quic.Connection) error {
+			stream, err := openUDPStream(ctx, conn, env.udpEcho)
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			flow := quicvarint.Append(nil, uint64(stream.StreamID())/4)
+
+			// the first datagram goes over the stream: datagrams sent before
+			// the server has read the target line would be dropped
+			first := randomPayload(64)
+			if _, err := stream.Write(bridge.AppendUDPRecord(nil, first)); err != nil {
+				return err
+			}
+			if err := expectDatagram(ctx, conn, flow, first); err != nil {
+				return err
+			}
+			next := randomPayload(64)
+			if err := conn.SendMessage(append(flow, next...)); err != nil {
+				return err
+			}
+			return expectDatagram(ctx, conn, flow, next)
+		})
+	}},
+	{"tcp udp records", func(ctx context.Context, env *selfTestEnv) error {
+		c, err := tcpDial(ctx, env.tcpAddr)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		_ = c.SetDeadline(time.Now().Add(selfTestTimeout))
+		payload := randomPayload(256)
+		msg := bridge.AppendUDPRecord([]byte(bridge.UDPPrefix+env.udpEcho+"\n"), payload)
+		if _, err := c.Write(msg); err != nil {
+			return err
+		}
+		got, err := bridge.ReadUDPRecord(c)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, payload) {
+			return fmt.Errorf("echo mismatch")
+		}
+		return nil
+	}},
+}
+
+// openUDPStream opens a QUIC stream carrying a UDP flow to target
+func openUDPStream(ctx context.Context, conn quic.Connection, target string) (quic.Stream, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("QUIC stream: %v", err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+	if _, err := stream.Write([]byte(bridge.UDPPrefix + target + "\n")); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// expectDatagram waits for payload as a QUIC datagram on flow
+func expectDatagram(ctx context.Context, conn quic.Connection, flow, payload []byte) error {
+	msg, err := conn.ReceiveMessage(ctx)
+	if err != nil {
+		return fmt.Errorf("QUIC datagram: %v", err)
+	}
+	if !bytes.HasPrefix(msg, flow) || !bytes.Equal(msg[len(flow):], payload) {
+		return fmt.Errorf("unexpected datagram (%d bytes)", len(msg))
+	}
+	return nil
+}
+
+// startUDPEchoServer echoes every datagram back to its sender
+func startUDPEchoServer() (net.PacketConn, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc, nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+// webTransportChecks run a QUIC stream echo through an HTTP/3
+// WebTransport session on the same listener
+var webTransportChecks = []selfTestCheck{
+	{"webtransport echo", func(ctx context.Context, env *selfTestEnv) error {
+		return withWebTransport(ctx, env, func(sess *webtransport.Session) error {
+			return wtEcho(ctx, sess, env.echoAddr, randomPayload(64*1024))
+		})
+	}},
+}
+
+// withWebTransport opens a WebTransport session to the QUIC listener for fn
+func withWebTransport(ctx context.Context, env *selfTestEnv, fn func(*webtransport.Session) error) error {
+	d := &webtransport.Dialer{RoundTripper: &http3.RoundTripper{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	defer d.Close()
+	_, sess, err := d.Dial(ctx, "https://"+env.quicAddr+"/", nil)
+	if err != nil {
+		return fmt.Errorf("WebTransport dial: %v", err)
+	}
+	defer sess.CloseWithError(0, "selftest done")
+	return fn(sess)
+}
+
+func wtStream(ctx context.Context, sess *webtransport.Session) (webtransport.Stream, error) {
+	stream, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("WebTransport stream: %v", err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+	return stream, nil
+}
+
+// wtEcho is quicEcho over a WebTransport stream
+func wtEcho(ctx context.Context, sess *webtransport.Session, target string, payload []byte) error {
+	stream, err := wtStream(ctx, sess)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return streamEcho(stream, target, payload)
+}
@@ -2,126 +2,155 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/DanielcoderX/anylink/errcode"
 	"github.com/DanielcoderX/anylink/internal/bridge"
 	"github.com/DanielcoderX/anylink/internal/config"
 	"github.com/DanielcoderX/anylink/internal/logger"
+	"github.com/DanielcoderX/anylink/internal/policy"
+	"github.com/DanielcoderX/anylink/internal/recording"
+	"github.com/DanielcoderX/anylink/internal/tracing"
 	"github.com/gorilla/websocket"
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// ----- Target Validation -----
-
-type TargetRule struct {
-	Raw      string
-	Regex    *regexp.Regexp
-	CIDRNet  *net.IPNet
-	IsDomain bool
-}
-
-// compileRules prepares target rules (CIDR, regex, domain)
-func compileRules(list []string) ([]*TargetRule, error) {
-	var rules []*TargetRule
-	for _, t := range list {
-		r := &TargetRule{Raw: t}
-		if strings.Contains(t, "/") { // CIDR
-			_, cidrNet, err := net.ParseCIDR(t)
-			if err != nil {
-				return nil, err
-			}
-			r.CIDRNet = cidrNet
-		} else if strings.ContainsAny(t, "*?") { // wildcard domain -> regex
-			pattern := "^" + regexp.QuoteMeta(t) + "$"
-			pattern = strings.ReplaceAll(pattern, `\*`, ".*")
-			reg, err := regexp.Compile(pattern)
-			if err != nil {
-				return nil, err
-			}
-			r.Regex = reg
-			r.IsDomain = true
-		} else if strings.Contains(t, ":") { // IP:Port exact match
-			// nothing needed, compare directly
-		} else { // domain exact
-			r.IsDomain = true
-		}
-		rules = append(rules, r)
-	}
-	return rules, nil
-}
-
-// isAllowedEnhanced checks a target against compiled rules
-func isAllowedEnhanced(rules []*TargetRule, target string) bool {
-	if len(rules) == 0 {
-		return true
-	}
-	host, _, _ := net.SplitHostPort(target)
-	for _, r := range rules {
-		if r.CIDRNet != nil {
-			ip := net.ParseIP(host)
-			if ip != nil && r.CIDRNet.Contains(ip) {
-				return true
-			}
-		} else if r.Regex != nil {
-			if r.Regex.MatchString(target) {
-				return true
-			}
-		} else if r.IsDomain {
-			if host == r.Raw {
-				return true
-			}
-		} else {
-			if target == r.Raw {
-				return true
-			}
-		}
-	}
-	return false
-}
-
 type Server struct {
 	cfg  *config.Config
 	http *http.Server
 	quic *quic.Listener
-
-	tlsManager *TLSManager
-	tcpPool    *bridge.TCPPool
-	sessions   map[string]*sessionState
-	sessionsMu sync.Mutex
-	log        *logger.Logger
+	wt   *webtransport.Server // h3 connections on the QUIC listener, if enabled
+
+	tlsManager     *TLSManager
+	tcpPool        *bridge.TCPPool
+	udp            *bridge.UDPTable    // created by Listen
+	resume         *bridge.ResumeTable // nil unless resume is enabled
+	records        *recording.Store    // nil unless a route or target is recorded
+	recordTargets  *targetIndex        // recording.targets keys
+	inspectTargets *targetIndex        // inspect.targets keys
+	captures       *captureTable
+	tunnels        map[uint64]*tunnelTap // bridged tunnels by ID
+	tunnelsMu      sync.Mutex
+	tunnelSeq      atomic.Uint64
+	sessions       map[string]*sessionState
+	sessionsMu     sync.Mutex
+	polls          map[string]*pollSession // HTTP fallback sessions by ID
+	pollsMu        sync.Mutex
+	log            *logger.Logger
+
+	policy     *policy.Policy
+	httpLn     net.Listener
+	tcpIngress []*tcpIngress // listen.tcp and tcp_routes
+	admin      *http.Server
+	adminLn    net.Listener // nil unless admin_addr is set
+	echo       net.Listener // in-process echo loop for readiness probes
+	probes     sync.Map     // local addrs of in-flight readiness probe conns
+	readyMu    sync.Mutex   // serializes readiness runs; guards ready, readyAt
+	ready      HealthReport
+	readyAt    time.Time
+	draining   atomic.Bool
+	done       chan struct{} // closed on Shutdown
 }
 
 type sessionState struct {
 	sess       quic.Connection
 	streams    map[quic.StreamID]*bridge.Bridge
-	lastActive time.Time
+	flows      map[uint64]*bridge.UDPAssoc // UDP streams by quarter stream ID
+	lastActive time.Time                   // last stream opened or ended; guarded by mu
 	mu         sync.Mutex
 }
 
 func New(cfg *config.Config) *Server {
 	tcpPool := bridge.NewTCPPool(cfg.TCPPoolSize)
+	alpn := []string{"anylink-quic"}
+	if cfg.WebTransport.Enable {
+		alpn = append(alpn, http3.NextProtoH3)
+	}
 	tlsMgr := NewTLSManager(
-		24*time.Hour,             // cert rotation every 24h
-		[]string{"anylink-quic"}, // ALPN
-		false,                    // optional client cert auth
-		nil,                      // client CAs
+		24*time.Hour, // cert rotation every 24h
+		alpn,
+		false, // optional client cert auth
+		nil,   // client CAs
 	)
-	return &Server{
+	s := &Server{
 		cfg:        cfg,
 		tlsManager: tlsMgr,
 		tcpPool:    tcpPool,
 		sessions:   make(map[string]*sessionState),
+		polls:      make(map[string]*pollSession),
+		tunnels:    make(map[uint64]*tunnelTap),
 		log:        logger.New("server"),
+		done:       make(chan struct{}),
 	}
+	s.captures = newCaptureTable(s)
+	return s
 }
 
 // Start runs WS HTTP server and QUIC listener
 func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen binds the HTTP and QUIC listeners and starts accepting QUIC sessions.
+// Serve must be called afterwards to handle HTTP.
+func (s *Server) Listen() error {
+	pol, err := policy.FromConfig(s.cfg)
+	if err != nil {
+		return fmt.Errorf("target policy: %w", err)
+	}
+	s.policy = pol
+	if err := s.openRecordings(); err != nil {
+		return err
+	}
+	if err := s.checkInspectors(); err != nil {
+		return err
+	}
+
+	for name, svc := range s.cfg.Services {
+		if svc.HashOn != "" && svc.HashOn != "client_ip" && !strings.HasPrefix(svc.HashOn, "header:") {
+			return fmt.Errorf("service %s: invalid hash_on %q", name, svc.HashOn)
+		}
+		if svc.DNS != "" {
+			if len(svc.Backends) > 0 {
+				return fmt.Errorf("service %s: set either backends or dns, not both", name)
+			}
+			d := s.cfg.Discovery
+			err := s.tcpPool.AddDiscoveredService(name, bridge.DiscoveryOptions{
+				Name:     svc.DNS,
+				Type:     svc.DNSType,
+				Port:     svc.Port,
+				Resolver: d.Resolver,
+				MinTTL:   d.MinTTL,
+				MaxTTL:   d.MaxTTL,
+			}, svc.Policy)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		backends := make([]bridge.Backend, len(svc.Backends))
+		for i, b := range svc.Backends {
+			backends[i] = bridge.Backend{Addr: b.Addr, Weight: b.Weight}
+		}
+		if err := s.tcpPool.AddService(name, backends, svc.Policy); err != nil {
+			return err
+		}
+	}
+
 	// ----- WebSocket handler -----
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  8192,
@@ -130,49 +159,108 @@ func (s *Server) Start() error {
 	}
 
 	mux := http.NewServeMux()
-	rules, _ := compileRules(s.cfg.AllowedTargets)
+
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		target, ok := extractTarget(r)
+		// continue the caller's trace if a traceparent header was sent
+		ctx, span := tracing.Tracer().Start(tracing.Extract(r.Context(), r.Header), "ws.tunnel",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(tracing.AttrTransport.String("ws")))
+		defer span.End()
+
+		if s.cfg.RoutesOnly {
+			span.SetStatus(codes.Error, "no such route")
+			http.NotFound(w, r)
+			return
+		}
+		if token, ok := s.resumeToken(r); ok {
+			s.resumeWS(ctx, span, w, r, &upgrader, token, nil)
+			return
+		}
+		if s.muxRequested(r) {
+			span.SetAttributes(tracing.AttrTransport.String("ws-mux"))
+			s.tunnelWSMux(ctx, span, w, r, &upgrader)
+			return
+		}
+		target, ok := extractTarget(r, s.tcpPool.HasTarget)
 		if !ok {
+			span.SetStatus(codes.Error, "missing target")
 			http.Error(w, "missing target", http.StatusBadRequest)
 			return
 		}
-		if !isAllowedEnhanced(rules, target) {
-			http.Error(w, "target not allowed", http.StatusForbidden)
-			return
+		span.SetAttributes(tracing.AttrTarget.String(target))
+		hostport, isUDP := udpTarget(target)
+		var addrs []string
+		var err error
+		if isUDP {
+			addrs, err = s.authorizeUDP(ctx, hostport)
+		} else {
+			addrs, err = s.authorizeTarget(ctx, target)
 		}
-
-		ws, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			s.log.Error("WS upgrade error: %v", err)
+			status, msg := http.StatusForbidden, "target not allowed"
+			if !errors.Is(err, policy.ErrDenied) {
+				status, msg = http.StatusBadGateway, "cannot resolve target"
+			}
+			s.log.Debug("refusing %s: %v", target, err)
+			span.SetStatus(codes.Error, msg)
+			http.Error(w, msg, status)
 			return
 		}
-		defer ws.Close()
 
-		tcpConn, err := s.tcpPool.Get(target)
-		if err != nil {
-			s.log.Error("dial %s: %v", target, err)
-			_ = ws.WriteMessage(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "connect failed"))
+		if isUDP {
+			s.tunnelWSUDP(ctx, span, w, r, &upgrader, target, addrs)
 			return
 		}
-		defer s.tcpPool.Put(target, tcpConn)
-
-		b := bridge.NewWSBridge(ws, tcpConn, &bridge.Config{ReadTimeout: s.cfg.ReadTimeout})
-		defer b.Close()
-		b.Wg().Wait()
+		s.tunnelWS(ctx, span, w, r, &upgrader, target, addrs, nil)
 	})
 
+	if s.cfg.WebTransport.Enable {
+		s.wt = s.newWebTransport()
+		mux.HandleFunc("GET /webtransport/cert-hash", s.handleCertHash)
+	}
+	if s.cfg.Poll.Enable {
+		s.registerPoll(mux)
+	}
+
+	routes, err := s.routeHandlers()
+	if err != nil {
+		return err
+	}
+	for path, h := range routes {
+		mux.Handle(path, h)
+	}
+
 	s.http = &http.Server{
 		Addr:    s.cfg.Addr,
 		Handler: mux,
 	}
+	if s.cfg.Proxy.Enable {
+		// h2c with prior knowledge: CONNECT streams share one connection
+		s.http.Handler = s.proxyHandler(mux)
+		s.http.Protocols = new(http.Protocols)
+		s.http.Protocols.SetHTTP1(true)
+		s.http.Protocols.SetHTTP2(true)
+		s.http.Protocols.SetUnencryptedHTTP2(true)
+	}
 
 	// ----- QUIC listener -----
 
 	tlsConf := s.tlsManager.GetTLSConfig()
 
+	httpLn, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.httpLn = httpLn
+
+	if err := s.listenTCP(); err != nil {
+		httpLn.Close()
+		return err
+	}
+
 	listener, err := quic.ListenAddr(
 		s.cfg.QUICAddr,
 		tlsConf,
@@ -184,26 +272,97 @@ func (s *Server) Start() error {
 			InitialConnectionReceiveWindow: 512 * 1024,
 			KeepAlivePeriod:                30 * time.Second,
 			Allow0RTT:                      true,
+			EnableDatagrams:                true, // UDP flows
 		},
 	)
 	if err != nil {
+		httpLn.Close()
+		s.closeTCP()
 		return err
 	}
 	s.quic = listener
 
+	echo, err := startEchoServer()
+	if err != nil {
+		httpLn.Close()
+		s.closeTCP()
+		listener.Close()
+		return err
+	}
+	s.echo = echo
+
+	if err := s.startAdmin(); err != nil {
+		httpLn.Close()
+		s.closeTCP()
+		listener.Close()
+		echo.Close()
+		return err
+	}
+
+	chk := s.cfg.Health.Checks
+	s.tcpPool.StartHealthChecks(bridge.HealthOptions{
+		Interval:      chk.Interval,
+		Timeout:       chk.Timeout,
+		FailThreshold: chk.FailThreshold,
+		BaseBackoff:   chk.BaseBackoff,
+		MaxBackoff:    chk.MaxBackoff,
+	})
+
+	pool := s.cfg.Pool
+	s.tcpPool.StartMaintenance(bridge.PoolOptions{
+		IdleTimeout: pool.IdleTimeout,
+		MaxLifetime: pool.MaxLifetime,
+		MaxConns:    pool.MaxConns,
+		Prewarm:     pool.Prewarm,
+	})
+
+	s.udp = bridge.NewUDPTable(s.cfg.UDP.IdleTimeout)
+	if s.cfg.Resume.Enable {
+		s.resume = bridge.NewResumeTable(s.cfg.Resume.Grace, s.cfg.Resume.BufferSize)
+	}
+
 	// QUIC accept loop
 	go s.quicAcceptLoop()
 
+	for _, in := range s.tcpIngress {
+		go s.tcpAcceptLoop(in)
+	}
+
 	// QUIC session idle cleanup
 	go s.cleanupIdleSessions()
+	if s.cfg.Poll.Enable {
+		go s.pollSweepLoop()
+	}
+	return nil
+}
 
+// Serve runs the HTTP server on the listener bound by Listen
+func (s *Server) Serve() error {
 	// HTTP server listen with TLS (WSS)
 	if s.cfg.EnableWSS {
 		s.http.TLSConfig = s.tlsManager.GetTLSConfig()
-		return s.http.ListenAndServeTLS("", "") // certs handled by TLSConfig
+		return s.http.ServeTLS(s.httpLn, "", "") // certs handled by TLSConfig
 	}
 	// run HTTP server
-	return s.http.ListenAndServe()
+	return s.http.Serve(s.httpLn)
+}
+
+// HTTPAddr returns the bound WS/HTTP address (after Listen)
+func (s *Server) HTTPAddr() net.Addr {
+	return s.httpLn.Addr()
+}
+
+// AdminAddr returns the bound admin API address, or nil if it is disabled
+func (s *Server) AdminAddr() net.Addr {
+	if s.adminLn == nil {
+		return nil
+	}
+	return s.adminLn.Addr()
+}
+
+// QUICAddr returns the bound QUIC address (after Listen)
+func (s *Server) QUICAddr() net.Addr {
+	return s.quic.Addr()
 }
 
 // QUIC accept loop
@@ -211,9 +370,17 @@ func (s *Server) quicAcceptLoop() {
 	for {
 		sess, err := s.quic.Accept(context.Background())
 		if err != nil {
-			s.log.Error("QUIC accept error: %v", err)
+			if s.draining.Load() {
+				s.log.Debug("QUIC listener closed")
+			} else {
+				s.log.Error("QUIC accept error: %v", err)
+			}
 			return
 		}
+		if s.wt != nil && sess.ConnectionState().TLS.NegotiatedProtocol == http3.NextProtoH3 {
+			go s.serveWebTransport(sess)
+			continue
+		}
 		go s.handleQUICSession(sess)
 	}
 }
@@ -223,31 +390,71 @@ func (s *Server) handleQUICSession(sess quic.Connection) {
 	st := &sessionState{
 		sess:       sess,
 		streams:    make(map[quic.StreamID]*bridge.Bridge),
+		flows:      make(map[uint64]*bridge.UDPAssoc),
 		lastActive: time.Now(),
 	}
 	s.sessionsMu.Lock()
 	s.sessions[sess.RemoteAddr().String()] = st
 	s.sessionsMu.Unlock()
 
+	if sess.ConnectionState().SupportsDatagrams {
+		go s.quicDatagramLoop(st)
+	}
+
 	for {
 		stream, err := sess.AcceptStream(context.Background())
 		if err != nil {
-			s.log.Error("QUIC stream accept error: %v", err)
+			var appErr *quic.ApplicationError
+			if errors.As(err, &appErr) && (appErr.ErrorCode == 0 || !appErr.Remote) {
+				s.log.Debug("QUIC session closed: %v", err) // clean close, e.g. readiness probe
+			} else {
+				s.log.Error("QUIC stream accept error: %v", err)
+			}
 			break
 		}
 
+		ctx, span := tracing.Tracer().Start(context.Background(), "bridge",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(tracing.AttrTransport.String("quic")))
+
 		st.mu.Lock()
-		b := bridge.NewQUICBridge(stream, nil, &bridge.Config{ReadTimeout: s.cfg.ReadTimeout})
+		cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(nil))
+		cfg.Record = func(target string, backend net.Addr) (bridge.Recorder, error) {
+			return s.tap(target, "quic", sess.RemoteAddr().String(), backend, nil)
+		}
+		cfg.Dial = reasonDial(func(target string) (net.Conn, error) {
+			span.SetAttributes(tracing.AttrTarget.String(target))
+			if hostport, ok := udpTarget(target); ok {
+				return s.openQUICFlow(ctx, st, stream.StreamID(), hostport)
+			}
+			if c, ok, err := s.dialResume(span, target, stream, func(target string) (net.Conn, error) {
+				return s.dialQUICTarget(ctx, target, sess.RemoteAddr().String())
+			}); ok {
+				return c, err
+			}
+			return s.dialQUICTarget(ctx, target, sess.RemoteAddr().String())
+		})
+		b := bridge.NewQUICBridge(stream, nil, cfg)
 		st.streams[stream.StreamID()] = b
-		st.mu.Unlock()
-
 		st.lastActive = time.Now()
+		st.mu.Unlock()
 
 		go func(stream quic.Stream, b *bridge.Bridge) {
 			b.Wg().Wait()
+			st.mu.Lock()
+			flow := uint64(stream.StreamID()) / 4
+			a := st.flows[flow]
+			delete(st.flows, flow)
+			st.mu.Unlock()
+			if a != nil {
+				endUDPSpan(span, a)
+			} else {
+				endBridgeSpan(span, b)
+			}
 			b.Close()
 			st.mu.Lock()
 			delete(st.streams, stream.StreamID())
+			st.lastActive = time.Now()
 			st.mu.Unlock()
 		}(stream, b)
 	}
@@ -259,17 +466,30 @@ func (s *Server) handleQUICSession(sess quic.Connection) {
 	sess.CloseWithError(0, "session closed")
 }
 
-// Idle session cleanup
+// cleanupIdleSessions closes QUIC connections that have had no open
+// stream for timeouts.quic_session; streams have their own idle timeout
 func (s *Server) cleanupIdleSessions() {
-	ticker := time.NewTicker(10 * time.Second)
+	idle := s.cfg.Timeouts.QUICSession
+	if idle <= 0 {
+		idle = 30 * time.Second
+	}
+	ticker := time.NewTicker(min(10*time.Second, idle/2))
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
 		now := time.Now()
 		s.sessionsMu.Lock()
 		for addr, st := range s.sessions {
-			if now.Sub(st.lastActive) > 30*time.Second {
+			st.mu.Lock()
+			expired := len(st.streams) == 0 && now.Sub(st.lastActive) > idle
+			st.mu.Unlock()
+			if expired {
 				s.log.Debug("closing idle session %s", addr)
-				st.sess.CloseWithError(0, "idle timeout")
+				st.sess.CloseWithError(quic.ApplicationErrorCode(errcode.IdleTimeout), "idle timeout")
 				delete(s.sessions, addr)
 			}
 		}
@@ -278,12 +498,40 @@ func (s *Server) cleanupIdleSessions() {
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.draining.Swap(true) {
+		close(s.done)
+	}
+	// streamed poll GETs would otherwise hold up http.Shutdown
+	s.closePolls()
 	if s.http != nil {
 		_ = s.http.Shutdown(ctx)
 	}
-	if s.quic != nilValueListener {
+	if s.admin != nil {
+		_ = s.admin.Shutdown(ctx)
+	}
+	s.captures.stopAll()
+	if s.wt != nil {
+		_ = s.wt.Close()
+	}
+	s.sessionsMu.Lock()
+	for _, st := range s.sessions {
+		st.sess.CloseWithError(quic.ApplicationErrorCode(errcode.ServerDraining), "server draining")
+	}
+	s.sessionsMu.Unlock()
+	if s.quic != nil {
 		_ = s.quic.Close()
 	}
+	s.closeTCP()
+	if s.echo != nil {
+		_ = s.echo.Close()
+	}
+	s.tcpPool.Close()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.resume != nil {
+		s.resume.Close()
+	}
 	if s.tlsManager != nil {
 		s.tlsManager.Stop()
 	}
@@ -291,9 +539,208 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 // ----- helpers -----
-func extractTarget(r *http.Request) (string, bool) {
+
+// errRoutesOnly refuses client-chosen targets when routes_only is set
+var errRoutesOnly = fmt.Errorf("%w: only configured routes are served", policy.ErrDenied)
+
+// authorizeTarget applies the target policy to a client-chosen target and
+// returns the addresses to dial. With routes_only every such target is
+// refused. Services are operator-defined and pass through unresolved; raw
+// targets are resolved once and only the approved IPs are dialed, so a
+// second DNS answer cannot redirect the connection.
+func (s *Server) authorizeTarget(ctx context.Context, target string) ([]string, error) {
+	if s.cfg.RoutesOnly {
+		return nil, errRoutesOnly
+	}
+	if _, ok := s.cfg.Services[target]; ok {
+		return []string{target}, nil
+	}
+	ctx, span := tracing.Start(ctx, "acl.check", tracing.AttrTarget.String(target))
+	defer span.End()
+
+	d, err := s.policy.Check(ctx, target)
+	if err == nil {
+		err = d.Err()
+	}
+	span.SetAttributes(tracing.AttrAllowed.Bool(err == nil), tracing.AttrResolved.StringSlice(d.Addrs()))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return d.Addrs(), nil
+}
+
+// dialTarget dials the authorized addresses of target in order through the
+// pool, behind the interceptors of target and route (nil for client-chosen
+// targets)
+func (s *Server) dialTarget(ctx context.Context, target string, addrs []string, key string, route *config.RouteConfig) (net.Conn, error) {
+	var err error
+	for _, addr := range addrs {
+		var c net.Conn
+		if c, err = s.tcpPool.GetKeyed(ctx, addr, key); err == nil {
+			return s.intercept(c, target, route), nil
+		}
+	}
+	return nil, err
+}
+
+// dialQUICTarget applies the ACL to a QUIC client's target and dials it
+// through the pool. The in-process readiness echo loop is reachable only
+// from the server's own probe connections.
+func (s *Server) dialQUICTarget(ctx context.Context, target, remoteAddr string) (net.Conn, error) {
+	if s.echo != nil && target == s.echo.Addr().String() {
+		if _, ok := s.probes.Load(remoteAddr); ok {
+			return s.tcpPool.GetKeyed(ctx, target, "")
+		}
+	}
+	addrs, err := s.authorizeTarget(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return s.dialTarget(ctx, target, addrs, s.clientKey(target, remoteAddr, nil), nil)
+}
+
+// clientKey returns the sticky-session key for consistent_hash services:
+// the configured request header (WS only) or else the client IP
+func (s *Server) clientKey(target, remoteAddr string, h http.Header) string {
+	svc, ok := s.cfg.Services[target]
+	if !ok || svc.Policy != bridge.PolicyConsistentHash {
+		return ""
+	}
+	if name, found := strings.CutPrefix(svc.HashOn, "header:"); found && h != nil {
+		if v := h.Get(name); v != "" {
+			return v
+		}
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// tunnelWS upgrades the request and bridges it to the first reachable
+// address in addrs, recording child spans under span; rt is nil for
+// client-chosen targets
+func (s *Server) tunnelWS(ctx context.Context, span trace.Span, w http.ResponseWriter, r *http.Request,
+	upgrader *websocket.Upgrader, target string, addrs []string, rt *route) {
+	route := rt.config()
+	_, upSpan := tracing.Start(ctx, "ws.upgrade")
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		upSpan.RecordError(err)
+		upSpan.SetStatus(codes.Error, "upgrade failed")
+		upSpan.End()
+		s.log.Error("WS upgrade error: %v", err)
+		return
+	}
+	upSpan.End()
+	defer ws.Close()
+
+	tcpConn, err := s.dialTarget(ctx, target, addrs, s.clientKey(target, r.RemoteAddr, r.Header), route)
+	if err != nil {
+		span.SetStatus(codes.Error, "connect failed")
+		s.log.Error("dial %s: %v", target, err)
+		closeWS(ws, closeReason(err))
+		return
+	}
+	rec, err := s.tap(target, "ws", ws.RemoteAddr().String(), tcpConn.RemoteAddr(), route)
+	if err != nil {
+		tcpConn.Close()
+		span.SetStatus(codes.Error, "recording failed")
+		s.log.Error("%v", err)
+		closeWS(ws, closeReason(err))
+		return
+	}
+	if s.resume != nil && r.URL.Query().Get("resume") == "new" {
+		att, err := s.resume.New(target, rt.key(), tcpConn)
+		if err != nil {
+			rec.Close()
+			span.SetStatus(codes.Error, "server closing")
+			closeWS(ws, errcode.ServerDraining)
+			return
+		}
+		span.SetAttributes(tracing.AttrResume.String("new"))
+		if err := bridge.WriteWSFrame(ws, 0, []byte(att.Line())); err != nil {
+			rec.Close()
+			att.Close()
+			return
+		}
+		tcpConn = att
+	}
+	s.bridgeWS(ctx, ws, tcpConn, rec, target, route, r.URL.Query().Get("halfclose") == "1")
+}
+
+// bridgeWS runs a WS bridge to tcpConn, recorded by rec, until both
+// directions have ended, with the timeouts of route
+func (s *Server) bridgeWS(ctx context.Context, ws *websocket.Conn, tcpConn net.Conn, rec bridge.Recorder, target string, route *config.RouteConfig, halfClose bool) {
+	_, bSpan := tracing.Start(ctx, "bridge", tracing.AttrTransport.String("ws"), tracing.AttrTarget.String(target))
+	cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(route))
+	cfg.HalfClose = halfClose
+	cfg.Recorder = rec
+	b := bridge.NewWSBridge(ws, tcpConn, cfg)
+	defer b.Close()
+	b.Wg().Wait()
+	endBridgeSpan(bSpan, b)
+}
+
+// bridgeConfig returns bridge settings with timeouts that end when the
+// server drains
+func (s *Server) bridgeConfig(timeouts config.TimeoutConfig) *bridge.Config {
+	return &bridge.Config{
+		IdleTimeout:  timeouts.Idle,
+		MaxDuration:  timeouts.MaxDuration,
+		PingInterval: timeouts.PingInterval,
+		Drain:        s.done,
+	}
+}
+
+// endBridgeSpan records transferred bytes and ends the bridge span
+func endBridgeSpan(span trace.Span, b *bridge.Bridge) {
+	span.SetAttributes(
+		tracing.AttrBytesSent.Int64(b.BytesSent),
+		tracing.AttrBytesRecv.Int64(b.BytesReceived),
+		tracing.AttrReason.String(b.Reason().String()),
+	)
+	span.End()
+}
+
+// closeReason classifies a failed authorization or dial for the client
+func closeReason(err error) errcode.Code {
+	switch {
+	case errors.Is(err, policy.ErrDenied):
+		return errcode.PolicyDenied
+	case errors.Is(err, bridge.ErrPoolExhausted):
+		return errcode.RateLimited
+	case errors.Is(err, bridge.ErrPoolClosed):
+		return errcode.ServerDraining
+	}
+	return errcode.Of(err, errcode.BackendRefused)
+}
+
+// reasonDial attaches closeReason to dial's errors, for bridges that tell
+// the client why a stream failed
+func reasonDial(dial func(string) (net.Conn, error)) func(string) (net.Conn, error) {
+	return func(target string) (net.Conn, error) {
+		c, err := dial(target)
+		if err != nil {
+			return nil, errcode.Wrap(closeReason(err), err)
+		}
+		return c, nil
+	}
+}
+
+// closeWS ends a WebSocket that never reached a bridge with reason
+func closeWS(ws *websocket.Conn, reason errcode.Code) {
+	_ = ws.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(reason.WSCloseCode(), reason.String()))
+}
+
+// extractTarget reads the target from the URL path (host:port or a
+// service name) or from ?target=
+func extractTarget(r *http.Request, isService func(string) bool) (string, bool) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
-	if path != "" && strings.Contains(path, ":") {
+	if path != "" && (strings.Contains(path, ":") || isService(path)) {
 		return path, true
 	}
 	if t := r.URL.Query().Get("target"); t != "" {
@@ -313,6 +760,3 @@ func isAllowed(list []string, target string) bool {
 	}
 	return false
 }
-
-// nilValueListener is a zero value for quic.Listener
-var nilValueListener interface{} = (interface{})(nil)
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/quic-go/quic-go"
+)
+
+// startTestServer runs a self-test server against a fresh echo backend
+func startTestServer(t *testing.T, tweaks ...func(*config.Config)) (*Server, *selfTestEnv) {
+	t.Helper()
+	env := newTestEnv(t)
+	srv, err := startSelfTestServer(env, false, append([]func(*config.Config){withTimeoutRoutes}, tweaks...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	env.httpURL = "http://" + srv.HTTPAddr().String()
+	env.wsURL = "ws://" + srv.HTTPAddr().String()
+	env.quicAddr = srv.QUICAddr().String()
+	tcpAddrs := srv.TCPAddrs()
+	env.tcpAddr, env.tcpRoute = tcpAddrs[0].String(), tcpAddrs[1].String()
+	return srv, env
+}
+
+// testContext bounds one test by selfTestTimeout
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// newTestEnv starts the echo backends of a self-test environment
+func newTestEnv(t *testing.T) *selfTestEnv {
+	t.Helper()
+	echoLn, err := startEchoServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echoLn.Close() })
+	deniedLn, err := startEchoServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { deniedLn.Close() })
+	deadLn, err := startEchoServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLn.Close()
+	udpLn, err := startUDPEchoServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udpLn.Close() })
+
+	return &selfTestEnv{
+		echoAddr:   echoLn.Addr().String(),
+		deniedAddr: deniedLn.Addr().String(),
+		deadAddr:   deadLn.Addr().String(),
+		udpEcho:    udpLn.LocalAddr().String(),
+	}
+}
+
+// TestSSRFLoopbackName dials a name the ACL allows but which resolves to
+// loopback: the resolved address must be refused on every transport
+func TestSSRFLoopbackName(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	target := loopbackName(env.deniedAddr)
+
+	if err := wsExpectDenied(ctx, env.wsURL+"/"+target); err != nil {
+		t.Errorf("ws: %v", err)
+	}
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		return quicExpectDenied(ctx, conn, target)
+	})
+	if err != nil {
+		t.Errorf("quic: %v", err)
+	}
+}
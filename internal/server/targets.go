@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+// targetResolveTimeout bounds the lookups of host-name keys in a targetIndex
+const targetResolveTimeout = 2 * time.Second
+
+// targetIndex finds the recording.targets or inspect.targets key of a
+// tunnel however the client spelled its target. Keys and targets are
+// compared as normalized host:port (lower case, no trailing dot, plain IPs),
+// by the backend address actually dialed, and by the addresses host-name
+// keys resolve to, so neither an IP, another DNS name nor a service
+// backend reached directly slips past a key.
+type targetIndex struct {
+	exact   map[string]string // normalized target or backend address -> key
+	names   []namedTarget     // keys with a host name, matched by their IPs
+	resolve func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+type namedTarget struct {
+	host, port, key string
+}
+
+// newTargetIndex indexes keys, which are host:port targets or names of
+// services; a service's static backends match the service's key
+func newTargetIndex(keys []string, services map[string]config.ServiceConfig,
+	resolve func(ctx context.Context, host string) ([]netip.Addr, error)) *targetIndex {
+	ix := &targetIndex{exact: make(map[string]string), resolve: resolve}
+	for _, key := range keys {
+		norm := normalizeTarget(key)
+		ix.exact[norm] = key
+		if host, port, err := net.SplitHostPort(norm); err == nil {
+			if _, err := netip.ParseAddr(host); err != nil {
+				ix.names = append(ix.names, namedTarget{host, port, key})
+			}
+		}
+	}
+	// backend aliases after the keys: a key naming the backend itself wins
+	for _, key := range keys {
+		for _, b := range services[key].Backends {
+			if norm := normalizeTarget(b.Addr); ix.exact[norm] == "" {
+				ix.exact[norm] = key
+			}
+		}
+	}
+	return ix
+}
+
+// match returns the key of a tunnel to target whose backend connection
+// goes to dialed (nil if unknown). A host-name key whose lookup fails
+// matches nothing.
+func (ix *targetIndex) match(target string, dialed net.Addr) (string, bool) {
+	if ix == nil {
+		return "", false
+	}
+	if key, ok := ix.exact[normalizeTarget(target)]; ok {
+		return key, true
+	}
+	if dialed == nil {
+		return "", false
+	}
+	ap, err := netip.ParseAddrPort(dialed.String())
+	if err != nil {
+		return "", false
+	}
+	ap = netip.AddrPortFrom(ap.Addr().WithZone("").Unmap(), ap.Port())
+	if key, ok := ix.exact[ap.String()]; ok {
+		return key, true
+	}
+	port := strconv.Itoa(int(ap.Port()))
+	for _, n := range ix.names {
+		if n.port != port {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), targetResolveTimeout)
+		ips, err := ix.resolve(ctx, n.host)
+		cancel()
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.WithZone("").Unmap() == ap.Addr() {
+				return n.key, true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeTarget lowercases the host of a host:port target, drops its
+// trailing dot and writes IPs in their plain form; other targets, such as
+// service names, are returned as they are
+func normalizeTarget(target string) string {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return target
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return net.JoinHostPort(ip.WithZone("").Unmap().String(), port)
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.ToLower(host), "."), port)
+}
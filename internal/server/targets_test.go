@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+)
+
+func TestTargetIndexMatch(t *testing.T) {
+	services := map[string]config.ServiceConfig{
+		"db": {Backends: []config.ServiceBackend{{Addr: "10.0.0.11:5432"}, {Addr: "10.0.0.12:5432"}}},
+	}
+	resolve := func(_ context.Context, host string) ([]netip.Addr, error) {
+		switch host {
+		case "cache.internal":
+			return []netip.Addr{netip.MustParseAddr("10.0.1.5")}, nil
+		case "broken.internal":
+			return nil, errors.New("no such host")
+		}
+		return nil, nil
+	}
+	ix := newTargetIndex([]string{"10.0.0.1:22", "Cache.Internal.:6379", "db", "broken.internal:80", "10.0.0.12:5432"}, services, resolve)
+	dialed := func(s string) net.Addr { return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s)) }
+
+	for _, tc := range []struct {
+		target string
+		dialed net.Addr
+		key    string
+	}{
+		{"10.0.0.1:22", nil, "10.0.0.1:22"},
+		{"[::ffff:10.0.0.1]:22", nil, "10.0.0.1:22"},
+		{"cache.internal:6379", nil, "Cache.Internal.:6379"},
+		{"CACHE.internal.:6379", nil, "Cache.Internal.:6379"},
+		// another name or the IP of a keyed name: matched by what was dialed
+		{"ssh.internal:22", dialed("10.0.0.1:22"), "10.0.0.1:22"},
+		{"10.0.1.5:6379", dialed("10.0.1.5:6379"), "Cache.Internal.:6379"},
+		{"db", dialed("10.0.0.11:5432"), "db"},
+		// a service backend reached directly belongs to the service...
+		{"10.0.0.11:5432", dialed("10.0.0.11:5432"), "db"},
+		// ...unless it has a key of its own
+		{"10.0.0.12:5432", dialed("10.0.0.12:5432"), "10.0.0.12:5432"},
+		{"10.0.1.5:6380", dialed("10.0.1.5:6380"), ""},
+		{"10.0.2.1:80", dialed("10.0.2.1:80"), ""},
+	} {
+		key, ok := ix.match(tc.target, tc.dialed)
+		if key != tc.key || ok != (tc.key != "") {
+			t.Errorf("match(%s, %v) = %q, %v; want %q", tc.target, tc.dialed, key, ok, tc.key)
+		}
+	}
+
+	var none *targetIndex
+	if _, ok := none.match("10.0.0.1:22", nil); ok {
+		t.Error("nil index matched")
+	}
+}
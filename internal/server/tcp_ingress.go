@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tcpHeaderTimeout = 10 * time.Second // time allowed to send "target\n"
+	tcpHeaderMax     = 512              // longest accepted header line
+)
+
+// tcpIngress is one raw TCP entry point. Clients of listen.tcp name their
+// target in a header line; tcp_routes listeners always use target.
+type tcpIngress struct {
+	ln     net.Listener
+	target string
+}
+
+// listenTCP binds listen.tcp and every listen.tcp_routes entry
+func (s *Server) listenTCP() error {
+	l := s.cfg.Listen
+	for _, rt := range l.TCPRoutes {
+		if err := s.checkFixedTarget(rt.Target); err != nil {
+			return fmt.Errorf("tcp route %s: %w", rt.Addr, err)
+		}
+	}
+	bind := func(addr, target string) error {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			s.closeTCP()
+			return err
+		}
+		s.tcpIngress = append(s.tcpIngress, &tcpIngress{ln: ln, target: target})
+		return nil
+	}
+	if l.TCP != "" {
+		if err := bind(l.TCP, ""); err != nil {
+			return err
+		}
+	}
+	for _, rt := range l.TCPRoutes {
+		if err := bind(rt.Addr, rt.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) closeTCP() {
+	for _, in := range s.tcpIngress {
+		in.ln.Close()
+	}
+}
+
+// TCPAddrs returns the bound TCP entry points (after Listen): listen.tcp
+// first if set, then tcp_routes in config order
+func (s *Server) TCPAddrs() []net.Addr {
+	addrs := make([]net.Addr, len(s.tcpIngress))
+	for i, in := range s.tcpIngress {
+		addrs[i] = in.ln.Addr()
+	}
+	return addrs
+}
+
+func (s *Server) tcpAcceptLoop(in *tcpIngress) {
+	for {
+		c, err := in.ln.Accept()
+		if err != nil {
+			if s.draining.Load() {
+				s.log.Debug("TCP listener %s closed", in.ln.Addr())
+			} else {
+				s.log.Error("TCP accept error: %v", err)
+			}
+			return
+		}
+		go s.handleTCP(in, c)
+	}
+}
+
+// handleTCP relays one ingress connection. Header targets go through the
+// same policy as WS and QUIC; route targets are operator-defined. Refused
+// clients are disconnected without a reply, as on QUIC.
+func (s *Server) handleTCP(in *tcpIngress, c net.Conn) {
+	defer c.Close()
+	ctx, span := tracing.Tracer().Start(context.Background(), "tcp.tunnel",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrTransport.String("tcp")))
+	defer span.End()
+
+	target, addrs, client := in.target, []string{in.target}, c
+	if target != "" {
+		span.SetAttributes(tracing.AttrRoute.String(in.ln.Addr().String()), tracing.AttrTarget.String(target))
+		if hostport, ok := udpTarget(target); ok {
+			addrs = []string{hostport}
+		}
+	} else {
+		var err error
+		if target, client, err = readTCPHeader(c); err != nil {
+			s.log.Debug("TCP client %s: %v", c.RemoteAddr(), err)
+			span.SetStatus(codes.Error, "bad header")
+			return
+		}
+		span.SetAttributes(tracing.AttrTarget.String(target))
+		if hostport, ok := udpTarget(target); ok {
+			addrs, err = s.authorizeUDP(ctx, hostport)
+		} else {
+			addrs, err = s.authorizeTarget(ctx, target)
+		}
+		if err != nil {
+			s.log.Debug("refusing %s: %v", target, err)
+			span.SetStatus(codes.Error, "target not allowed")
+			return
+		}
+	}
+
+	var backend net.Conn
+	var flow *bridge.UDPAssoc
+	var err error
+	if _, ok := udpTarget(target); ok {
+		// datagrams as [len(2B)][payload] records in both directions
+		if flow, err = s.udp.Open(c.RemoteAddr().String(), addrs); err == nil {
+			backend = bridge.NewUDPStreamConn(flow, nil)
+		}
+	} else {
+		backend, err = s.dialTarget(ctx, target, addrs, s.clientKey(target, c.RemoteAddr().String(), nil), nil)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "connect failed")
+		s.log.Error("dial %s: %v", target, err)
+		return
+	}
+
+	// no channel for a reason: a tunnel whose recording fails just closes
+	rec, err := s.tap(target, "tcp", c.RemoteAddr().String(), backend.RemoteAddr(), nil)
+	if err != nil {
+		backend.Close()
+		span.SetStatus(codes.Error, "recording failed")
+		s.log.Error("%v", err)
+		return
+	}
+
+	_, bSpan := tracing.Start(ctx, "bridge", tracing.AttrTransport.String("tcp"), tracing.AttrTarget.String(target))
+	cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(nil))
+	cfg.Recorder = rec
+	b := bridge.NewTCPBridge(client, backend, cfg)
+	defer b.Close()
+	b.Wg().Wait()
+	if flow != nil {
+		endUDPSpan(bSpan, flow)
+	} else {
+		endBridgeSpan(bSpan, b)
+	}
+}
+
+// readTCPHeader reads the "target\n" line. The returned conn replays any
+// payload the client sent after the newline.
+func readTCPHeader(c net.Conn) (string, net.Conn, error) {
+	_ = c.SetReadDeadline(time.Now().Add(tcpHeaderTimeout))
+	r := bufio.NewReaderSize(c, tcpHeaderMax)
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", nil, fmt.Errorf("header longer than %d bytes", tcpHeaderMax)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("read header: %w", err)
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	target := strings.TrimSpace(string(line))
+	if target == "" {
+		return "", nil, errors.New("empty target")
+	}
+	return target, &bufferedConn{Conn: c, r: r}, nil
+}
+
+// bufferedConn reads through r so bytes buffered past the header are kept
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// CloseWrite half-closes the client connection
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
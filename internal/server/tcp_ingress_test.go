@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTCPHeaderACLDeny(t *testing.T) {
+	_, env := startTestServer(t)
+	c, err := tcpDial(testContext(t), env.tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(env.deniedAddr + "\nping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(selfTestTimeout))
+	if n, err := c.Read(make([]byte, 4)); !errors.Is(err, io.EOF) {
+		t.Fatalf("read %d bytes, err %v; want the connection closed", n, err)
+	}
+}
+
+func TestReadTCPHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, target, rest, err string
+	}{
+		{name: "payload after header", in: " db:5432 \r\nhello", target: "db:5432", rest: "hello"},
+		{name: "empty", in: "\n", err: "empty target"},
+		{name: "too long", in: strings.Repeat("a", tcpHeaderMax+1) + "\n", err: "header longer"},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			io.WriteString(client, tc.in)
+			client.Close()
+		}()
+		target, c, err := readTCPHeader(server)
+		switch {
+		case tc.err != "":
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		default:
+			rest, _ := io.ReadAll(c)
+			if target != tc.target || string(rest) != tc.rest {
+				t.Errorf("%s: target %q, rest %q", tc.name, target, rest)
+			}
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+)
+
+// routes whose timeouts expire during the tests; the idle route pings
+// every selfTestIdleTimeout/2
+const (
+	selfTestIdleRoute   = "/idle"
+	selfTestIdleTimeout = 200 * time.Millisecond
+	selfTestExpireRoute = "/expire"
+	selfTestMaxDuration = 300 * time.Millisecond
+)
+
+// withTimeoutRoutes adds the idle and expire routes to the echo backend
+func withTimeoutRoutes(cfg *config.Config) {
+	echo := cfg.Routes[selfTestRoute].Target
+	cfg.Routes[selfTestIdleRoute] = config.RouteConfig{Target: echo, ReadTimeout: selfTestIdleTimeout}
+	cfg.Routes[selfTestExpireRoute] = config.RouteConfig{Target: echo, Timeouts: config.TimeoutConfig{MaxDuration: selfTestMaxDuration}}
+}
+
+func TestTimeoutIdleResetByTraffic(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+selfTestIdleRoute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// stay busy for several idle periods
+	for end := time.Now().Add(3 * selfTestIdleTimeout); time.Now().Before(end); {
+		if err := wsEchoConn(ctx, ws, randomPayload(64)); err != nil {
+			t.Fatalf("active tunnel: %v", err)
+		}
+		time.Sleep(selfTestIdleTimeout / 4)
+	}
+	if err := wsExpectCode(ctx, ws, errcode.IdleTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeoutWSPing(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+selfTestIdleRoute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var pings atomic.Int32
+	ws.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	// pongs keep the peer alive but are not traffic
+	if err := wsExpectCode(ctx, ws, errcode.IdleTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if pings.Load() == 0 {
+		t.Fatal("no ping within the idle timeout")
+	}
+}
+
+func TestTimeoutMaxDuration(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	start := time.Now()
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+selfTestExpireRoute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := wsEchoConn(ctx, ws, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wsExpectCode(ctx, ws, errcode.SessionExpired); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < selfTestMaxDuration {
+		t.Fatalf("tunnel expired after %v, max_duration is %v", d, selfTestMaxDuration)
+	}
+}
+
+func TestTimeoutQUICStreamAndSession(t *testing.T) {
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.Timeouts = config.TimeoutConfig{Idle: selfTestIdleTimeout, QUICSession: selfTestIdleTimeout}
+	})
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			return err
+		}
+		dl, _ := ctx.Deadline()
+		_ = stream.SetDeadline(dl)
+		if err := streamEcho(stream, env.echoAddr, randomPayload(64)); err != nil {
+			return err
+		}
+		_, err = io.ReadAll(stream)
+		if err := expectCode(err, errcode.FromQUIC, errcode.IdleTimeout); err != nil {
+			t.Errorf("stream: %v", err)
+		}
+		// with no stream left, the connection goes too
+		_, err = conn.AcceptStream(ctx)
+		if err := expectCode(err, errcode.FromQUIC, errcode.IdleTimeout); err != nil {
+			t.Errorf("session: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -67,13 +67,16 @@ func (t *TLSManager) rotationLoop() {
 
 // GetTLSConfig returns a ready-to-use TLS config for QUIC/HTTP.
 func (t *TLSManager) GetTLSConfig() *tls.Config {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
 	cfg := &tls.Config{
-		Certificates: []tls.Certificate{t.cert},
-		MinVersion:   tls.VersionTLS13,
-		NextProtos:   t.nextProtos,
+		// resolve per handshake so rotated certs reach running listeners
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+			cert := t.cert
+			return &cert, nil
+		},
+		MinVersion: tls.VersionTLS13,
+		NextProtos: t.nextProtos,
 
 		// 0-RTT support
 		SessionTicketsDisabled: false,
@@ -87,6 +90,13 @@ func (t *TLSManager) GetTLSConfig() *tls.Config {
 	return cfg
 }
 
+// Certificate returns the certificate currently served.
+func (t *TLSManager) Certificate() tls.Certificate {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.cert
+}
+
 // Stop stops the background rotation ticker.
 func (t *TLSManager) Stop() {
 	t.rotateTicker.Stop()
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWSTunnelSpans(t *testing.T) {
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "10") // export ended spans promptly
+	exp := tracetest.NewInMemoryExporter()
+	shutdown := tracing.SetupWithExporter(config.TracingConfig{Enable: true}, exp)
+	t.Cleanup(func() { shutdown(context.Background()) })
+
+	_, env := startTestServer(t)
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	h := http.Header{"Traceparent": {"00-" + traceID + "-" + parentID + "-01"}}
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, env.wsURL+"/"+env.echoAddr, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wsEchoConn(ctx, ws, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	spans := waitSpans(t, exp, "ws.tunnel", "acl.check", "ws.upgrade", "backend.dial", "bridge")
+	root := spans["ws.tunnel"]
+	if got := root.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("ws.tunnel trace ID %s, want %s", got, traceID)
+	}
+	if got := root.Parent.SpanID().String(); got != parentID || !root.Parent.IsRemote() {
+		t.Errorf("ws.tunnel parent %s (remote %v), want remote %s", got, root.Parent.IsRemote(), parentID)
+	}
+	for _, name := range []string{"acl.check", "ws.upgrade", "backend.dial", "bridge"} {
+		if got := spans[name].Parent.SpanID(); got != root.SpanContext.SpanID() {
+			t.Errorf("%s parent %s, want ws.tunnel %s", name, got, root.SpanContext.SpanID())
+		}
+	}
+
+	wantAttrs(t, spans["ws.tunnel"], tracing.AttrTransport.String("ws"), tracing.AttrTarget.String(env.echoAddr))
+	wantAttrs(t, spans["acl.check"], tracing.AttrTarget.String(env.echoAddr), tracing.AttrAllowed.Bool(true))
+	wantAttrs(t, spans["backend.dial"], tracing.AttrBackend.String(env.echoAddr), tracing.AttrPoolHit.Bool(false))
+	wantAttrs(t, spans["bridge"], tracing.AttrTransport.String("ws"), tracing.AttrBytesSent.Int64(64), tracing.AttrBytesRecv.Int64(64))
+}
+
+// waitSpans polls exp until a span with each name has been exported
+func waitSpans(t *testing.T, exp *tracetest.InMemoryExporter, names ...string) map[string]tracetest.SpanStub {
+	t.Helper()
+	deadline := time.Now().Add(selfTestTimeout)
+	for {
+		got := make(map[string]tracetest.SpanStub)
+		for _, s := range exp.GetSpans() {
+			got[s.Name] = s
+		}
+		missing := ""
+		for _, n := range names {
+			if _, ok := got[n]; !ok {
+				missing = n
+				break
+			}
+		}
+		if missing == "" {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("span %q not exported", missing)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func wantAttrs(t *testing.T, s tracetest.SpanStub, want ...attribute.KeyValue) {
+	t.Helper()
+	have := make(map[attribute.Key]attribute.Value, len(s.Attributes))
+	for _, kv := range s.Attributes {
+		have[kv.Key] = kv.Value
+	}
+	for _, kv := range want {
+		if v, ok := have[kv.Key]; !ok || v != kv.Value {
+			t.Errorf("%s: %s = %v, want %v", s.Name, kv.Key, v.Emit(), kv.Value.Emit())
+		}
+	}
+}
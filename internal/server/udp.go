@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/policy"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// udpTarget reports whether target is "udp/host:port" and returns host:port
+func udpTarget(target string) (string, bool) {
+	return strings.CutPrefix(target, bridge.UDPPrefix)
+}
+
+// authorizeUDP applies the target policy to a UDP destination. Services
+// are pools of TCP backends and cannot be used over UDP.
+func (s *Server) authorizeUDP(ctx context.Context, hostport string) ([]string, error) {
+	if _, ok := s.cfg.Services[hostport]; ok {
+		return nil, fmt.Errorf("%w: service %s is TCP only", policy.ErrDenied, hostport)
+	}
+	return s.authorizeTarget(ctx, hostport)
+}
+
+// tunnelWSUDP upgrades the request and relays one datagram per WS message
+func (s *Server) tunnelWSUDP(ctx context.Context, span trace.Span, w http.ResponseWriter, r *http.Request,
+	upgrader *websocket.Upgrader, target string, addrs []string) {
+	_, upSpan := tracing.Start(ctx, "ws.upgrade")
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		upSpan.RecordError(err)
+		upSpan.SetStatus(codes.Error, "upgrade failed")
+		upSpan.End()
+		s.log.Error("WS upgrade error: %v", err)
+		return
+	}
+	upSpan.End()
+	defer ws.Close()
+
+	a, err := s.udp.Open(r.RemoteAddr, addrs)
+	if err != nil {
+		span.SetStatus(codes.Error, "connect failed")
+		s.log.Error("open %s: %v", target, err)
+		closeWS(ws, closeReason(err))
+		return
+	}
+
+	_, bSpan := tracing.Start(ctx, "bridge", tracing.AttrTransport.String("ws"), tracing.AttrTarget.String(target))
+	b := bridge.NewWSUDPBridge(ws, a)
+	defer b.Close()
+	b.Wg().Wait()
+	endUDPSpan(bSpan, a)
+}
+
+// openQUICFlow opens the UDP association for a "udp/host:port" stream.
+// The flow ID is the quarter stream ID, as in RFC 9297: datagrams carry
+// [flow ID varint][payload]. The stream stays open for the flow's
+// lifetime and carries length-prefixed datagrams too, for peers without
+// datagram support and payloads too large for one QUIC datagram.
+func (s *Server) openQUICFlow(ctx context.Context, st *sessionState, id quic.StreamID, hostport string) (net.Conn, error) {
+	addrs, err := s.authorizeUDP(ctx, hostport)
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.udp.Open(st.sess.RemoteAddr().String(), addrs)
+	if err != nil {
+		return nil, err
+	}
+	flow := uint64(id) / 4
+
+	var reply func([]byte) bool
+	if st.sess.ConnectionState().SupportsDatagrams {
+		prefix := quicvarint.Append(nil, flow)
+		reply = func(p []byte) bool {
+			msg := append(prefix[:len(prefix):len(prefix)], p...)
+			return st.sess.SendMessage(msg) == nil
+		}
+	}
+	st.mu.Lock()
+	st.flows[flow] = a
+	st.mu.Unlock()
+	return bridge.NewUDPStreamConn(a, reply), nil
+}
+
+// quicDatagramLoop hands incoming QUIC datagrams to their flows. Datagrams
+// for unknown or closed flows are dropped, as UDP would.
+func (s *Server) quicDatagramLoop(st *sessionState) {
+	for {
+		msg, err := st.sess.ReceiveMessage(st.sess.Context())
+		if err != nil {
+			return
+		}
+		r := bytes.NewReader(msg)
+		flow, err := quicvarint.Read(r)
+		if err != nil {
+			continue
+		}
+		st.mu.Lock()
+		a := st.flows[flow]
+		st.mu.Unlock()
+		if a != nil {
+			_ = a.Send(msg[len(msg)-r.Len():])
+		}
+	}
+}
+
+// endUDPSpan records the association's datagram bytes and ends span
+func endUDPSpan(span trace.Span, a *bridge.UDPAssoc) {
+	span.SetAttributes(
+		tracing.AttrBytesSent.Int64(a.BytesSent.Load()),
+		tracing.AttrBytesRecv.Int64(a.BytesReceived.Load()),
+	)
+	span.End()
+}
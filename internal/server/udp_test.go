@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/quic-go/quic-go"
+)
+
+func TestUDPACLDeny(t *testing.T) {
+	_, env := startTestServer(t)
+	if err := wsExpectDenied(testContext(t), env.wsURL+"/udp/"+env.deniedAddr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestQUICUDPStreamFallback sends a datagram too large for a QUIC
+// datagram: the reply must come back on the stream
+func TestQUICUDPStreamFallback(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	err := withQUIC(ctx, env, func(conn quic.Connection) error {
+		stream, err := openUDPStream(ctx, conn, env.udpEcho)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		payload := randomPayload(4000)
+		if _, err := stream.Write(bridge.AppendUDPRecord(nil, payload)); err != nil {
+			return err
+		}
+		got, err := bridge.ReadUDPRecord(stream)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, payload) {
+			t.Error("echo mismatch")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
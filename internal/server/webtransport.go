@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+
+	"github.com/DanielcoderX/anylink/errcode"
+	"github.com/DanielcoderX/anylink/internal/bridge"
+	"github.com/DanielcoderX/anylink/internal/tracing"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newWebTransport builds the WebTransport server. It has no listener of
+// its own: h3 connections on the QUIC listener are handed to it.
+func (s *Server) newWebTransport() *webtransport.Server {
+	path := s.cfg.WebTransport.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.handleWebTransport)
+	return &webtransport.Server{
+		H3: http3.Server{Handler: mux},
+		// checked in handleWebTransport, where a refusal can be a 403
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+}
+
+// serveWebTransport runs HTTP/3 on an accepted h3 connection
+func (s *Server) serveWebTransport(conn quic.Connection) {
+	if err := s.wt.ServeQUICConn(conn); err != nil && !s.draining.Load() {
+		s.log.Debug("WebTransport connection %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// handleWebTransport upgrades a CONNECT request to a session and bridges
+// each bidirectional stream like a raw QUIC stream: "target\n", the
+// target policy, then the pool
+func (s *Server) handleWebTransport(w http.ResponseWriter, r *http.Request) {
+	if !originAllowed(s.cfg.WebTransport.Origins, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	sess, err := s.wt.Upgrade(w, r)
+	if err != nil {
+		s.log.Debug("WebTransport upgrade from %s: %v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer sess.CloseWithError(0, "")
+
+	// streams continue the trace of the CONNECT request, if any
+	parent := tracing.Extract(context.Background(), r.Header)
+	remote := sess.RemoteAddr().String()
+	for {
+		str, err := sess.AcceptStream(sess.Context())
+		if err != nil {
+			return
+		}
+		go s.bridgeWebTransportStream(parent, remote, str)
+	}
+}
+
+func (s *Server) bridgeWebTransportStream(parent context.Context, remote string, str webtransport.Stream) {
+	ctx, span := tracing.Tracer().Start(parent, "bridge",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrTransport.String("webtransport")))
+
+	cfg := s.bridgeConfig(s.cfg.TunnelTimeouts(nil))
+	cfg.Record = func(target string, backend net.Addr) (bridge.Recorder, error) {
+		return s.tap(target, "webtransport", remote, backend, nil)
+	}
+	cfg.Dial = reasonDial(func(target string) (net.Conn, error) {
+		span.SetAttributes(tracing.AttrTarget.String(target))
+		if hostport, ok := udpTarget(target); ok {
+			// no datagram support here: [len(2B)][payload] records only
+			addrs, err := s.authorizeUDP(ctx, hostport)
+			if err != nil {
+				return nil, err
+			}
+			a, err := s.udp.Open(remote, addrs)
+			if err != nil {
+				return nil, err
+			}
+			return bridge.NewUDPStreamConn(a, nil), nil
+		}
+		if c, ok, err := s.dialResume(span, target, str, func(target string) (net.Conn, error) {
+			return s.dialQUICTarget(ctx, target, remote)
+		}); ok {
+			return c, err
+		}
+		return s.dialQUICTarget(ctx, target, remote)
+	})
+	b := bridge.NewStreamBridge(str,
+		func(c errcode.Code) { str.CancelRead(webtransport.StreamErrorCode(c)) },
+		func(c errcode.Code) { str.CancelWrite(webtransport.StreamErrorCode(c)) },
+		nil, cfg)
+	b.Wg().Wait()
+	endBridgeSpan(span, b)
+	b.Close()
+}
+
+// handleCertHash serves the SHA-256 of the current certificate for the
+// browser's serverCertificateHashes option; it changes on every rotation
+func (s *Server) handleCertHash(w http.ResponseWriter, r *http.Request) {
+	sum := sha256.Sum256(s.tlsManager.Certificate().Leaf.Raw)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"algorithm": "sha-256",
+		"value":     base64.StdEncoding.EncodeToString(sum[:]),
+	})
+}
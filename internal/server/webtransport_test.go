@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+func TestWebTransportACLDeny(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	err := withWebTransport(ctx, env, func(sess *webtransport.Session) error {
+		stream, err := wtStream(ctx, sess)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		return streamExpectDenied(ctx, stream, env.deniedAddr)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebTransportConcurrentStreams(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	err := withWebTransport(ctx, env, func(sess *webtransport.Session) error {
+		return concurrently(selfTestConcurrent, func() error {
+			return wtEcho(ctx, sess, env.echoAddr, randomPayload(64*1024))
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebTransportCertHash(t *testing.T) {
+	_, env := startTestServer(t)
+	ctx := testContext(t)
+	resp, err := http.Get(env.httpURL + "/webtransport/cert-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got struct{ Algorithm, Value string }
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	err = withWebTransport(ctx, env, func(sess *webtransport.Session) error {
+		sum := sha256.Sum256(sess.ConnectionState().TLS.PeerCertificates[0].Raw)
+		if want := base64.StdEncoding.EncodeToString(sum[:]); got.Algorithm != "sha-256" || got.Value != want {
+			t.Errorf("cert hash %s %s, served certificate has sha-256 %s", got.Algorithm, got.Value, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebTransportOrigin(t *testing.T) {
+	_, env := startTestServer(t, func(cfg *config.Config) {
+		cfg.WebTransport.Origins = []string{selfTestOrigin}
+	})
+	ctx := testContext(t)
+	d := &webtransport.Dialer{RoundTripper: &http3.RoundTripper{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	defer d.Close()
+
+	resp, _, err := d.Dial(ctx, "https://"+env.quicAddr+"/", http.Header{"Origin": {"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin: %v, want 403", err)
+	}
+	_, sess, err := d.Dial(ctx, "https://"+env.quicAddr+"/", http.Header{"Origin": {selfTestOrigin}})
+	if err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	defer sess.CloseWithError(0, "")
+	if err := wtEcho(ctx, sess, env.echoAddr, randomPayload(64)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/DanielcoderX/anylink/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/DanielcoderX/anylink"
+
+// Span attribute keys shared by the server and bridge
+const (
+	AttrTarget    = attribute.Key("anylink.target")
+	AttrRoute     = attribute.Key("anylink.route")
+	AttrBackend   = attribute.Key("anylink.backend")
+	AttrTransport = attribute.Key("anylink.transport")
+	AttrAllowed   = attribute.Key("anylink.acl.allowed")
+	AttrResolved  = attribute.Key("anylink.acl.resolved")
+	AttrPoolHit   = attribute.Key("anylink.pool.hit")
+	AttrBytesSent = attribute.Key("anylink.bytes_sent")
+	AttrBytesRecv = attribute.Key("anylink.bytes_received")
+	AttrResume    = attribute.Key("anylink.resume") // "new" or "attach"
+	AttrReason    = attribute.Key("anylink.close_reason")
+)
+
+// Shutdown flushes and stops the tracer provider
+type Shutdown func(context.Context) error
+
+// Setup installs a global tracer provider exporting over OTLP/HTTP.
+// When tracing is disabled the global no-op provider is left in place.
+func Setup(ctx context.Context, cfg config.TracingConfig) (Shutdown, error) {
+	if !cfg.Enable {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{}
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return SetupWithExporter(cfg, exp), nil
+}
+
+// SetupWithExporter installs a global tracer provider using exp, e.g. an
+// in-memory exporter from sdk/trace/tracetest.
+func SetupWithExporter(cfg config.TracingConfig, exp sdktrace.SpanExporter) Shutdown {
+	name := cfg.ServiceName
+	if name == "" {
+		name = "anylink"
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(name),
+			semconv.ServiceVersion(config.Version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return tp.Shutdown
+}
+
+// Tracer returns the AnyLink tracer from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a span from ctx using the AnyLink tracer
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Extract returns ctx carrying the remote span context from traceparent headers
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(h))
+}
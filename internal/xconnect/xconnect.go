@@ -0,0 +1,26 @@
+// Package xconnect turns on HTTP/2 extended CONNECT (RFC 8441) in net/http.
+//
+// net/http only advertises extended CONNECT when GODEBUG contains
+// http2xconnect=1, and it reads the variable once while initializing, so
+// a //go:debug line or a Setenv in main comes too late. Importing this
+// package for its side effect sets the variable first: it depends on
+// nothing but os and strings, and Go initializes ready packages in
+// import-path order, ahead of net/http. An http2xconnect value already in
+// the environment is kept, so GODEBUG=http2xconnect=0 still disables it.
+package xconnect
+
+import (
+	"os"
+	"strings"
+)
+
+func init() {
+	godebug := os.Getenv("GODEBUG")
+	if strings.Contains(godebug, "http2xconnect=") {
+		return
+	}
+	if godebug != "" {
+		godebug += ","
+	}
+	os.Setenv("GODEBUG", godebug+"http2xconnect=1")
+}